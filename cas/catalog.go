@@ -0,0 +1,26 @@
+package cas
+
+import (
+	"context"
+	"time"
+)
+
+// CatalogEntry describes an object created through a Store.
+type CatalogEntry struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	// Source is where the data came from, typically a file path.
+	// Empty if not known.
+	Source string            `json:"source,omitempty"`
+	Time   time.Time         `json:"time"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Cataloger records objects created through a Store.
+//
+// Object keys reveal nothing about the contents, and the names of
+// the stored blobs are boxed, so a catalog is the only way to know
+// what a volume contains.
+type Cataloger interface {
+	Record(ctx context.Context, entry *CatalogEntry) error
+}
@@ -0,0 +1,87 @@
+package cas
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"strings"
+
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gocloud.dev/blob"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumError reports object data that does not match the checksum
+// the backend has for the object. The data was either corrupted in
+// transit, or is stored corrupted.
+type ChecksumError struct {
+	Key string
+	// Want is the MD5 the backend has for the object, and Got the
+	// MD5 of the data.
+	Want []byte
+	Got  []byte
+}
+
+var _ error = (*ChecksumError)(nil)
+
+func (c *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: backend has md5 %x, data has %x", c.Key, c.Want, c.Got)
+}
+
+// checkMD5 compares data to the MD5 the backend has for it. No
+// checksum to compare to passes.
+func checkMD5(key string, want []byte, data []byte) error {
+	if len(want) == 0 {
+		return nil
+	}
+	got := md5.Sum(data)
+	if !bytes.Equal(want, got[:]) {
+		return &ChecksumError{Key: key, Want: want, Got: got[:]}
+	}
+	return nil
+}
+
+// readerMD5 returns the MD5 of the whole object being read by br, as
+// reported by the backend with the read, or nil if there is none.
+//
+// Google Cloud Storage is not handled here, as its readers check
+// CRC32C themselves.
+func readerMD5(br *blob.Reader) []byte {
+	var s3obj s3.GetObjectOutput
+	if br.As(&s3obj) {
+		if s3obj.ServerSideEncryption != nil && *s3obj.ServerSideEncryption == s3.ServerSideEncryptionAwsKms ||
+			s3obj.SSECustomerAlgorithm != nil {
+			// ETag is not the MD5 of the data
+			return nil
+		}
+		return etagMD5(aws.StringValue(s3obj.ETag))
+	}
+	var azobj azblobblob.DownloadStreamResponse
+	if br.As(&azobj) {
+		if len(azobj.BlobContentMD5) > 0 {
+			return azobj.BlobContentMD5
+		}
+		return azobj.ContentMD5
+	}
+	return nil
+}
+
+// etagMD5 returns the MD5 in an S3 ETag. ETags of multipart uploads
+// are not an MD5 of the data, and give nil.
+func etagMD5(etag string) []byte {
+	etag = strings.TrimPrefix(etag, "W/")
+	etag = strings.Trim(etag, `"`)
+	if len(etag) != 2*md5.Size {
+		return nil
+	}
+	sum, err := hex.DecodeString(etag)
+	if err != nil {
+		return nil
+	}
+	return sum
+}
@@ -0,0 +1,102 @@
+package cas
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gocloud.dev/blob/fileblob"
+)
+
+func TestEtagMD5(t *testing.T) {
+	sum := md5.Sum([]byte("hello, world\n"))
+	for _, tc := range []struct {
+		etag string
+		want []byte
+	}{
+		{`"22c3683b094136c3398391ae71b20f04"`, sum[:]},
+		{`W/"22c3683b094136c3398391ae71b20f04"`, sum[:]},
+		// multipart upload
+		{`"d41d8cd98f00b204e9800998ecf8427e-3"`, nil},
+		{`"not hex, but exactly 32 bytes.."`, nil},
+		{``, nil},
+	} {
+		if g := etagMD5(tc.etag); string(g) != string(tc.want) {
+			t.Errorf("etagMD5(%q) = %x, want %x", tc.etag, g, tc.want)
+		}
+	}
+}
+
+func TestCheckMD5(t *testing.T) {
+	data := []byte("hello, world\n")
+	sum := md5.Sum(data)
+	if err := checkMD5("k", sum[:], data); err != nil {
+		t.Errorf("checkMD5: %v", err)
+	}
+	if err := checkMD5("k", nil, data); err != nil {
+		t.Errorf("no checksum must pass: %v", err)
+	}
+	var sumErr *ChecksumError
+	if err := checkMD5("k", sum[:], []byte("hello, world!")); !errors.As(err, &sumErr) {
+		t.Errorf("expected checksum error: %v", err)
+	}
+}
+
+func TestScrub(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	bucket, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatalf("OpenBucket: %v", err)
+	}
+	defer bucket.Close()
+	s := NewStore("s3kr1t", WithBucket(bucket))
+	if _, err := s.Create(ctx, strings.NewReader("hello, world\n")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	scrub := func() (results []ScrubResult) {
+		t.Helper()
+		err := Scrub(ctx, bucket, "", func(res ScrubResult) error {
+			results = append(results, res)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Scrub: %v", err)
+		}
+		if len(results) == 0 {
+			t.Fatal("nothing scrubbed")
+		}
+		return results
+	}
+	for _, res := range scrub() {
+		if res.Err != nil {
+			t.Errorf("%s: %v", res.Key, res.Err)
+		}
+	}
+
+	// corrupt a stored object behind the backend's back
+	victim := scrub()[0].Key
+	path := filepath.Join(dir, filepath.FromSlash(victim))
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf[len(buf)-1] ^= 0xff
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range scrub() {
+		var sumErr *ChecksumError
+		switch {
+		case res.Key == victim && !errors.As(res.Err, &sumErr):
+			t.Errorf("%s: expected checksum error: %v", res.Key, res.Err)
+		case res.Key != victim && res.Err != nil:
+			t.Errorf("%s: %v", res.Key, res.Err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package cas
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestIfNotExist(t *testing.T) {
+	var azOpts azblob.UploadStreamOptions
+	var uploader s3manager.Uploader
+	as := func(i interface{}) bool {
+		switch p := i.(type) {
+		case **azblob.UploadStreamOptions:
+			*p = &azOpts
+		case **s3manager.Uploader:
+			*p = &uploader
+		default:
+			return false
+		}
+		return true
+	}
+	if err := ifNotExist(as); err != nil {
+		t.Fatalf("ifNotExist: %v", err)
+	}
+
+	if azOpts.AccessConditions == nil || azOpts.AccessConditions.ModifiedAccessConditions == nil ||
+		azOpts.AccessConditions.ModifiedAccessConditions.IfNoneMatch == nil ||
+		*azOpts.AccessConditions.ModifiedAccessConditions.IfNoneMatch != azcore.ETagAny {
+		t.Errorf("no Azure precondition: %+v", azOpts.AccessConditions)
+	}
+
+	req := &request.Request{HTTPRequest: &http.Request{Header: http.Header{}}}
+	req.ApplyOptions(uploader.RequestOptions...)
+	if g, e := req.HTTPRequest.Header.Get("If-None-Match"), "*"; g != e {
+		t.Errorf("no S3 precondition: %q != %q", g, e)
+	}
+}
+
+func TestIsExistsConflict(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"s3 precondition", awserr.NewRequestFailure(awserr.New("PreconditionFailed", "", nil), http.StatusPreconditionFailed, "req"), true},
+		{"s3 concurrent", awserr.NewRequestFailure(awserr.New("ConditionalRequestConflict", "", nil), http.StatusConflict, "req"), true},
+		{"s3 other", awserr.NewRequestFailure(awserr.New("InternalError", "", nil), http.StatusInternalServerError, "req"), false},
+		{"azure exists", &azcore.ResponseError{ErrorCode: "BlobAlreadyExists", StatusCode: http.StatusConflict}, true},
+		{"azure other", &azcore.ResponseError{ErrorCode: "ServerBusy", StatusCode: http.StatusServiceUnavailable}, false},
+		{"plain", errors.New("fail for test"), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if g := isExistsConflict(tc.err); g != tc.want {
+				t.Errorf("isExistsConflict(%v) = %v", tc.err, g)
+			}
+		})
+	}
+}
+
+func TestBucketPreconditions(t *testing.T) {
+	if g, e := bucketPreconditions(memblob.OpenBucket(nil)), emulated; g != e {
+		t.Errorf("wrong support for memblob: %v != %v", g, e)
+	}
+}
+
+func TestWriteDescriptorConflict(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	first := NewStore("s3kr1t", WithBucket(bucket))
+	if err := first.InitVolume(ctx); err != nil {
+		t.Fatalf("InitVolume: %v", err)
+	}
+
+	// a client that lost the race checks the winning descriptor
+	same := NewStore("s3kr1t", WithBucket(bucket))
+	alt := &same.config.buckets[0]
+	if err := same.writeDescriptor(ctx, alt, same.wantDescriptor(alt)); err != nil {
+		t.Errorf("same settings: %v", err)
+	}
+	other := NewStore("other", WithBucket(bucket))
+	alt = &other.config.buckets[0]
+	if err := other.writeDescriptor(ctx, alt, other.wantDescriptor(alt)); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("expected ErrWrongPassphrase, got %v", err)
+	}
+	if err := first.CheckVolume(ctx); err != nil {
+		t.Errorf("descriptor was overwritten: %v", err)
+	}
+}
@@ -0,0 +1,362 @@
+package cas
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"bazil.org/plop/internal/multierr"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+var (
+	ErrWrongPassphrase = errors.New("wrong passphrase for volume")
+	ErrNoDescriptor    = errors.New("volume descriptor not found")
+	// ErrNotInitialized is returned for writes to a volume that has
+	// objects but no descriptor, as the passphrase cannot be checked.
+	// See PrepareVolume.
+	ErrNotInitialized = errors.New("volume has no descriptor to check the passphrase against, initialize it to allow writes")
+)
+
+// DescriptorMismatchError is returned when the stored volume
+// descriptor disagrees with the Store configuration.
+type DescriptorMismatchError struct {
+	// Bucket is the name of the bucket holding the descriptor.
+	Bucket     string
+	Field      string
+	Stored     string
+	Configured string
+}
+
+var _ error = (*DescriptorMismatchError)(nil)
+
+func (d *DescriptorMismatchError) Error() string {
+	return fmt.Sprintf("volume descriptor mismatch in bucket %s: %s is %s, configured as %s", d.Bucket, d.Field, d.Stored, d.Configured)
+}
+
+const (
+	// The volume descriptor is the only object stored with a
+	// well-known name, so that a wrong passphrase can be told apart
+	// from a volume that was never initialized.
+	descriptorName = "plop-volume"
+
+	contentTypeVolumeV1 = "application/x.org.bazil.plop.volume.v1"
+
+	prefixVolume = "bazil.org/plop#type/volume/v1\x00\x00\x00"
+
+	volumeFormatVersion = 1
+)
+
+func init() {
+	if len(prefixVolume) != 32 {
+		panic("bad definition of prefixVolume")
+	}
+}
+
+// descriptor is the plaintext content of the volume descriptor.
+type descriptor struct {
+	// Verifier is derived from the passphrase. The AEAD already
+	// guarantees the passphrase was right, this makes the intent
+	// explicit and survives future changes to the encryption.
+	Verifier      []byte `json:"verifier"`
+	FormatVersion int    `json:"format_version"`
+	ChunkMin      uint32 `json:"chunk_min"`
+	ChunkMax      uint32 `json:"chunk_max"`
+	ChunkAvgBits  int    `json:"chunk_average_bits"`
+	ShardBits     uint8  `json:"shard_bits"`
+	Prefix        string `json:"prefix"`
+}
+
+func (s *Store) wantDescriptor(alt *alternativeBucket) *descriptor {
+	d := &descriptor{
+		Verifier:      s.verifier,
+		FormatVersion: volumeFormatVersion,
+		ChunkMin:      s.config.chunkMin,
+		ChunkMax:      s.config.chunkMax,
+		ChunkAvgBits:  s.config.chunkAvgBits,
+		ShardBits:     alt.shardBits,
+		Prefix:        s.config.prefix,
+	}
+	return d
+}
+
+func (s *Store) sealDescriptor(d *descriptor) ([]byte, error) {
+	plaintext, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	plaintext = append([]byte(prefixVolume), plaintext...)
+	nonce := make([]byte, chacha20poly1305.NonceSizeX, chacha20poly1305.NonceSizeX+len(plaintext)+chacha20poly1305.Overhead)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("cannot get randomness: %w", err)
+	}
+	ciphertext := s.descriptorCipher.Seal(nonce, nonce, plaintext, []byte(contentTypeVolumeV1))
+	return ciphertext, nil
+}
+
+func (s *Store) openDescriptor(ciphertext []byte) (*descriptor, error) {
+	if len(ciphertext) < chacha20poly1305.NonceSizeX {
+		return nil, ErrCorruptBlob
+	}
+	nonce := ciphertext[:chacha20poly1305.NonceSizeX]
+	ciphertext = ciphertext[chacha20poly1305.NonceSizeX:]
+	plaintext, err := s.descriptorCipher.Open(nil, nonce, ciphertext, []byte(contentTypeVolumeV1))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	if len(plaintext) < len(prefixVolume) || string(plaintext[:len(prefixVolume)]) != prefixVolume {
+		return nil, ErrCorruptBlob
+	}
+	plaintext = plaintext[len(prefixVolume):]
+	var d descriptor
+	if err := json.Unmarshal(plaintext, &d); err != nil {
+		return nil, fmt.Errorf("volume descriptor: %w", err)
+	}
+	if subtle.ConstantTimeCompare(d.Verifier, s.verifier) != 1 {
+		return nil, ErrWrongPassphrase
+	}
+	return &d, nil
+}
+
+func (s *Store) readDescriptor(ctx context.Context, bucket *blob.Bucket) (*descriptor, error) {
+	br, err := bucket.NewReader(ctx, s.config.prefix+descriptorName, nil)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil, ErrNoDescriptor
+		}
+		return nil, fmt.Errorf("volume descriptor read open: %w", err)
+	}
+	defer br.Close()
+	if ct := br.ContentType(); ct != contentTypeVolumeV1 {
+		err := &UnexpectedContentTypeError{
+			ContentType: ct,
+		}
+		return nil, err
+	}
+	// descriptors are tiny, anything big is not ours
+	const maxSize = 64 * 1024
+	ciphertext, err := io.ReadAll(io.LimitReader(br, maxSize))
+	if err != nil {
+		return nil, fmt.Errorf("volume descriptor read: %w", err)
+	}
+	return s.openDescriptor(ciphertext)
+}
+
+// writeDescriptor creates the volume descriptor in the bucket. If
+// another client created one first, that one is checked against d
+// instead.
+func (s *Store) writeDescriptor(ctx context.Context, alt *alternativeBucket, d *descriptor) error {
+	ciphertext, err := s.sealDescriptor(d)
+	if err != nil {
+		return err
+	}
+	opts := &blob.WriterOptions{
+		ContentEncoding: "identity",
+		ContentType:     contentTypeVolumeV1,
+	}
+	err = writeIfNotExist(ctx, alt.bucket, s.config.prefix+descriptorName, ciphertext, opts)
+	if errors.Is(err, errExists) {
+		stored, err := s.readDescriptor(ctx, alt.bucket)
+		if err != nil {
+			return err
+		}
+		return compareDescriptor(alt.name, stored, d)
+	}
+	if err != nil {
+		return fmt.Errorf("volume descriptor write: %w", err)
+	}
+	return nil
+}
+
+func compareDescriptor(bucket string, stored, want *descriptor) error {
+	check := func(field string, stored, want string) error {
+		if stored == want {
+			return nil
+		}
+		return &DescriptorMismatchError{
+			Bucket:     bucket,
+			Field:      field,
+			Stored:     stored,
+			Configured: want,
+		}
+	}
+	if err := check("format version", strconv.Itoa(stored.FormatVersion), strconv.Itoa(want.FormatVersion)); err != nil {
+		return err
+	}
+	if err := check("chunker min", strconv.FormatUint(uint64(stored.ChunkMin), 10), strconv.FormatUint(uint64(want.ChunkMin), 10)); err != nil {
+		return err
+	}
+	if err := check("chunker max", strconv.FormatUint(uint64(stored.ChunkMax), 10), strconv.FormatUint(uint64(want.ChunkMax), 10)); err != nil {
+		return err
+	}
+	if err := check("chunker average bits", strconv.Itoa(stored.ChunkAvgBits), strconv.Itoa(want.ChunkAvgBits)); err != nil {
+		return err
+	}
+	if err := check("shard bits", strconv.Itoa(int(stored.ShardBits)), strconv.Itoa(int(want.ShardBits))); err != nil {
+		return err
+	}
+	if err := check("prefix", strconv.Quote(stored.Prefix), strconv.Quote(want.Prefix)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CheckVolume verifies the Store configuration against the volume
+// descriptor stored in the buckets.
+//
+// It returns ErrWrongPassphrase if the descriptor cannot be opened,
+// and a *DescriptorMismatchError if a setting disagrees. If no bucket
+// has a descriptor, the error is ErrNoDescriptor; callers may choose
+// to accept volumes created before descriptors existed.
+//
+// Buckets that cannot be reached are not considered a failure as
+// long as at least one bucket had a matching descriptor.
+func (s *Store) CheckVolume(ctx context.Context) error {
+	var errs []error
+	found := false
+	for idx := range s.config.buckets {
+		alt := &s.config.buckets[idx]
+		d, err := s.readDescriptor(ctx, alt.bucket)
+		if errors.Is(err, ErrNoDescriptor) {
+			continue
+		}
+		if errors.Is(err, ErrWrongPassphrase) {
+			return err
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("bucket %s: %w", alt.name, err))
+			continue
+		}
+		if err := compareDescriptor(alt.name, d, s.wantDescriptor(alt)); err != nil {
+			return err
+		}
+		found = true
+	}
+	if found {
+		return nil
+	}
+	if len(errs) > 0 {
+		return multierr.New(errs)
+	}
+	return ErrNoDescriptor
+}
+
+// InitVolume writes the volume descriptor to every bucket that does
+// not have one yet. Existing descriptors are checked like in
+// CheckVolume.
+func (s *Store) InitVolume(ctx context.Context) error {
+	for idx := range s.config.buckets {
+		alt := &s.config.buckets[idx]
+		want := s.wantDescriptor(alt)
+		d, err := s.readDescriptor(ctx, alt.bucket)
+		if errors.Is(err, ErrNoDescriptor) {
+			err := s.writeDescriptor(ctx, alt, want)
+			var mismatch *DescriptorMismatchError
+			if errors.Is(err, ErrWrongPassphrase) || errors.As(err, &mismatch) {
+				return err
+			}
+			if err != nil {
+				return fmt.Errorf("bucket %s: %w", alt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("bucket %s: %w", alt.name, err)
+		}
+		if err := compareDescriptor(alt.name, d, want); err != nil {
+			return err
+		}
+	}
+	s.uninitialized.Store(false)
+	s.pendingInit.Store(false)
+	return nil
+}
+
+// PrepareVolume checks the volume descriptor like CheckVolume, and
+// decides whether the store may write. It only reads the buckets, so
+// it works with read-only credentials.
+//
+// If there is no descriptor, the first write decides. A volume
+// without any objects is then initialized, as with InitVolume, so
+// merely opening an empty volume does not fix its passphrase. A
+// volume that has objects but no descriptor was created before
+// descriptors existed, and the passphrase cannot be checked; writes
+// fail with ErrNotInitialized until InitVolume is called, so a wrong
+// passphrase cannot store objects under the wrong key. Reads with a
+// wrong passphrase fail on their own.
+func (s *Store) PrepareVolume(ctx context.Context) error {
+	err := s.CheckVolume(ctx)
+	if errors.Is(err, ErrNoDescriptor) {
+		s.pendingInit.Store(true)
+		return nil
+	}
+	return err
+}
+
+// isEmpty reports whether no bucket has objects under the prefix of
+// the store.
+func (s *Store) isEmpty(ctx context.Context) (bool, error) {
+	for idx := range s.config.buckets {
+		alt := &s.config.buckets[idx]
+		page, _, err := alt.bucket.ListPage(ctx, blob.FirstPageToken, 1, &blob.ListOptions{
+			Prefix: s.config.prefix,
+		})
+		if err != nil {
+			return false, fmt.Errorf("bucket %s: %w", alt.name, err)
+		}
+		if len(page) > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// checkWritable returns ErrNotInitialized if the store may not
+// write, initializing the volume first if needed. See PrepareVolume.
+func (s *Store) checkWritable(ctx context.Context) error {
+	if s.pendingInit.Load() {
+		if err := s.initOnWrite(ctx); err != nil {
+			return err
+		}
+	}
+	if s.uninitialized.Load() {
+		return ErrNotInitialized
+	}
+	return nil
+}
+
+// initOnWrite initializes an empty volume on its first write, or
+// marks a volume with objects as uninitialized.
+func (s *Store) initOnWrite(ctx context.Context) error {
+	s.initMu.Lock()
+	defer s.initMu.Unlock()
+	if !s.pendingInit.Load() {
+		// another write decided
+		return nil
+	}
+	// another client may have initialized the volume since it was
+	// opened
+	if err := s.CheckVolume(ctx); !errors.Is(err, ErrNoDescriptor) {
+		if err == nil {
+			s.pendingInit.Store(false)
+		}
+		return err
+	}
+	empty, err := s.isEmpty(ctx)
+	if err != nil {
+		return err
+	}
+	if empty {
+		return s.InitVolume(ctx)
+	}
+	s.uninitialized.Store(true)
+	s.pendingInit.Store(false)
+	return nil
+}
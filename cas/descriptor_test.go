@@ -0,0 +1,188 @@
+package cas_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"bazil.org/plop/cas"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestVolumeNoDescriptor(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	s := cas.NewStore("s3kr1t", cas.WithBucket(b))
+	if err := s.CheckVolume(ctx); !errors.Is(err, cas.ErrNoDescriptor) {
+		t.Fatalf("expected ErrNoDescriptor, got %v", err)
+	}
+}
+
+func TestVolumeInit(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	s := cas.NewStore("s3kr1t", cas.WithBucket(b))
+	if err := s.InitVolume(ctx); err != nil {
+		t.Fatalf("InitVolume: %v", err)
+	}
+	checkBucket(t, b, "plop-volume")
+	if err := s.CheckVolume(ctx); err != nil {
+		t.Fatalf("CheckVolume: %v", err)
+	}
+	// initializing again is harmless
+	if err := s.InitVolume(ctx); err != nil {
+		t.Fatalf("InitVolume again: %v", err)
+	}
+}
+
+func TestVolumeWrongPassphrase(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	s := cas.NewStore("s3kr1t", cas.WithBucket(b))
+	if err := s.InitVolume(ctx); err != nil {
+		t.Fatalf("InitVolume: %v", err)
+	}
+	wrong := cas.NewStore("hunter2", cas.WithBucket(b))
+	if err := wrong.CheckVolume(ctx); !errors.Is(err, cas.ErrWrongPassphrase) {
+		t.Fatalf("expected ErrWrongPassphrase, got %v", err)
+	}
+	if err := wrong.InitVolume(ctx); !errors.Is(err, cas.ErrWrongPassphrase) {
+		t.Fatalf("expected ErrWrongPassphrase, got %v", err)
+	}
+}
+
+func TestVolumeMismatch(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	s := cas.NewStore("s3kr1t", cas.WithBucket(b))
+	if err := s.InitVolume(ctx); err != nil {
+		t.Fatalf("InitVolume: %v", err)
+	}
+
+	t.Run("chunker", func(t *testing.T) {
+		other := cas.NewStore("s3kr1t",
+			cas.WithBucket(b),
+			cas.WithChunkGoal(1024*1024),
+		)
+		err := other.CheckVolume(ctx)
+		var mismatch *cas.DescriptorMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Fatalf("expected DescriptorMismatchError, got %v", err)
+		}
+		if g, e := mismatch.Field, "chunker average bits"; g != e {
+			t.Errorf("wrong mismatch field: %q != %q", g, e)
+		}
+	})
+
+	t.Run("shard bits", func(t *testing.T) {
+		other := cas.NewStore("s3kr1t",
+			cas.WithBucket(b, cas.BucketShardBits(9)),
+		)
+		err := other.CheckVolume(ctx)
+		var mismatch *cas.DescriptorMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Fatalf("expected DescriptorMismatchError, got %v", err)
+		}
+		if g, e := mismatch.Field, "shard bits"; g != e {
+			t.Errorf("wrong mismatch field: %q != %q", g, e)
+		}
+		if g, e := mismatch.Bucket, "#1"; g != e {
+			t.Errorf("wrong mismatch bucket: %q != %q", g, e)
+		}
+	})
+}
+
+func TestVolumePrepareEmpty(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	s := cas.NewStore("s3kr1t", cas.WithBucket(b))
+	if err := s.PrepareVolume(ctx); err != nil {
+		t.Fatalf("PrepareVolume: %v", err)
+	}
+	// opening does not write, so any passphrase is accepted until
+	// the volume is used
+	checkBucket(t, b)
+	typo := cas.NewStore("hunter2", cas.WithBucket(b))
+	if err := typo.PrepareVolume(ctx); err != nil {
+		t.Fatalf("PrepareVolume: %v", err)
+	}
+
+	// the first write initializes the volume
+	if _, err := s.Create(ctx, strings.NewReader("hello, world\n")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := s.CheckVolume(ctx); err != nil {
+		t.Fatalf("CheckVolume: %v", err)
+	}
+	wrong := cas.NewStore("hunter2", cas.WithBucket(b))
+	if err := wrong.PrepareVolume(ctx); !errors.Is(err, cas.ErrWrongPassphrase) {
+		t.Fatalf("expected ErrWrongPassphrase, got %v", err)
+	}
+	// a store opened before the volume was initialized does not
+	// write under another passphrase
+	if _, err := typo.Create(ctx, strings.NewReader("oops\n")); !errors.Is(err, cas.ErrWrongPassphrase) {
+		t.Fatalf("expected ErrWrongPassphrase, got %v", err)
+	}
+}
+
+func TestVolumePrepareLegacy(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	old := cas.NewStore("s3kr1t", cas.WithBucket(b))
+	key, err := old.Create(ctx, strings.NewReader("hello, world\n"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	wrong := cas.NewStore("hunter2", cas.WithBucket(b))
+	if err := wrong.PrepareVolume(ctx); err != nil {
+		t.Fatalf("PrepareVolume: %v", err)
+	}
+	if _, err := wrong.Create(ctx, strings.NewReader("oops\n")); !errors.Is(err, cas.ErrNotInitialized) {
+		t.Fatalf("expected ErrNotInitialized, got %v", err)
+	}
+	if _, err := wrong.SetRef(ctx, "main", key, 0); !errors.Is(err, cas.ErrNotInitialized) {
+		t.Fatalf("expected ErrNotInitialized, got %v", err)
+	}
+
+	s := cas.NewStore("s3kr1t", cas.WithBucket(b))
+	if err := s.PrepareVolume(ctx); err != nil {
+		t.Fatalf("PrepareVolume: %v", err)
+	}
+	if _, err := s.Open(ctx, key); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := s.InitVolume(ctx); err != nil {
+		t.Fatalf("InitVolume: %v", err)
+	}
+	if _, err := s.Create(ctx, strings.NewReader("more\n")); err != nil {
+		t.Fatalf("Create after init: %v", err)
+	}
+}
+
+func TestVolumePrefix(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	one := cas.NewStore("s3kr1t", cas.WithBucket(b), cas.WithObjectPrefix("one/"))
+	two := cas.NewStore("hunter2", cas.WithBucket(b), cas.WithObjectPrefix("two/"))
+	if err := one.InitVolume(ctx); err != nil {
+		t.Fatalf("InitVolume: %v", err)
+	}
+	if err := two.InitVolume(ctx); err != nil {
+		t.Fatalf("InitVolume: %v", err)
+	}
+	key, err := one.Create(ctx, strings.NewReader("hello, world\n"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	checkBucket(t, b,
+		"one/plop-volume",
+		"two/plop-volume",
+		"one/b3jci1t6o4wstq445g5hc6mguexbbq948kq7mm1kxbjwyzwdrh6o",
+		"one/o3iaqfe94q73cqbw3s468pxoy444hotxmahoqkfi91htaigfheqy",
+	)
+	if _, err := one.Open(ctx, key); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package cas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"bazil.org/plop/internal/multierr"
+	"gocloud.dev/gcerrors"
+)
+
+// BucketState is the health of a bucket, as seen by a Store.
+type BucketState int
+
+const (
+	// BucketHealthy buckets are used normally.
+	BucketHealthy BucketState = iota
+	// BucketDegraded buckets have failed recently, and are still
+	// used.
+	BucketDegraded
+	// BucketOpen buckets have failed repeatedly. They are skipped,
+	// except for a probe request every now and then, until a request
+	// succeeds.
+	BucketOpen
+)
+
+func (s BucketState) String() string {
+	switch s {
+	case BucketHealthy:
+		return "healthy"
+	case BucketDegraded:
+		return "degraded"
+	case BucketOpen:
+		return "open"
+	default:
+		return fmt.Sprintf("BucketState(%d)", int(s))
+	}
+}
+
+// ErrBucketOpen is the error for buckets skipped because they have
+// been failing. See BucketOpen.
+var ErrBucketOpen = errors.New("bucket skipped after repeated failures")
+
+const (
+	// openAfter is how many failed requests in a row open a bucket.
+	openAfter = 5
+	// probeInterval is how often an open bucket is tried again.
+	probeInterval = 30 * time.Second
+)
+
+// BucketHealth describes the health of one bucket.
+type BucketHealth struct {
+	Name  string
+	State BucketState
+	// Since is when the bucket entered its current state. It is zero
+	// for buckets that have always been healthy.
+	Since time.Time
+	// Failures is the number of failed requests since the last
+	// successful one.
+	Failures int
+	// LastError is the error of the last failed request, or nil.
+	LastError error
+}
+
+// health tracks the state of a bucket, as a circuit breaker.
+type health struct {
+	mu        sync.Mutex
+	state     BucketState
+	since     time.Time
+	failures  int
+	lastErr   error
+	nextProbe time.Time
+}
+
+// isBucketFailure reports whether err means something is wrong with
+// the bucket, as opposed to the object.
+func isBucketFailure(err error) bool {
+	var ctErr *UnexpectedContentTypeError
+	if errors.As(err, &ctErr) {
+		return false
+	}
+	switch gcerrors.Code(err) {
+	case gcerrors.NotFound, gcerrors.AlreadyExists, gcerrors.FailedPrecondition:
+		// the bucket answered
+		return false
+	}
+	return true
+}
+
+// allow reports whether a request should be sent to the bucket. An
+// open bucket is let through once every probeInterval, to notice
+// when it recovers.
+func (alt *alternativeBucket) allow(now time.Time) bool {
+	h := alt.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.state != BucketOpen {
+		return true
+	}
+	if now.Before(h.nextProbe) {
+		return false
+	}
+	h.nextProbe = now.Add(probeInterval)
+	return true
+}
+
+// observe updates the health of the bucket after a request to it. A
+// request abandoned by the caller says nothing about the bucket.
+func (alt *alternativeBucket) observe(ctx context.Context, err error) {
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	h := alt.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	setState := func(state BucketState) {
+		if h.state == state {
+			return
+		}
+		h.state = state
+		h.since = now
+		alt.metrics.bucketState.WithLabelValues(alt.name).Set(float64(state))
+	}
+	if err == nil || !isBucketFailure(err) {
+		h.failures = 0
+		setState(BucketHealthy)
+		return
+	}
+	h.failures++
+	h.lastErr = err
+	if h.state != BucketOpen && h.failures >= openAfter {
+		h.nextProbe = now.Add(probeInterval)
+		setState(BucketOpen)
+		return
+	}
+	if h.state == BucketHealthy {
+		setState(BucketDegraded)
+	}
+}
+
+// usableBuckets returns the buckets to send a request to, by index
+// in the configured buckets, and errors for the buckets skipped. If
+// every bucket would be skipped, all are tried anyway.
+func (s *Store) usableBuckets() (use []int, skipped []error) {
+	now := time.Now()
+	for i := range s.config.buckets {
+		alt := &s.config.buckets[i]
+		if !alt.allow(now) {
+			skipped = append(skipped, fmt.Errorf("bucket %s: %w", alt.name, ErrBucketOpen))
+			continue
+		}
+		use = append(use, i)
+	}
+	if len(use) == 0 {
+		for i := range s.config.buckets {
+			use = append(use, i)
+		}
+		return use, nil
+	}
+	return use, skipped
+}
+
+// withSkipped adds the errors for skipped buckets to the error of a
+// request. This keeps skipped buckets from looking like they do not
+// have the object.
+func withSkipped(err error, skipped []error) error {
+	if len(skipped) == 0 {
+		return err
+	}
+	var errs []error
+	if m, ok := err.(multierr.MultiErr); ok {
+		errs = append(errs, m...)
+	} else {
+		errs = append(errs, err)
+	}
+	errs = append(errs, skipped...)
+	return multierr.New(errs)
+}
+
+// BucketHealth returns the health of the buckets of the store, in
+// the order they were configured.
+func (s *Store) BucketHealth() []BucketHealth {
+	res := make([]BucketHealth, 0, len(s.config.buckets))
+	for i := range s.config.buckets {
+		alt := &s.config.buckets[i]
+		h := alt.health
+		h.mu.Lock()
+		res = append(res, BucketHealth{
+			Name:      alt.name,
+			State:     h.state,
+			Since:     h.since,
+			Failures:  h.failures,
+			LastError: h.lastErr,
+		})
+		h.mu.Unlock()
+	}
+	return res
+}
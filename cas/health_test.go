@@ -0,0 +1,132 @@
+package cas
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestHealth(t *testing.T) {
+	ctx := context.Background()
+	alt := testBucket("test-health", RetryPolicy{})
+	errFail := errors.New("region outage for test")
+	fail := func(ctx context.Context) error { return errFail }
+	check := func(state BucketState, failures int) {
+		t.Helper()
+		h := alt.health
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.state != state || h.failures != failures {
+			t.Errorf("wrong health: %v/%d != %v/%d", h.state, h.failures, state, failures)
+		}
+	}
+
+	_ = alt.do(ctx, 0, fail)
+	check(BucketDegraded, 1)
+	if g, e := testutil.ToFloat64(alt.metrics.bucketState.WithLabelValues("test-health")), float64(BucketDegraded); g != e {
+		t.Errorf("wrong state metric: %v != %v", g, e)
+	}
+	for i := 0; i < openAfter-1; i++ {
+		_ = alt.do(ctx, 0, fail)
+	}
+	check(BucketOpen, openAfter)
+
+	now := time.Now()
+	if alt.allow(now) {
+		t.Error("open bucket must be skipped")
+	}
+	later := now.Add(probeInterval)
+	if !alt.allow(later) {
+		t.Error("open bucket must be probed")
+	}
+	if alt.allow(later) {
+		t.Error("only one probe per interval")
+	}
+
+	// a request abandoned by the caller does not count
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_ = alt.do(canceled, 0, func(ctx context.Context) error { return ctx.Err() })
+	check(BucketOpen, openAfter)
+
+	// the bucket answering that an object does not exist is healthy
+	_ = alt.do(ctx, 0, func(ctx context.Context) error {
+		_, err := alt.bucket.ReadAll(ctx, "does-not-exist")
+		return err
+	})
+	check(BucketHealthy, 0)
+}
+
+func TestSkipOpenBucket(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore("s3kr1t",
+		WithBucket(memblob.OpenBucket(nil), BucketName("primary")),
+		WithBucket(memblob.OpenBucket(nil), BucketName("mirror"), BucketAfter(time.Hour)),
+	)
+	// only the primary has the object
+	key, err := s.Create(ctx, strings.NewReader("hello, world\n"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	setOpen := func(alt *alternativeBucket) {
+		alt.health.mu.Lock()
+		defer alt.health.mu.Unlock()
+		alt.health.state = BucketOpen
+		alt.health.nextProbe = time.Now().Add(time.Hour)
+	}
+	setOpen(&s.config.buckets[0])
+
+	_, err = s.Open(ctx, key)
+	if !errors.Is(err, ErrBucketOpen) {
+		t.Errorf("expected skipped bucket in error: %v", err)
+	}
+	if errors.Is(err, ErrNotExist) {
+		t.Errorf("object in a skipped bucket must not be reported missing: %v", err)
+	}
+
+	// with every bucket open, try them all anyway
+	setOpen(&s.config.buckets[1])
+	if _, err := s.Open(ctx, key); err != nil {
+		t.Errorf("Open: %v", err)
+	}
+	health := s.BucketHealth()
+	if g, e := health[0].State, BucketHealthy; g != e {
+		t.Errorf("primary did not recover: %v != %v", g, e)
+	}
+}
+
+func TestLocateSkipsOpenBucket(t *testing.T) {
+	ctx := context.Background()
+	var requests countLimiter
+	s := NewStore("s3kr1t",
+		WithBucket(memblob.OpenBucket(nil), BucketName("primary")),
+		WithBucket(memblob.OpenBucket(nil), BucketName("mirror"), BucketLimits(Limits{Requests: &requests})),
+	)
+	key, err := s.Create(ctx, strings.NewReader("hello, world\n"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	primary := &s.config.buckets[0]
+	primary.health.mu.Lock()
+	primary.health.state = BucketOpen
+	primary.health.nextProbe = time.Now().Add(time.Hour)
+	primary.health.mu.Unlock()
+
+	before := requests.calls
+	names, err := s.Locate(ctx, key)
+	if !errors.Is(err, ErrBucketOpen) {
+		t.Errorf("expected skipped bucket in error: %v", err)
+	}
+	if g, e := names, []string{"mirror"}; !reflect.DeepEqual(g, e) {
+		t.Errorf("wrong buckets: %q != %q", g, e)
+	}
+	if requests.calls == before {
+		t.Error("Locate was not rate limited")
+	}
+}
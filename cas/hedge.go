@@ -0,0 +1,87 @@
+package cas
+
+import (
+	"sort"
+	"time"
+
+	"bazil.org/plop/internal/multiflight"
+)
+
+// latencyWindow is how many recent requests to each bucket are used
+// to estimate its latency.
+const latencyWindow = 200
+
+// Percentiles of latency used for hedging.
+const (
+	// Buckets are tried fastest first, by median latency.
+	orderQuantile = 0.5
+	// The next bucket is tried when the previous one is slower than
+	// usual.
+	hedgeQuantile = 0.95
+)
+
+// hedgeDelays returns when to start trying each bucket, by index in
+// the configured buckets. The latency function picks which latency
+// to use, as reads and writes differ.
+//
+// Once every bucket has enough samples, buckets are ordered by their
+// recent median latency. Each bucket is tried when the one before it
+// has taken longer than its recent 95th percentile. The configured
+// delays bound this: the Nth bucket is tried no later than the Nth
+// smallest configured delay, so hedging only ever tries buckets
+// sooner than without it.
+func (s *Store) hedgeDelays(latency func(alt *alternativeBucket) *multiflight.Latency) []time.Duration {
+	buckets := s.config.buckets
+	order := make([]int, len(buckets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return buckets[order[a]].delay < buckets[order[b]].delay
+	})
+	bounds := make([]time.Duration, len(order))
+	for k, i := range order {
+		bounds[k] = buckets[i].delay
+	}
+
+	medians := make([]time.Duration, len(buckets))
+	known := true
+	for i := range buckets {
+		median, ok := latency(&buckets[i]).Quantile(orderQuantile)
+		if !ok {
+			known = false
+			break
+		}
+		medians[i] = median
+	}
+	if known {
+		sort.SliceStable(order, func(a, b int) bool {
+			return medians[order[a]] < medians[order[b]]
+		})
+	}
+
+	delays := make([]time.Duration, len(buckets))
+	var start time.Duration
+	for k, i := range order {
+		if k > 0 {
+			next := bounds[k]
+			prev := &buckets[order[k-1]]
+			if slow, ok := latency(prev).Quantile(hedgeQuantile); ok && start+slow < next {
+				next = start + slow
+			}
+			if next > start {
+				start = next
+			}
+		}
+		delays[i] = start
+	}
+	return delays
+}
+
+func readLatency(alt *alternativeBucket) *multiflight.Latency {
+	return alt.readLatency
+}
+
+func writeLatency(alt *alternativeBucket) *multiflight.Latency {
+	return alt.writeLatency
+}
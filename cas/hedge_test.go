@@ -0,0 +1,49 @@
+package cas
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob/memblob"
+)
+
+func TestHedgeDelays(t *testing.T) {
+	s := NewStore("s3kr1t",
+		WithBucket(memblob.OpenBucket(nil), BucketName("primary")),
+		WithBucket(memblob.OpenBucket(nil), BucketName("mirror"), BucketAfter(time.Second)),
+	)
+	primary, mirror := &s.config.buckets[0], &s.config.buckets[1]
+	check := func(want ...time.Duration) {
+		t.Helper()
+		if g := s.hedgeDelays(readLatency); !reflect.DeepEqual(g, want) {
+			t.Errorf("wrong delays: %v != %v", g, want)
+		}
+	}
+
+	// nothing known yet, use the configured delays
+	check(0, time.Second)
+
+	// hedge when the primary is slower than usual
+	for i := 0; i < 100; i++ {
+		primary.readLatency.Observe(time.Duration(i+1) * time.Millisecond)
+	}
+	check(0, 95*time.Millisecond)
+
+	// never wait longer than configured
+	for i := 0; i < latencyWindow; i++ {
+		primary.readLatency.Observe(5 * time.Second)
+	}
+	check(0, time.Second)
+
+	// a faster mirror is tried first
+	for i := 0; i < 100; i++ {
+		mirror.readLatency.Observe(20 * time.Millisecond)
+	}
+	check(20*time.Millisecond, 0)
+
+	// writes are tracked separately
+	if g, e := s.hedgeDelays(writeLatency), []time.Duration{0, time.Second}; !reflect.DeepEqual(g, e) {
+		t.Errorf("wrong write delays: %v != %v", g, e)
+	}
+}
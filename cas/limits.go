@@ -0,0 +1,48 @@
+package cas
+
+import (
+	"context"
+)
+
+// Limiter limits the rate of requests or bytes, waiting as needed.
+type Limiter interface {
+	// Wait takes n tokens, waiting until they are available.
+	Wait(ctx context.Context, n int64) error
+}
+
+// Limits are rate limits for a bucket. Nil limiters do not limit.
+// Limiters may be shared between buckets and stores.
+type Limits struct {
+	// Upload limits the bytes of objects written.
+	Upload Limiter
+	// Download limits the bytes of objects read.
+	Download Limiter
+	// Requests limits the number of requests.
+	Requests Limiter
+}
+
+// waitAttempt waits until the limits allow another attempt at a
+// request, uploading the given number of bytes.
+func (l *Limits) waitAttempt(ctx context.Context, upload int64) error {
+	if l.Requests != nil {
+		if err := l.Requests.Wait(ctx, 1); err != nil {
+			return err
+		}
+	}
+	if l.Upload != nil && upload > 0 {
+		if err := l.Upload.Wait(ctx, upload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitDownload accounts for downloaded bytes. Callers wait once the
+// size of the object is known, before reading its content, and leave
+// the wait out of the bucket latency.
+func (l *Limits) waitDownload(ctx context.Context, n int64) error {
+	if l.Download == nil {
+		return nil
+	}
+	return l.Download.Wait(ctx, n)
+}
@@ -0,0 +1,159 @@
+package cas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob/memblob"
+)
+
+// countLimiter counts tokens taken, without waiting.
+type countLimiter struct {
+	mu    sync.Mutex
+	calls int
+	total int64
+	err   error
+}
+
+func (l *countLimiter) Wait(ctx context.Context, n int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+	l.calls++
+	l.total += n
+	return nil
+}
+
+// sleepLimiter waits a fixed time for every wait.
+type sleepLimiter time.Duration
+
+func (l sleepLimiter) Wait(ctx context.Context, n int64) error {
+	time.Sleep(time.Duration(l))
+	return nil
+}
+
+func TestLimitsLatency(t *testing.T) {
+	ctx := context.Background()
+	const wait = 20 * time.Millisecond
+	s := NewStore("s3kr1t",
+		WithBucket(memblob.OpenBucket(nil), BucketLimits(Limits{
+			Download: sleepLimiter(wait),
+			Requests: sleepLimiter(wait),
+		})),
+	)
+	alt := &s.config.buckets[0]
+	// enough objects for the latencies to be estimated
+	for i := 0; i < 20; i++ {
+		key, err := s.Create(ctx, strings.NewReader(fmt.Sprintf("hello, %d\n", i)))
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		h, err := s.Open(ctx, key)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if _, err := io.ReadAll(h.IO(ctx)); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+	}
+
+	// hedging compares buckets without the time spent waiting for
+	// limits
+	for _, l := range []struct {
+		name    string
+		latency interface {
+			Quantile(q float64) (time.Duration, bool)
+		}
+	}{
+		{"read", alt.readLatency},
+		{"write", alt.writeLatency},
+	} {
+		d, ok := l.latency.Quantile(1)
+		if !ok {
+			t.Errorf("no %s latency observed", l.name)
+			continue
+		}
+		if d >= wait {
+			t.Errorf("%s latency includes limiter wait: %v", l.name, d)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+	var upload, download, requests countLimiter
+	bucket := memblob.OpenBucket(nil)
+	s := NewStore("s3kr1t",
+		WithBucket(bucket, BucketLimits(Limits{
+			Upload:   &upload,
+			Download: &download,
+			Requests: &requests,
+		})),
+	)
+	const greeting = "hello, world\n"
+	key, err := s.Create(ctx, strings.NewReader(greeting))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	var stored int64
+	iter := bucket.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		stored += obj.Size
+	}
+	if g, e := upload.total, stored; g != e {
+		t.Errorf("wrong upload bytes: %d != %d", g, e)
+	}
+	if requests.calls == 0 {
+		t.Error("requests were not limited")
+	}
+
+	h, err := s.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	buf, err := io.ReadAll(h.IO(ctx))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if g, e := string(buf), greeting; g != e {
+		t.Errorf("wrong content: %q != %q", g, e)
+	}
+	if download.total == 0 {
+		t.Error("downloads were not limited")
+	}
+
+	// downloads are charged before their content is read
+	errLimit := errors.New("limit for test")
+	download.err = errLimit
+	uncached := NewStore("s3kr1t",
+		WithBucket(bucket, BucketLimits(Limits{
+			Download: &download,
+		})),
+	)
+	if _, err := uncached.Open(ctx, key); !errors.Is(err, errLimit) {
+		t.Errorf("expected download limiter error: %v", err)
+	}
+	download.err = nil
+
+	// a failing wait stops the request
+	requests.err = errLimit
+	if _, err := s.Create(ctx, strings.NewReader("other\n")); !errors.Is(err, errLimit) {
+		t.Errorf("expected limiter error: %v", err)
+	}
+}
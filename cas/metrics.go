@@ -0,0 +1,119 @@
+package cas
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gocloud.dev/gcerrors"
+)
+
+// durationBuckets are histogram buckets for request durations, in
+// seconds.
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// storeMetrics are the metrics of a Store. Stores sharing a registry
+// share the metrics, see WithMetrics.
+type storeMetrics struct {
+	cacheHits      prometheus.Counter
+	cacheMisses    prometheus.Counter
+	cacheEvictions prometheus.Counter
+
+	bucketRequests *prometheus.CounterVec
+	bucketDuration *prometheus.HistogramVec
+	bucketBytes    *prometheus.CounterVec
+
+	bucketState   *prometheus.GaugeVec
+	bucketRetries *prometheus.CounterVec
+
+	dedupHits *prometheus.CounterVec
+	fallbacks *prometheus.CounterVec
+}
+
+// newStoreMetrics creates the metrics of a store, and registers them
+// with reg. A nil reg keeps them unregistered.
+func newStoreMetrics(reg prometheus.Registerer) *storeMetrics {
+	m := &storeMetrics{
+		cacheHits: register(reg, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "plop_cache_hits_total",
+			Help: "Objects found in the object cache.",
+		})),
+		cacheMisses: register(reg, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "plop_cache_misses_total",
+			Help: "Objects not found in the object cache.",
+		})),
+		cacheEvictions: register(reg, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "plop_cache_evictions_total",
+			Help: "Objects evicted from the object cache.",
+		})),
+
+		bucketRequests: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "plop_bucket_requests_total",
+			Help: "Requests to buckets, by operation and error code.",
+		}, []string{"bucket", "op", "code"})),
+		bucketDuration: register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "plop_bucket_request_duration_seconds",
+			Help:    "Duration of requests to buckets.",
+			Buckets: durationBuckets,
+		}, []string{"bucket", "op"})),
+		bucketBytes: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "plop_bucket_bytes_total",
+			Help: "Object bytes transferred to and from buckets.",
+		}, []string{"bucket", "direction"})),
+
+		bucketState: register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "plop_bucket_state",
+			Help: "Health of buckets: 0 healthy, 1 degraded, 2 open.",
+		}, []string{"bucket"})),
+		bucketRetries: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "plop_bucket_retries_total",
+			Help: "Requests to buckets retried, by reason.",
+		}, []string{"bucket", "reason"})),
+
+		dedupHits: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "plop_dedup_hits_total",
+			Help: "Objects not uploaded because the bucket already had them.",
+		}, []string{"bucket"})),
+		fallbacks: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "plop_multiflight_fallbacks_total",
+			Help: "Alternatives started because earlier ones were slow (reason delay) or failed (reason error).",
+		}, []string{"op", "reason"})),
+	}
+	return m
+}
+
+// register registers c with reg. If another Store already registered
+// the same metric, that one is returned instead, so the stores count
+// together. Other registration errors leave c unregistered, rather
+// than failing the Store.
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) C {
+	if reg == nil {
+		return c
+	}
+	err := reg.Register(c)
+	var already prometheus.AlreadyRegisteredError
+	if errors.As(err, &already) {
+		if existing, ok := already.ExistingCollector.(C); ok {
+			return existing
+		}
+	}
+	return c
+}
+
+// countFallback returns a function counting the fallbacks of a
+// multiflight operation.
+func (s *Store) countFallback(op string) func(reason string) {
+	return func(reason string) {
+		s.metrics.fallbacks.WithLabelValues(op, reason).Inc()
+	}
+}
+
+// observeRequest records a request to a bucket, started at start.
+func (m *storeMetrics) observeRequest(bucket string, op string, start time.Time, err error) {
+	code := "OK"
+	if err != nil {
+		code = gcerrors.Code(err).String()
+	}
+	m.bucketRequests.WithLabelValues(bucket, op, code).Inc()
+	m.bucketDuration.WithLabelValues(bucket, op).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,122 @@
+package cas
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/dgryski/go-s4lru"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"gocloud.dev/blob/memblob"
+)
+
+// histogramCount returns the number of observations in h.
+func histogramCount(t testing.TB, h prometheus.Observer) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := h.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("reading histogram: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	const name = "test-metrics"
+	s := NewStore("s3kr1t", WithBucket(memblob.OpenBucket(nil), BucketName(name)))
+	m := s.metrics
+
+	writes := func() float64 { return testutil.ToFloat64(m.bucketRequests.WithLabelValues(name, "write", "OK")) }
+	written := func() float64 { return testutil.ToFloat64(m.bucketBytes.WithLabelValues(name, "write")) }
+	reads := func() float64 { return testutil.ToFloat64(m.bucketRequests.WithLabelValues(name, "read", "OK")) }
+	notFound := func() float64 { return testutil.ToFloat64(m.bucketRequests.WithLabelValues(name, "read", "NotFound")) }
+	durations := func() float64 { return float64(histogramCount(t, m.bucketDuration.WithLabelValues(name, "read"))) }
+	hits := func() float64 { return testutil.ToFloat64(m.cacheHits) }
+	misses := func() float64 { return testutil.ToFloat64(m.cacheMisses) }
+
+	key, err := s.Create(ctx, strings.NewReader("hello, world\n"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	// extents and the one blob
+	if g, e := writes(), 2.0; g != e {
+		t.Errorf("wrong write requests: %v != %v", g, e)
+	}
+	if written() == 0 {
+		t.Errorf("expected bytes written")
+	}
+
+	for i := 0; i < 2; i++ {
+		h, err := s.Open(ctx, key)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		buf := make([]byte, 13)
+		if _, err := h.IO(ctx).ReadAt(buf, 0); err != nil {
+			t.Fatalf("ReadAt: %v", err)
+		}
+	}
+	// extents are not cached
+	if g, e := reads(), 3.0; g != e {
+		t.Errorf("wrong read requests: %v != %v", g, e)
+	}
+	if g, e := misses(), 1.0; g != e {
+		t.Errorf("wrong cache misses: %v != %v", g, e)
+	}
+	if g, e := hits(), 1.0; g != e {
+		t.Errorf("wrong cache hits: %v != %v", g, e)
+	}
+
+	if _, err := s.Open(ctx, "yyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyy"); err == nil {
+		t.Fatal("expected error")
+	}
+	if g, e := notFound(), 1.0; g != e {
+		t.Errorf("wrong failed read requests: %v != %v", g, e)
+	}
+	if g, e := durations(), 4.0; g != e {
+		t.Errorf("wrong read durations: %v != %v", g, e)
+	}
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	ctx := context.Background()
+	reg := prometheus.NewRegistry()
+	// stores of different volumes share the registry
+	one := NewStore("s3kr1t", WithMetrics(reg), WithBucket(memblob.OpenBucket(nil), BucketName("one")))
+	two := NewStore("s3kr1t", WithMetrics(reg), WithBucket(memblob.OpenBucket(nil), BucketName("two")))
+	for _, s := range []*Store{one, two} {
+		if _, err := s.Create(ctx, strings.NewReader("hello, world\n")); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+	const want = `
+# HELP plop_bucket_requests_total Requests to buckets, by operation and error code.
+# TYPE plop_bucket_requests_total counter
+plop_bucket_requests_total{bucket="one",code="OK",op="write"} 2
+plop_bucket_requests_total{bucket="two",code="OK",op="write"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "plop_bucket_requests_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCacheEvictions(t *testing.T) {
+	s := NewStore("s3kr1t", WithBucket(memblob.OpenBucket(nil)))
+	// one entry per segment
+	s.cache = s4lru.New(4)
+	if s.cacheObject("one", []byte("1")) {
+		t.Error("empty cache evicted")
+	}
+	if s.cacheObject("one", []byte("1")) {
+		t.Error("adding a cached object evicted")
+	}
+	// the lookup counts as a use, moving "one" to the next segment
+	if s.cacheObject("two", []byte("2")) {
+		t.Error("free segment evicted")
+	}
+	if !s.cacheObject("three", []byte("3")) {
+		t.Error("full segment did not evict")
+	}
+}
@@ -60,6 +60,12 @@ func (h *Handle) Size() int64 {
 	return off
 }
 
+// NumExtents returns the number of extents, that is chunks of data,
+// the object consists of.
+func (h *Handle) NumExtents() int {
+	return len(h.extents) / extentSize
+}
+
 func (h *Handle) IO(ctx context.Context) *Reader {
 	r := &Reader{
 		handle: h,
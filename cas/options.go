@@ -1,8 +1,11 @@
 package cas
 
 import (
+	"fmt"
 	"time"
 
+	"bazil.org/plop/internal/multiflight"
+	"github.com/prometheus/client_golang/prometheus"
 	"gocloud.dev/blob"
 )
 
@@ -43,10 +46,44 @@ func WithChunkGoal(size uint32) Option {
 	return fn
 }
 
+// WithCatalog records every object created in the catalog.
+func WithCatalog(catalog Cataloger) Option {
+	fn := func(cfg *config) {
+		cfg.catalog = catalog
+	}
+	return fn
+}
+
+// WithObjectPrefix sets a prefix for the names of all objects
+// stored, letting multiple volumes share a bucket.
+func WithObjectPrefix(prefix string) Option {
+	fn := func(cfg *config) {
+		cfg.prefix = prefix
+	}
+	return fn
+}
+
+// WithMetrics registers the metrics of the store with reg. Stores
+// sharing a registry count together. By default, metrics are not
+// exposed.
+func WithMetrics(reg prometheus.Registerer) Option {
+	fn := func(cfg *config) {
+		cfg.registerer = reg
+	}
+	return fn
+}
+
 // WithBucket adds a bucket as an alternate destination for reads and writes.
 func WithBucket(bucket *blob.Bucket, opts ...BucketOption) Option {
 	fn := func(cfg *config) {
-		bucket := alternativeBucket{bucket: bucket}
+		bucket := alternativeBucket{
+			bucket:       bucket,
+			name:         fmt.Sprintf("#%d", len(cfg.buckets)+1),
+			limiter:      newLimiter(maxBucketConcurrency),
+			health:       &health{},
+			readLatency:  multiflight.NewLatency(latencyWindow),
+			writeLatency: multiflight.NewLatency(latencyWindow),
+		}
 		for _, opt := range opts {
 			opt(&bucket)
 		}
@@ -60,7 +97,10 @@ type bucketOption func(*alternativeBucket)
 type BucketOption bucketOption
 
 // BucketAfter sets a bucket to only be tried after delay has passed,
-// or if all earlier possible buckets have failed.
+// or if all earlier possible buckets have failed. Once the latency of
+// the buckets is known, buckets may be tried sooner, and in a
+// different order; delay then only bounds how long a request waits
+// before trying another bucket.
 func BucketAfter(delay time.Duration) BucketOption {
 	fn := func(bucket *alternativeBucket) {
 		bucket.delay = delay
@@ -68,9 +108,82 @@ func BucketAfter(delay time.Duration) BucketOption {
 	return fn
 }
 
+// BucketName sets a human-readable name for the bucket, used in
+// diagnostics. Defaults to the position of the bucket, as in "#1".
+func BucketName(name string) BucketOption {
+	fn := func(bucket *alternativeBucket) {
+		bucket.name = name
+	}
+	return fn
+}
+
 func BucketShardBits(shardBits uint8) BucketOption {
 	fn := func(bucket *alternativeBucket) {
 		bucket.shardBits = shardBits
 	}
 	return fn
 }
+
+// BucketLimits sets rate limits for requests to the bucket. By
+// default, requests are not rate limited.
+func BucketLimits(limits Limits) BucketOption {
+	fn := func(bucket *alternativeBucket) {
+		bucket.limits = limits
+	}
+	return fn
+}
+
+// BucketRetry sets how failed requests to the bucket are retried.
+// By default, requests are not retried.
+func BucketRetry(policy RetryPolicy) BucketOption {
+	fn := func(bucket *alternativeBucket) {
+		bucket.retry = policy
+	}
+	return fn
+}
+
+type createOption func(*createConfig)
+
+type CreateOption createOption
+
+type createConfig struct {
+	source    string
+	labels    map[string]string
+	noCatalog bool
+	progress  func(stored int64)
+}
+
+// CreateSource records where the data came from, such as a file
+// path, in the catalog.
+func CreateSource(source string) CreateOption {
+	fn := func(cfg *createConfig) {
+		cfg.source = source
+	}
+	return fn
+}
+
+// CreateLabels attaches labels to the catalog entry of the object.
+func CreateLabels(labels map[string]string) CreateOption {
+	fn := func(cfg *createConfig) {
+		cfg.labels = labels
+	}
+	return fn
+}
+
+// CreateProgress calls fn after every chunk of the content is
+// stored, with the number of bytes stored so far. It is called from
+// the goroutine calling Create.
+func CreateProgress(progress func(stored int64)) CreateOption {
+	fn := func(cfg *createConfig) {
+		cfg.progress = progress
+	}
+	return fn
+}
+
+// CreateWithoutCatalog skips recording the object in the catalog.
+func CreateWithoutCatalog() CreateOption {
+	fn := func(cfg *createConfig) {
+		cfg.noCatalog = true
+	}
+	return fn
+}
@@ -0,0 +1,497 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bazil.org/plop/internal/multierr"
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/tv42/zbase32"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Refs are named, mutable pointers to keys.
+//
+// Every update of a ref is stored as a separate immutable entry, so
+// the history of a ref is available. The entry object names contain
+// the boxed ref name and a sequence number:
+//
+//	refs/BOXEDNAME/SEQ
+//
+// Updates are a compare-and-swap: a writer that saw sequence number N
+// creates entry N+1 only if it does not exist yet. On backends with
+// native create-if-not-exist preconditions this is atomic, and no
+// update is lost. Those are Google Cloud Storage, Azure Blob Storage,
+// and S3 through the default v1 SDK (If-None-Match, which S3 has
+// honored since 2024; S3-compatible servers may not, see below).
+//
+// Other backends, such as local files, memory, and S3 through the v2
+// SDK, have no such precondition. There, it is emulated by checking
+// for existence and reading the entry back after the write. This only
+// narrows the race: refs on these backends give NO lost-update
+// guarantee, and two concurrent writers may both succeed, with one
+// update silently lost. Use them with a single writer.
+//
+// Refs are written to all buckets, but the first bucket is
+// authoritative for conflict detection and reads.
+
+var (
+	ErrRefNotExist = errors.New("ref does not exist")
+	ErrRefConflict = errors.New("ref was updated concurrently")
+	ErrBadRefName  = errors.New("bad ref name")
+)
+
+const (
+	contentTypeRefV1 = "application/x.org.bazil.plop.ref.v1"
+
+	prefixRef = "bazil.org/plop#type/ref/v1\x00\x00\x00\x00\x00\x00"
+
+	refsDir = "refs/"
+)
+
+func init() {
+	if len(prefixRef) != 32 {
+		panic("bad definition of prefixRef")
+	}
+}
+
+// Ref is a single state of a named ref.
+type Ref struct {
+	Name string `json:"name"`
+	// Seq is the sequence number of this update, starting from 1.
+	Seq uint64 `json:"seq"`
+	// Key is the object the ref points to. Empty Key means the ref
+	// was deleted.
+	Key  string    `json:"key"`
+	Time time.Time `json:"time"`
+}
+
+// Deleted reports whether this state marks the deletion of the ref.
+func (r *Ref) Deleted() bool {
+	return r.Key == ""
+}
+
+func checkRefName(name string) error {
+	if name == "" || strings.ContainsAny(name, "\x00") {
+		return ErrBadRefName
+	}
+	return nil
+}
+
+func (s *Store) boxRefName(name string) string {
+	h := mustBlake3NewKeyed(s.refNameSecret)
+	_, _ = h.Write([]byte(name))
+	return zbase32.EncodeToString(h.Sum(nil))
+}
+
+// refEntryName returns the object name of a ref entry, excluding the
+// store-wide prefix. It is also used as the AEAD associated data, so
+// entries cannot be moved around.
+func refEntryName(boxedName string, seq uint64) string {
+	return fmt.Sprintf("%s%s/%016x", refsDir, boxedName, seq)
+}
+
+func parseRefSeq(objectName string) (uint64, bool) {
+	idx := strings.LastIndexByte(objectName, '/')
+	if idx < 0 {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(objectName[idx+1:], 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return seq, true
+}
+
+func (s *Store) sealRef(entryName string, ref *Ref) ([]byte, error) {
+	plaintext, err := json.Marshal(ref)
+	if err != nil {
+		return nil, err
+	}
+	plaintext = append([]byte(prefixRef), plaintext...)
+	nonce := make([]byte, chacha20poly1305.NonceSizeX, chacha20poly1305.NonceSizeX+len(plaintext)+chacha20poly1305.Overhead)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("cannot get randomness: %w", err)
+	}
+	ciphertext := s.refCipher.Seal(nonce, nonce, plaintext, []byte(entryName))
+	return ciphertext, nil
+}
+
+func (s *Store) openRef(entryName string, ciphertext []byte) (*Ref, error) {
+	if len(ciphertext) < chacha20poly1305.NonceSizeX {
+		return nil, ErrCorruptBlob
+	}
+	nonce := ciphertext[:chacha20poly1305.NonceSizeX]
+	ciphertext = ciphertext[chacha20poly1305.NonceSizeX:]
+	plaintext, err := s.refCipher.Open(nil, nonce, ciphertext, []byte(entryName))
+	if err != nil {
+		return nil, fmt.Errorf("ref box open: %w", ErrCorruptBlob)
+	}
+	if !bytes.HasPrefix(plaintext, []byte(prefixRef)) {
+		return nil, ErrCorruptBlob
+	}
+	plaintext = plaintext[len(prefixRef):]
+	var ref Ref
+	if err := json.Unmarshal(plaintext, &ref); err != nil {
+		return nil, fmt.Errorf("ref entry: %w", err)
+	}
+	return &ref, nil
+}
+
+func (s *Store) readRefEntry(ctx context.Context, bucket *blob.Bucket, entryName string) (*Ref, error) {
+	br, err := bucket.NewReader(ctx, s.config.prefix+entryName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ref read open: %w", err)
+	}
+	defer br.Close()
+	if ct := br.ContentType(); ct != contentTypeRefV1 {
+		err := &UnexpectedContentTypeError{
+			ContentType: ct,
+		}
+		return nil, err
+	}
+	// ref entries are tiny, anything big is not ours
+	const maxSize = 64 * 1024
+	ciphertext, err := io.ReadAll(io.LimitReader(br, maxSize))
+	if err != nil {
+		return nil, fmt.Errorf("ref read: %w", err)
+	}
+	return s.openRef(entryName, ciphertext)
+}
+
+// listRefEntries returns the names of all entries of a ref, oldest
+// first, excluding the store-wide prefix.
+func (s *Store) listRefEntries(ctx context.Context, bucket *blob.Bucket, boxedName string) ([]string, error) {
+	dir := refsDir + boxedName + "/"
+	iter := bucket.List(&blob.ListOptions{
+		Prefix: s.config.prefix + dir,
+	})
+	var names []string
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ref list: %w", err)
+		}
+		name := strings.TrimPrefix(obj.Key, s.config.prefix)
+		if _, ok := parseRefSeq(name); !ok {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// primaryBucket returns the bucket that is authoritative for refs.
+func (s *Store) primaryBucket() *blob.Bucket {
+	return s.config.buckets[0].bucket
+}
+
+// GetRef returns the current state of the named ref.
+//
+// A deleted ref is returned with an empty Key, so that its sequence
+// number can be used to recreate it.
+func (s *Store) GetRef(ctx context.Context, name string) (*Ref, error) {
+	if err := checkRefName(name); err != nil {
+		return nil, err
+	}
+	bucket := s.primaryBucket()
+	entries, err := s.listRefEntries(ctx, bucket, s.boxRefName(name))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrRefNotExist
+	}
+	ref, err := s.readRefEntry(ctx, bucket, entries[len(entries)-1])
+	if err != nil {
+		return nil, err
+	}
+	if ref.Name != name {
+		return nil, fmt.Errorf("ref entry for wrong name: %w", ErrCorruptBlob)
+	}
+	return ref, nil
+}
+
+// RefLog returns all the updates of the named ref, oldest first.
+func (s *Store) RefLog(ctx context.Context, name string) ([]*Ref, error) {
+	if err := checkRefName(name); err != nil {
+		return nil, err
+	}
+	bucket := s.primaryBucket()
+	entries, err := s.listRefEntries(ctx, bucket, s.boxRefName(name))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrRefNotExist
+	}
+	refs := make([]*Ref, 0, len(entries))
+	for _, entryName := range entries {
+		ref, err := s.readRefEntry(ctx, bucket, entryName)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// ListRefs returns the current state of all refs that have not been
+// deleted.
+func (s *Store) ListRefs(ctx context.Context) ([]*Ref, error) {
+	bucket := s.primaryBucket()
+	iter := bucket.List(&blob.ListOptions{
+		Prefix:    s.config.prefix + refsDir,
+		Delimiter: "/",
+	})
+	var refs []*Ref
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ref list: %w", err)
+		}
+		if !obj.IsDir {
+			continue
+		}
+		boxedName := strings.TrimSuffix(strings.TrimPrefix(obj.Key, s.config.prefix+refsDir), "/")
+		entries, err := s.listRefEntries(ctx, bucket, boxedName)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		ref, err := s.readRefEntry(ctx, bucket, entries[len(entries)-1])
+		if err != nil {
+			return nil, err
+		}
+		if s.boxRefName(ref.Name) != boxedName {
+			return nil, fmt.Errorf("ref entry for wrong name: %w", ErrCorruptBlob)
+		}
+		if ref.Deleted() {
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// SetRef points the named ref at key.
+//
+// The update only happens if the current state of the ref has
+// sequence number prevSeq, as seen in Ref.Seq; use 0 for refs that
+// do not exist yet. Otherwise, the error is ErrRefConflict.
+func (s *Store) SetRef(ctx context.Context, name string, key string, prevSeq uint64) (*Ref, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	return s.writeRef(ctx, name, key, prevSeq)
+}
+
+// DeleteRef removes the named ref. Like SetRef, it only succeeds if
+// the ref has not changed since prevSeq.
+func (s *Store) DeleteRef(ctx context.Context, name string, prevSeq uint64) error {
+	_, err := s.writeRef(ctx, name, "", prevSeq)
+	return err
+}
+
+func (s *Store) writeRef(ctx context.Context, name string, key string, prevSeq uint64) (*Ref, error) {
+	if err := checkRefName(name); err != nil {
+		return nil, err
+	}
+	if err := s.checkWritable(ctx); err != nil {
+		return nil, err
+	}
+	ref := &Ref{
+		Name: name,
+		Seq:  prevSeq + 1,
+		Key:  key,
+		Time: time.Now().UTC().Truncate(time.Second),
+	}
+	entryName := refEntryName(s.boxRefName(name), ref.Seq)
+	ciphertext, err := s.sealRef(entryName, ref)
+	if err != nil {
+		return nil, err
+	}
+	objectName := s.config.prefix + entryName
+
+	if err := writeIfNotExist(ctx, s.primaryBucket(), objectName, ciphertext, refWriterOptions(nil)); err != nil {
+		if errors.Is(err, errExists) {
+			return nil, ErrRefConflict
+		}
+		return nil, fmt.Errorf("ref write: %w", err)
+	}
+
+	var errs []error
+	for idx := 1; idx < len(s.config.buckets); idx++ {
+		alt := &s.config.buckets[idx]
+		if err := alt.bucket.WriteAll(ctx, objectName, ciphertext, refWriterOptions(nil)); err != nil {
+			errs = append(errs, fmt.Errorf("ref mirror to bucket %s: %w", alt.name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return ref, multierr.New(errs)
+	}
+	return ref, nil
+}
+
+func checkKey(key string) error {
+	hash, err := zbase32.DecodeString(key)
+	if err != nil {
+		return ErrBadKey
+	}
+	if len(hash) != dataHashSize {
+		return ErrBadKey
+	}
+	return nil
+}
+
+func refWriterOptions(beforeWrite func(as func(interface{}) bool) error) *blob.WriterOptions {
+	opts := &blob.WriterOptions{
+		CacheControl:    "no-cache",
+		ContentEncoding: "identity",
+		ContentType:     contentTypeRefV1,
+		BeforeWrite:     beforeWrite,
+	}
+	return opts
+}
+
+// preconditionSupport is how a backend supports create-if-not-exist.
+type preconditionSupport int
+
+const (
+	// emulated preconditions can lose updates
+	emulated preconditionSupport = iota
+	native
+	// nativeUnverified preconditions are sent, but some servers
+	// speaking the protocol ignore them
+	nativeUnverified
+)
+
+func bucketPreconditions(bucket *blob.Bucket) preconditionSupport {
+	if gcsClient := (*storage.Client)(nil); bucket.As(&gcsClient) {
+		return native
+	}
+	if azClient := (*container.Client)(nil); bucket.As(&azClient) {
+		return native
+	}
+	if s3Client := (*s3.S3)(nil); bucket.As(&s3Client) {
+		return nativeUnverified
+	}
+	return emulated
+}
+
+// ifNotExist makes a write fail if the object exists, on backends
+// that support it.
+func ifNotExist(as func(interface{}) bool) error {
+	// Google Cloud Storage
+	var obj **storage.ObjectHandle
+	if as(&obj) {
+		*obj = (*obj).If(storage.Conditions{
+			DoesNotExist: true,
+		})
+	}
+	// Azure
+	var azOpts *azblob.UploadStreamOptions
+	if as(&azOpts) {
+		etag := azcore.ETagAny
+		azOpts.AccessConditions = &azblobblob.AccessConditions{
+			ModifiedAccessConditions: &azblobblob.ModifiedAccessConditions{
+				IfNoneMatch: &etag,
+			},
+		}
+	}
+	// S3 with the v1 SDK, which has no field for this
+	var uploader *s3manager.Uploader
+	if as(&uploader) {
+		uploader.RequestOptions = append(uploader.RequestOptions, func(r *request.Request) {
+			r.HTTPRequest.Header.Set("If-None-Match", "*")
+		})
+	}
+	return nil
+}
+
+// isExistsConflict reports whether a write failed because of
+// ifNotExist.
+func isExistsConflict(err error) bool {
+	if gcerrors.Code(err) == gcerrors.FailedPrecondition {
+		return true
+	}
+	if bloberror.HasCode(err, bloberror.BlobAlreadyExists, bloberror.ConditionNotMet) {
+		return true
+	}
+	var s3Err awserr.RequestFailure
+	if errors.As(err, &s3Err) {
+		switch s3Err.StatusCode() {
+		case http.StatusPreconditionFailed, http.StatusConflict:
+			return true
+		}
+	}
+	return false
+}
+
+// errExists is returned by writeIfNotExist when the object already
+// exists.
+var errExists = errors.New("object already exists")
+
+// writeIfNotExist creates an object with the given options, failing
+// with errExists if it already exists. See the comment on refs for
+// which backends can lose updates.
+func writeIfNotExist(ctx context.Context, bucket *blob.Bucket, objectName string, data []byte, opts *blob.WriterOptions) error {
+	support := bucketPreconditions(bucket)
+	if support == emulated {
+		exists, err := bucket.Exists(ctx, objectName)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errExists
+		}
+	}
+	conditional := *opts
+	conditional.BeforeWrite = ifNotExist
+	if err := bucket.WriteAll(ctx, objectName, data, &conditional); err != nil {
+		if support != emulated && isExistsConflict(err) {
+			return errExists
+		}
+		return err
+	}
+	if support == native {
+		return nil
+	}
+	// Catch a concurrent writer that overwrote our object.
+	got, err := bucket.ReadAll(ctx, objectName)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	if !bytes.Equal(got, data) {
+		return errExists
+	}
+	return nil
+}
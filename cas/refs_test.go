@@ -0,0 +1,180 @@
+package cas_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"bazil.org/plop/cas"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestRefRoundtrip(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	s := cas.NewStore("s3kr1t", cas.WithBucket(b))
+
+	if _, err := s.GetRef(ctx, "greeting"); !errors.Is(err, cas.ErrRefNotExist) {
+		t.Fatalf("expected ErrRefNotExist, got %v", err)
+	}
+
+	key1, err := s.Create(ctx, strings.NewReader("hello, world\n"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	key2, err := s.Create(ctx, strings.NewReader("goodbye, world\n"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	ref, err := s.SetRef(ctx, "greeting", key1, 0)
+	if err != nil {
+		t.Fatalf("SetRef: %v", err)
+	}
+	if g, e := ref.Seq, uint64(1); g != e {
+		t.Errorf("wrong seq: %d != %d", g, e)
+	}
+	if _, err := s.SetRef(ctx, "greeting", key2, ref.Seq); err != nil {
+		t.Fatalf("SetRef: %v", err)
+	}
+
+	got, err := s.GetRef(ctx, "greeting")
+	if err != nil {
+		t.Fatalf("GetRef: %v", err)
+	}
+	if g, e := got.Key, key2; g != e {
+		t.Errorf("wrong ref key: %q != %q", g, e)
+	}
+	if g, e := got.Seq, uint64(2); g != e {
+		t.Errorf("wrong seq: %d != %d", g, e)
+	}
+
+	log, err := s.RefLog(ctx, "greeting")
+	if err != nil {
+		t.Fatalf("RefLog: %v", err)
+	}
+	if g, e := len(log), 2; g != e {
+		t.Fatalf("wrong log length: %d != %d", g, e)
+	}
+	if g, e := log[0].Key, key1; g != e {
+		t.Errorf("wrong first log entry: %q != %q", g, e)
+	}
+	if g, e := log[1].Key, key2; g != e {
+		t.Errorf("wrong second log entry: %q != %q", g, e)
+	}
+
+	// ref names must not be visible in the bucket
+	iter := b.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if strings.Contains(obj.Key, "greeting") {
+			t.Errorf("ref name leaked: %q", obj.Key)
+		}
+	}
+}
+
+func TestRefConflict(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	s := cas.NewStore("s3kr1t", cas.WithBucket(b))
+	key, err := s.Create(ctx, strings.NewReader("hello, world\n"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := s.SetRef(ctx, "greeting", key, 0); err != nil {
+		t.Fatalf("SetRef: %v", err)
+	}
+	// a writer that did not see the first update
+	if _, err := s.SetRef(ctx, "greeting", key, 0); !errors.Is(err, cas.ErrRefConflict) {
+		t.Fatalf("expected ErrRefConflict, got %v", err)
+	}
+}
+
+func TestRefDelete(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	s := cas.NewStore("s3kr1t", cas.WithBucket(b))
+	key, err := s.Create(ctx, strings.NewReader("hello, world\n"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for _, name := range []string{"one", "two"} {
+		if _, err := s.SetRef(ctx, name, key, 0); err != nil {
+			t.Fatalf("SetRef: %v", err)
+		}
+	}
+	if err := s.DeleteRef(ctx, "one", 1); err != nil {
+		t.Fatalf("DeleteRef: %v", err)
+	}
+	deleted, err := s.GetRef(ctx, "one")
+	if err != nil {
+		t.Fatalf("GetRef: %v", err)
+	}
+	if !deleted.Deleted() {
+		t.Errorf("expected deleted ref: %+v", deleted)
+	}
+	if _, err := s.SetRef(ctx, "one", key, deleted.Seq); err != nil {
+		t.Fatalf("SetRef after delete: %v", err)
+	}
+	refs, err := s.ListRefs(ctx)
+	if err != nil {
+		t.Fatalf("ListRefs: %v", err)
+	}
+	if g, e := len(refs), 2; g != e {
+		t.Fatalf("wrong number of refs: %d != %d", g, e)
+	}
+
+	if err := s.DeleteRef(ctx, "two", 1); err != nil {
+		t.Fatalf("DeleteRef: %v", err)
+	}
+	refs, err = s.ListRefs(ctx)
+	if err != nil {
+		t.Fatalf("ListRefs: %v", err)
+	}
+	if g, e := len(refs), 1; g != e {
+		t.Fatalf("wrong number of refs: %d != %d", g, e)
+	}
+	if g, e := refs[0].Name, "one"; g != e {
+		t.Errorf("wrong ref name: %q != %q", g, e)
+	}
+}
+
+func TestRefBadKey(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	s := cas.NewStore("s3kr1t", cas.WithBucket(b))
+	if _, err := s.SetRef(ctx, "greeting", "not-really-a-key", 0); !errors.Is(err, cas.ErrBadKey) {
+		t.Fatalf("expected ErrBadKey, got %v", err)
+	}
+}
+
+func TestRefMirrorError(t *testing.T) {
+	ctx := context.Background()
+	mirror := memblob.OpenBucket(nil)
+	s := cas.NewStore("s3kr1t",
+		cas.WithBucket(memblob.OpenBucket(nil), cas.BucketName("primary")),
+		cas.WithBucket(mirror, cas.BucketName("mirror")),
+	)
+	key, err := s.Create(ctx, strings.NewReader("hello, world\n"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := mirror.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.SetRef(ctx, "greeting", key, 0)
+	if err == nil {
+		t.Fatal("expected mirror error")
+	}
+	if g, e := err.Error(), "ref mirror to bucket mirror: "; !strings.Contains(g, e) {
+		t.Errorf("error does not name the mirror: %v", g)
+	}
+}
@@ -0,0 +1,253 @@
+package cas
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gocloud.dev/gcerrors"
+)
+
+// RetryPolicy controls how requests to a bucket are retried.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a request is tried, including
+	// the first attempt. Zero means one attempt.
+	MaxAttempts int
+	// MinBackoff is the wait before the first retry. Each retry
+	// doubles it, up to MaxBackoff if set. Waits are jittered by up to half
+	// their length, so clients do not retry in lockstep.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// AttemptTimeout limits how long a single attempt may take. Zero
+	// means attempts are only limited by the context.
+	AttemptTimeout time.Duration
+}
+
+// maxBucketConcurrency is how many requests may be in flight to a
+// single bucket. Throttling responses lower the limit, and it
+// recovers as requests succeed.
+const maxBucketConcurrency = 64
+
+// errorClass is how an error from a bucket is handled.
+type errorClass int
+
+const (
+	// permanent errors are not retried
+	permanent errorClass = iota
+	// transient errors may go away by trying again
+	transient
+	// throttled errors are transient, and ask us to slow down
+	throttled
+)
+
+func (c errorClass) String() string {
+	switch c {
+	case transient:
+		return "error"
+	case throttled:
+		return "throttled"
+	default:
+		return "permanent"
+	}
+}
+
+// classifyError decides whether a failed request to a bucket is
+// worth retrying.
+func classifyError(err error) errorClass {
+	var ctErr *UnexpectedContentTypeError
+	if errors.As(err, &ctErr) {
+		// not a transport problem
+		return permanent
+	}
+	switch gcerrors.Code(err) {
+	case gcerrors.ResourceExhausted:
+		return throttled
+	case gcerrors.Unknown, gcerrors.Internal, gcerrors.DeadlineExceeded:
+		// Drivers report throttling by S3 and Azure as unknown
+		// errors; look at the HTTP status.
+		if isThrottleStatus(err) {
+			return throttled
+		}
+		return transient
+	default:
+		return permanent
+	}
+}
+
+// isThrottleStatus reports whether err carries an HTTP status that
+// asks the client to slow down, such as S3 503 SlowDown.
+func isThrottleStatus(err error) bool {
+	var status int
+	// AWS SDK v1
+	var v1 interface{ StatusCode() int }
+	// AWS SDK v2
+	var v2 interface{ HTTPStatusCode() int }
+	switch {
+	case errors.As(err, &v1):
+		status = v1.StatusCode()
+	case errors.As(err, &v2):
+		status = v2.HTTPStatusCode()
+	}
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// do calls fn, retrying as the retry policy of the bucket says. Each
+// attempt waits for the rate limits of the bucket, counting upload
+// bytes, and for room under its concurrency limit. The outcome
+// counts toward the health of the bucket.
+func (alt *alternativeBucket) do(ctx context.Context, upload int64, fn func(ctx context.Context) error) error {
+	err := alt.retrying(ctx, upload, fn)
+	alt.observe(ctx, err)
+	return err
+}
+
+// doTimed is like do, and also returns how long the successful
+// attempt took, without waiting for limits or between retries. This
+// is the latency hedging compares buckets by.
+func (alt *alternativeBucket) doTimed(ctx context.Context, upload int64, fn func(ctx context.Context) error) (time.Duration, error) {
+	var took time.Duration
+	err := alt.do(ctx, upload, func(ctx context.Context) error {
+		start := time.Now()
+		err := fn(ctx)
+		took = time.Since(start)
+		return err
+	})
+	return took, err
+}
+
+func (alt *alternativeBucket) retrying(ctx context.Context, upload int64, fn func(ctx context.Context) error) error {
+	policy := alt.retry
+	backoff := policy.MinBackoff
+	for attempt := 1; ; attempt++ {
+		err := alt.attempt(ctx, upload, fn)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || attempt >= policy.MaxAttempts {
+			return err
+		}
+		class := classifyError(err)
+		if class == permanent {
+			return err
+		}
+		alt.metrics.bucketRetries.WithLabelValues(alt.name, class.String()).Inc()
+		wait := jitter(backoff)
+		trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+			attribute.String("plop.bucket", alt.name),
+			attribute.Int("plop.attempt", attempt),
+			attribute.String("plop.reason", class.String()),
+			attribute.Int64("plop.backoff_ms", wait.Milliseconds()),
+		))
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+func (alt *alternativeBucket) attempt(ctx context.Context, upload int64, fn func(ctx context.Context) error) error {
+	// wait for rate limits before taking a concurrency slot
+	if err := alt.limits.waitAttempt(ctx, upload); err != nil {
+		return err
+	}
+	if err := alt.limiter.acquire(ctx); err != nil {
+		return err
+	}
+	if alt.retry.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, alt.retry.AttemptTimeout)
+		defer cancel()
+	}
+	err := fn(ctx)
+	alt.limiter.release(err != nil && classifyError(err) == throttled)
+	return err
+}
+
+// jitter returns a random duration between d/2 and d.
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// limiter bounds the number of requests in flight to a bucket. The
+// limit is lowered multiplicatively when the bucket throttles us,
+// and raised additively as requests succeed.
+type limiter struct {
+	mu       sync.Mutex
+	limit    float64
+	max      float64
+	inFlight int
+	// freed is closed and replaced when a request finishes.
+	freed chan struct{}
+}
+
+func newLimiter(n int) *limiter {
+	l := &limiter{
+		limit: float64(n),
+		max:   float64(n),
+		freed: make(chan struct{}),
+	}
+	return l
+}
+
+// acquire waits until another request may be started.
+func (l *limiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if float64(l.inFlight) < l.limit {
+			l.inFlight++
+			l.mu.Unlock()
+			return nil
+		}
+		freed := l.freed
+		l.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-freed:
+		}
+	}
+}
+
+// release marks a request as finished. If the bucket throttled the
+// request, the limit is lowered.
+func (l *limiter) release(slowDown bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	if slowDown {
+		l.limit /= 2
+		if l.limit < 1 {
+			l.limit = 1
+		}
+	} else if l.limit < l.max {
+		l.limit += 1 / l.limit
+		if l.limit > l.max {
+			l.limit = l.max
+		}
+	}
+	close(l.freed)
+	l.freed = make(chan struct{})
+}
+
+// current returns the current concurrency limit.
+func (l *limiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
@@ -0,0 +1,179 @@
+package cas
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gocloud.dev/blob/memblob"
+)
+
+// statusError looks like an AWS SDK request failure.
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string {
+	return http.StatusText(e.status)
+}
+
+func (e *statusError) StatusCode() int {
+	return e.status
+}
+
+func testBucket(name string, policy RetryPolicy) *alternativeBucket {
+	alt := &alternativeBucket{
+		name:    name,
+		bucket:  memblob.OpenBucket(nil),
+		retry:   policy,
+		limiter: newLimiter(maxBucketConcurrency),
+		health:  &health{},
+		metrics: newStoreMetrics(nil),
+	}
+	return alt
+}
+
+func TestRetryTransient(t *testing.T) {
+	ctx := context.Background()
+	const name = "test-retry-transient"
+	alt := testBucket(name, RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond})
+	calls := 0
+	err := alt.do(ctx, 0, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errors.New("connection reset for test")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	if g, e := calls, 3; g != e {
+		t.Errorf("wrong number of attempts: %d != %d", g, e)
+	}
+	if g, e := testutil.ToFloat64(alt.metrics.bucketRetries.WithLabelValues(name, "error")), 2.0; g != e {
+		t.Errorf("wrong retries: %v != %v", g, e)
+	}
+}
+
+func TestRetryGiveUp(t *testing.T) {
+	ctx := context.Background()
+	alt := testBucket("test-retry-give-up", RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond})
+	calls := 0
+	errFail := errors.New("fail for test")
+	err := alt.do(ctx, 0, func(ctx context.Context) error {
+		calls++
+		return errFail
+	})
+	if !errors.Is(err, errFail) {
+		t.Fatalf("wrong error: %v", err)
+	}
+	if g, e := calls, 2; g != e {
+		t.Errorf("wrong number of attempts: %d != %d", g, e)
+	}
+}
+
+func TestRetryPermanent(t *testing.T) {
+	ctx := context.Background()
+	alt := testBucket("test-retry-permanent", RetryPolicy{MaxAttempts: 5, MinBackoff: time.Millisecond})
+	calls := 0
+	err := alt.do(ctx, 0, func(ctx context.Context) error {
+		calls++
+		_, err := alt.bucket.ReadAll(ctx, "does-not-exist")
+		return err
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if g, e := calls, 1; g != e {
+		t.Errorf("not found must not be retried: %d attempts", g)
+	}
+}
+
+func TestRetryAttemptTimeout(t *testing.T) {
+	ctx := context.Background()
+	alt := testBucket("test-retry-timeout", RetryPolicy{
+		MaxAttempts:    2,
+		MinBackoff:     time.Millisecond,
+		AttemptTimeout: 10 * time.Millisecond,
+	})
+	calls := 0
+	err := alt.do(ctx, 0, func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			// hang until the attempt times out
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	if g, e := calls, 2; g != e {
+		t.Errorf("wrong number of attempts: %d != %d", g, e)
+	}
+}
+
+func TestRetryThrottled(t *testing.T) {
+	ctx := context.Background()
+	const name = "test-retry-throttled"
+	alt := testBucket(name, RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond})
+	calls := 0
+	err := alt.do(ctx, 0, func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			return &statusError{status: http.StatusServiceUnavailable}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	if g, e := testutil.ToFloat64(alt.metrics.bucketRetries.WithLabelValues(name, "throttled")), 1.0; g != e {
+		t.Errorf("wrong throttled retries: %v != %v", g, e)
+	}
+	// halved by the throttling, then raised a little by the success
+	if g, e := alt.limiter.current(), maxBucketConcurrency/2; g != e {
+		t.Errorf("wrong concurrency limit: %d != %d", g, e)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := newLimiter(4)
+	for i := 0; i < 3; i++ {
+		if err := l.acquire(ctx); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		l.release(true)
+	}
+	if g, e := l.current(), 1; g != e {
+		t.Fatalf("wrong limit after throttling: %d != %d", g, e)
+	}
+
+	if err := l.acquire(ctx); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	// no room for a second request
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(timeoutCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected to wait for room: %v", err)
+	}
+	l.release(false)
+
+	// successes recover the limit
+	for i := 0; i < 100; i++ {
+		if err := l.acquire(ctx); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		l.release(false)
+	}
+	if g, e := l.current(), 4; g != e {
+		t.Errorf("limit did not recover: %d != %d", g, e)
+	}
+}
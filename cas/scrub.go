@@ -0,0 +1,84 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+
+	"gocloud.dev/blob"
+)
+
+// ErrNoChecksum is the error for objects the backend has no checksum
+// for, so they cannot be scrubbed.
+var ErrNoChecksum = errors.New("backend has no checksum for object")
+
+// ScrubResult is the outcome of checking one stored object.
+type ScrubResult struct {
+	Key  string
+	Size int64
+	// Err is nil if the object matches the checksum the backend has
+	// for it. A mismatch is a *ChecksumError.
+	Err error
+}
+
+// Scrub checks every object in the bucket under prefix against the
+// checksum the backend has stored for it, calling fn for each
+// object. Only the ciphertext is checked, so no passphrase is
+// needed.
+//
+// Errors for single objects are passed to fn. Errors from fn, and
+// errors listing the bucket, stop the scrub.
+func Scrub(ctx context.Context, bucket *blob.Bucket, prefix string, fn func(ScrubResult) error) error {
+	iter := bucket.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("listing bucket: %w", err)
+		}
+		if obj.IsDir {
+			continue
+		}
+		res := ScrubResult{
+			Key:  obj.Key,
+			Size: obj.Size,
+			Err:  scrubObject(ctx, bucket, obj),
+		}
+		if err := fn(res); err != nil {
+			return err
+		}
+	}
+}
+
+func scrubObject(ctx context.Context, bucket *blob.Bucket, obj *blob.ListObject) error {
+	want := obj.MD5
+	if len(want) == 0 {
+		// not all backends list checksums
+		attrs, err := bucket.Attributes(ctx, obj.Key)
+		if err != nil {
+			return err
+		}
+		want = attrs.MD5
+	}
+	if len(want) == 0 {
+		return ErrNoChecksum
+	}
+	br, err := bucket.NewReader(ctx, obj.Key, nil)
+	if err != nil {
+		return err
+	}
+	defer br.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, br); err != nil {
+		return err
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, want) {
+		return &ChecksumError{Key: obj.Key, Want: want, Got: got}
+	}
+	return nil
+}
@@ -17,11 +17,14 @@ import (
 	"bytes"
 	"context"
 	"crypto/cipher"
+	"crypto/md5"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"bazil.org/plop/internal/multierr"
@@ -29,9 +32,12 @@ import (
 	"cloud.google.com/go/storage"
 	"github.com/dgryski/go-s4lru"
 	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/restic/chunker"
 	"github.com/tv42/zbase32"
 	"github.com/zeebo/blake3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gocloud.dev/blob"
 	"gocloud.dev/gcerrors"
 	"golang.org/x/crypto/argon2"
@@ -54,6 +60,30 @@ func (u *UnexpectedContentTypeError) Error() string {
 	return fmt.Sprintf("unexpected Content-Type: %q", u.ContentType)
 }
 
+// CorruptObjectError reports an object that was found in a bucket,
+// but could not be decoded. It matches ErrCorruptBlob with
+// errors.Is.
+type CorruptObjectError struct {
+	// Bucket is the name of the bucket holding the corrupted copy.
+	Bucket   string
+	BoxedKey string
+	Err      error
+}
+
+var _ error = (*CorruptObjectError)(nil)
+
+func (c *CorruptObjectError) Error() string {
+	return fmt.Sprintf("corrupt object %s in bucket %s: %v", c.BoxedKey, c.Bucket, c.Err)
+}
+
+func (c *CorruptObjectError) Is(target error) bool {
+	return target == ErrCorruptBlob
+}
+
+func (c *CorruptObjectError) Unwrap() error {
+	return c.Err
+}
+
 // Length of keys before encoding.
 const dataHashSize = 32
 
@@ -96,9 +126,19 @@ func newCipher(secret []byte) cipher.AEAD {
 }
 
 type alternativeBucket struct {
+	name      string
 	delay     time.Duration
 	bucket    *blob.Bucket
 	shardBits uint8
+	retry     RetryPolicy
+	limiter   *limiter
+	limits    Limits
+	health    *health
+	// recent latency of successful requests, for hedging
+	readLatency  *multiflight.Latency
+	writeLatency *multiflight.Latency
+	// metrics is shared with the Store.
+	metrics *storeMetrics
 }
 
 type config struct {
@@ -106,6 +146,12 @@ type config struct {
 	chunkMax     uint32
 	chunkAvgBits int
 	buckets      []alternativeBucket
+	// prefix is prepended to all object names, to let multiple
+	// volumes share a bucket.
+	prefix  string
+	catalog Cataloger
+	// registerer receives the metrics of the store, if set.
+	registerer prometheus.Registerer
 }
 
 type Store struct {
@@ -114,10 +160,25 @@ type Store struct {
 	hashSecret        []byte
 	nonceSecret       []byte
 	dataCipher        cipher.AEAD
+	descriptorCipher  cipher.AEAD
+	verifier          []byte
+	refNameSecret     []byte
+	refCipher         cipher.AEAD
 	chunkerPolynomial chunker.Pol
 
 	cacheMu sync.Mutex
 	cache   *s4lru.Cache
+
+	metrics *storeMetrics
+
+	// uninitialized is set for volumes with objects but no
+	// descriptor, see PrepareVolume.
+	uninitialized atomic.Bool
+	// pendingInit is set when PrepareVolume found no descriptor,
+	// until the first write decides whether to initialize the
+	// volume. initMu serializes that decision.
+	pendingInit atomic.Bool
+	initMu      sync.Mutex
 }
 
 func mustBlake3NewKeyed(key []byte) *blake3.Hasher {
@@ -182,7 +243,27 @@ func NewStore(sharingPassphrase string, opts ...Option) *Store {
 			sharingSecret,
 			32,
 		),
-		dataCipher:        newCipher(blobSecret),
+		dataCipher: newCipher(blobSecret),
+		descriptorCipher: newCipher(blake3DeriveKeySized(
+			"bazil.org/plop 2026-10-18 volume descriptor cipher",
+			sharingSecret,
+			chacha20poly1305.KeySize,
+		)),
+		verifier: blake3DeriveKeySized(
+			"bazil.org/plop 2026-10-18 volume passphrase verifier",
+			sharingSecret,
+			32,
+		),
+		refNameSecret: blake3DeriveKeySized(
+			"bazil.org/plop 2026-10-18 ref name boxing",
+			sharingSecret,
+			32,
+		),
+		refCipher: newCipher(blake3DeriveKeySized(
+			"bazil.org/plop 2026-10-18 ref cipher",
+			sharingSecret,
+			chacha20poly1305.KeySize,
+		)),
 		chunkerPolynomial: chunkerPolynomial,
 
 		// Cache objects are typically ~8MB, so keep it small. All we
@@ -209,6 +290,12 @@ func NewStore(sharingPassphrase string, opts ...Option) *Store {
 	if len(s.config.buckets) == 0 {
 		panic("cas.NewStore must have at least one bucket")
 	}
+	s.metrics = newStoreMetrics(s.config.registerer)
+	for i := range s.config.buckets {
+		alt := &s.config.buckets[i]
+		alt.metrics = s.metrics
+		alt.metrics.bucketState.WithLabelValues(alt.name).Set(float64(BucketHealthy))
+	}
 	return s
 }
 
@@ -238,7 +325,8 @@ func (s *Store) boxKey(key []byte) []byte {
 	return boxedKey
 }
 
-func (s *Store) uploadToBackend(ctx context.Context, bucket *blob.Bucket, boxedKey string, data []byte) error {
+func (s *Store) uploadToBackend(ctx context.Context, alt *alternativeBucket, boxedKey string, data []byte) error {
+	bucket := alt.bucket
 	hasCreateIfNotExist := false
 	if gcsClient := (*storage.Client)(nil); bucket.As(&gcsClient) {
 		hasCreateIfNotExist = true
@@ -253,20 +341,33 @@ func (s *Store) uploadToBackend(ctx context.Context, bucket *blob.Bucket, boxedK
 			// With multiple alternative buckets, this can still cause
 			// some duplication (and is skipped for small objects,
 			// anyway).
-			exists, err := bucket.Exists(ctx, boxedKey)
+			var exists bool
+			err := alt.do(ctx, 0, func(ctx context.Context) error {
+				start := time.Now()
+				var err error
+				exists, err = bucket.Exists(ctx, boxedKey)
+				alt.metrics.observeRequest(alt.name, "exists", start, err)
+				return err
+			})
 			if err != nil {
 				return err
 			}
 			if exists {
+				alt.metrics.dedupHits.WithLabelValues(alt.name).Inc()
 				return nil
 			}
 		}
 	}
 
+	// Backends check the data they receive against these, to catch
+	// corruption in transit.
+	sum := md5.Sum(data)
+	crc := crc32.Checksum(data, castagnoli)
 	opts := &blob.WriterOptions{
 		CacheControl:    "public, max-age=2147483648, immutable",
 		ContentEncoding: "identity",
 		ContentType:     contentTypeV1,
+		ContentMD5:      sum[:],
 		BeforeWrite: func(as func(interface{}) bool) error {
 			// do not add more preconditions without considering the
 			// error checking below
@@ -277,28 +378,46 @@ func (s *Store) uploadToBackend(ctx context.Context, bucket *blob.Bucket, boxedK
 					GenerationMatch: 0,
 				})
 			}
+			var w *storage.Writer
+			if as(&w) {
+				w.CRC32C = crc
+				w.SendCRC32C = true
+			}
 			return nil
 		},
 	}
-	if err := bucket.WriteAll(ctx, boxedKey, data, opts); err != nil {
+	took, err := alt.doTimed(ctx, int64(len(data)), func(ctx context.Context) error {
+		start := time.Now()
+		err := bucket.WriteAll(ctx, boxedKey, data, opts)
+		alt.metrics.observeRequest(alt.name, "write", start, err)
+		return err
+	})
+	if err != nil {
 		switch gcerrors.Code(err) {
 		case gcerrors.AlreadyExists:
+			alt.metrics.dedupHits.WithLabelValues(alt.name).Inc()
 			return nil
 		case gcerrors.FailedPrecondition:
+			alt.metrics.dedupHits.WithLabelValues(alt.name).Inc()
 			return nil
 		}
 		return fmt.Errorf("object write: %w", err)
 	}
+	alt.writeLatency.Observe(took)
+	alt.metrics.bucketBytes.WithLabelValues(alt.name, "write").Add(float64(len(data)))
 	return nil
 }
 
-func (s *Store) downloadFromBackend(ctx context.Context, bucket *blob.Bucket, boxedKey string, prefix constantString) ([]byte, error) {
+// downloadFromBackend reads an object from the bucket. The object is
+// charged to the download limits before its content is read; waited
+// is how long that took.
+func (s *Store) downloadFromBackend(ctx context.Context, alt *alternativeBucket, boxedKey string, prefix constantString) (_ []byte, waited time.Duration, _ error) {
 	opts := &blob.ReaderOptions{
 		// TODO BeforeRead
 	}
-	br, err := bucket.NewReader(ctx, boxedKey, opts)
+	br, err := alt.bucket.NewReader(ctx, boxedKey, opts)
 	if err != nil {
-		return nil, fmt.Errorf("object read open: %w", err)
+		return nil, 0, fmt.Errorf("object read open: %w", err)
 	}
 	defer br.Close()
 	switch ct := br.ContentType(); ct {
@@ -306,10 +425,23 @@ func (s *Store) downloadFromBackend(ctx context.Context, bucket *blob.Bucket, bo
 		err := &UnexpectedContentTypeError{
 			ContentType: ct,
 		}
-		return nil, err
+		return nil, 0, err
 
 	case contentTypeV1:
-		return s._downloadFromBackendV1(prefix, br)
+		start := time.Now()
+		err := alt.limits.waitDownload(ctx, br.Size())
+		waited = time.Since(start)
+		if err != nil {
+			return nil, waited, err
+		}
+		buf, err := s._downloadFromBackendV1(prefix, br)
+		if err != nil {
+			return nil, waited, err
+		}
+		if err := checkMD5(boxedKey, readerMD5(br), buf); err != nil {
+			return nil, waited, err
+		}
+		return buf, waited, nil
 	}
 }
 
@@ -346,10 +478,25 @@ func shardPrefix(boxedKeyRaw []byte, shardBits uint8) string {
 	return shard + "/"
 }
 
+// objectName returns the name of the object in the given bucket.
+func (s *Store) objectName(alt *alternativeBucket, boxedKeyRaw []byte, boxedKey string) string {
+	return s.config.prefix + shardPrefix(boxedKeyRaw, alt.shardBits) + boxedKey
+}
+
 // not using Pool.New because zstd.NewWriter can return an error
 var zstdEncoders sync.Pool
 
-func (s *Store) saveObject(ctx context.Context, prefix constantString, plaintext []byte) (key []byte, boxedKey string, _ error) {
+func (s *Store) saveObject(ctx context.Context, prefix constantString, plaintext []byte) (key []byte, boxedKey string, err error) {
+	ctx, span := tracer.Start(ctx, "cas.saveObject", trace.WithAttributes(
+		attribute.String("plop.type", objectType(prefix)),
+		attribute.Int("plop.size", len(plaintext)),
+	))
+	defer func() { endSpan(span, err) }()
+	if err := s.checkWritable(ctx); err != nil {
+		return nil, "", err
+	}
+
+	_, encodeSpan := tracer.Start(ctx, "cas.encode")
 	hash := s.hashData(prefix, plaintext)
 	nonce := s.nonce(hash)
 	var zbuf bytes.Buffer
@@ -378,24 +525,40 @@ func (s *Store) saveObject(ctx context.Context, prefix constantString, plaintext
 	}
 	compressed := zbuf.Bytes()
 	ciphertext := s.dataCipher.Seal(compressed[:0], nonce, compressed, hash)
+	encodeSpan.End()
 
 	boxedKeyRaw := s.boxKey(hash)
 	boxedKey = zbase32.EncodeToString(boxedKeyRaw)
+	span.SetAttributes(
+		attribute.String("plop.boxed_key", boxedKey),
+		attribute.Int("plop.stored_size", len(ciphertext)),
+	)
 
 	m := multiflight.New()
-	for _, alt := range s.config.buckets {
-		bucket := alt.bucket
-		objectName := shardPrefix(boxedKeyRaw, alt.shardBits) + boxedKey
+	m.SetName("save")
+	m.SetOnFallback(s.countFallback("save"))
+	delays := s.hedgeDelays(writeLatency)
+	use, skipped := s.usableBuckets()
+	for _, i := range use {
+		alt := &s.config.buckets[i]
+		objectName := s.objectName(alt, boxedKeyRaw, boxedKey)
 		upload := func(ctx context.Context) (interface{}, error) {
-			if err := s.uploadToBackend(ctx, bucket, objectName, ciphertext); err != nil {
+			ctx, span := tracer.Start(ctx, "cas.upload", trace.WithAttributes(
+				attribute.String("plop.bucket", alt.name),
+				attribute.String("plop.object", objectName),
+				attribute.Int("plop.stored_size", len(ciphertext)),
+			))
+			err := s.uploadToBackend(ctx, alt, objectName, ciphertext)
+			endSpan(span, err)
+			if err != nil {
 				return nil, err
 			}
 			return nil, nil
 		}
-		m.Add(alt.delay, upload)
+		m.Add(delays[i], upload)
 	}
 	if _, err := m.Run(ctx); err != nil {
-		return nil, "", err
+		return nil, "", withSkipped(err, skipped)
 	}
 	return hash, boxedKey, nil
 }
@@ -403,28 +566,88 @@ func (s *Store) saveObject(ctx context.Context, prefix constantString, plaintext
 // not using Pool.New because zstd.NewReader can return an error
 var zstdDecoders sync.Pool
 
-func (s *Store) loadObject(ctx context.Context, prefix constantString, hash []byte) ([]byte, error) {
+func (s *Store) loadObject(ctx context.Context, prefix constantString, hash []byte) (_ []byte, err error) {
 	boxedKeyRaw := s.boxKey(hash)
 	boxedKey := zbase32.EncodeToString(boxedKeyRaw)
+	ctx, span := tracer.Start(ctx, "cas.loadObject", trace.WithAttributes(
+		attribute.String("plop.type", objectType(prefix)),
+		attribute.String("plop.boxed_key", boxedKey),
+	))
+	defer func() { endSpan(span, err) }()
 
 	m := multiflight.New()
-	for _, alt := range s.config.buckets {
-		bucket := alt.bucket
-		objectName := shardPrefix(boxedKeyRaw, alt.shardBits) + boxedKey
-		download := func(ctx context.Context) (interface{}, error) {
-			ciphertext, err := s.downloadFromBackend(ctx, bucket, objectName, prefix)
+	m.SetName("load")
+	m.SetOnFallback(s.countFallback("load"))
+	delays := s.hedgeDelays(readLatency)
+	use, skipped := s.usableBuckets()
+	for _, i := range use {
+		alt := &s.config.buckets[i]
+		objectName := s.objectName(alt, boxedKeyRaw, boxedKey)
+		fetch := func(ctx context.Context) (_ interface{}, err error) {
+			ctx, span := tracer.Start(ctx, "cas.download", trace.WithAttributes(
+				attribute.String("plop.bucket", alt.name),
+				attribute.String("plop.object", objectName),
+			))
+			defer func() { endSpan(span, err) }()
+			var ciphertext []byte
+			var waited time.Duration
+			took, err := alt.doTimed(ctx, 0, func(ctx context.Context) error {
+				start := time.Now()
+				var err error
+				ciphertext, waited, err = s.downloadFromBackend(ctx, alt, objectName, prefix)
+				alt.metrics.observeRequest(alt.name, "read", start.Add(waited), err)
+				return err
+			})
+			// Waiting for the download limits is not the bucket
+			// being slow, and must not make hedging start another
+			// download.
+			took -= waited
+			if err == nil {
+				alt.readLatency.Observe(took)
+				alt.metrics.bucketBytes.WithLabelValues(alt.name, "read").Add(float64(len(ciphertext)))
+				span.SetAttributes(attribute.Int("plop.stored_size", len(ciphertext)))
+			}
+			var ctErr *UnexpectedContentTypeError
+			var sumErr *ChecksumError
+			if errors.As(err, &ctErr) || errors.As(err, &sumErr) {
+				err = &CorruptObjectError{
+					Bucket:   alt.name,
+					BoxedKey: boxedKey,
+					Err:      err,
+				}
+			}
 			if err != nil {
 				return nil, err
 			}
-			return ciphertext, nil
+			// Decode each copy separately, so a corrupted copy does
+			// not prevent another bucket from serving the object.
+			_, decodeSpan := tracer.Start(ctx, "cas.decode")
+			plaintext, err := s.decodeObject(prefix, hash, ciphertext)
+			endSpan(decodeSpan, err)
+			if err != nil {
+				err := &CorruptObjectError{
+					Bucket:   alt.name,
+					BoxedKey: boxedKey,
+					Err:      err,
+				}
+				return nil, err
+			}
+			return plaintext, nil
 		}
-		m.Add(alt.delay, download)
+		m.Add(delays[i], fetch)
 	}
 	result, err := m.Run(ctx)
 	if err != nil {
-		return nil, err
+		return nil, withSkipped(err, skipped)
 	}
-	ciphertext := result.([]byte)
+	buf := result.([]byte)
+	span.SetAttributes(attribute.Int("plop.size", len(buf)))
+	return buf, nil
+}
+
+// decodeObject opens the box and uncompresses an object downloaded
+// from a bucket.
+func (s *Store) decodeObject(prefix constantString, hash []byte, ciphertext []byte) ([]byte, error) {
 	nonce := s.nonce(hash)
 	compressed, err := s.dataCipher.Open(ciphertext[:0], nonce, ciphertext, hash)
 	if err != nil {
@@ -473,19 +696,37 @@ func (s *Store) loadObjectCached(ctx context.Context, prefix constantString, has
 	s.cacheMu.Unlock()
 	if ok {
 		// cache hit
+		s.metrics.cacheHits.Inc()
 		buf := cache.([]byte)
 		return buf, nil
 	}
 
 	// cache miss
+	s.metrics.cacheMisses.Inc()
 	buf, err := s.loadObject(ctx, prefix, hash)
 	if err != nil {
 		return nil, err
 	}
+	if s.cacheObject(cacheKey, buf) {
+		s.metrics.cacheEvictions.Inc()
+	}
+	return buf, nil
+}
+
+// cacheObject adds an object to the cache, unless a concurrent load
+// already did. It reports whether another object was evicted to make
+// room.
+func (s *Store) cacheObject(cacheKey string, buf []byte) (evicted bool) {
 	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	if _, ok := s.cache.Get(cacheKey); ok {
+		// Set would add a second entry for the key
+		return false
+	}
+	before := s.cache.Len()
 	s.cache.Set(cacheKey, buf)
-	s.cacheMu.Unlock()
-	return buf, nil
+	// a full cache reuses its oldest entry
+	return s.cache.Len() == before
 }
 
 func (s *Store) saveExtents(ctx context.Context, plaintext []byte) (string, error) {
@@ -497,7 +738,14 @@ func (s *Store) saveExtents(ctx context.Context, plaintext []byte) (string, erro
 	return key, nil
 }
 
-func (s *Store) Create(ctx context.Context, r io.Reader) (string, error) {
+func (s *Store) Create(ctx context.Context, r io.Reader, opts ...CreateOption) (_ string, err error) {
+	ctx, span := tracer.Start(ctx, "cas.Create")
+	defer func() { endSpan(span, err) }()
+
+	var createConfig createConfig
+	for _, opt := range opts {
+		opt(&createConfig)
+	}
 	var extents bytes.Buffer
 	ch := chunker.NewWithBoundaries(r, s.chunkerPolynomial,
 		// uint32 to uint is always safe
@@ -506,14 +754,21 @@ func (s *Store) Create(ctx context.Context, r io.Reader) (string, error) {
 	buf := make([]byte, 8*1024*1024)
 	extent := make([]byte, 8+32)
 	var offset uint64
+	var chunks int
 	for {
+		_, chunkSpan := tracer.Start(ctx, "cas.chunk")
 		chunk, err := ch.Next(buf)
 		if err == io.EOF {
+			chunkSpan.End()
 			break
 		}
 		if err != nil {
+			endSpan(chunkSpan, err)
 			return "", err
 		}
+		chunkSpan.SetAttributes(attribute.Int("plop.size", len(chunk.Data)))
+		chunkSpan.End()
+		chunks++
 		keyRaw, _, err := s.saveObject(ctx, prefixBlob, chunk.Data)
 		if err != nil {
 			return "", err
@@ -532,12 +787,41 @@ func (s *Store) Create(ctx context.Context, r io.Reader) (string, error) {
 			panic("extent key length error")
 		}
 		_, _ = extents.Write(extent)
+		if createConfig.progress != nil {
+			// uint64 to int64 is safe for any real content
+			createConfig.progress(int64(offset))
+		}
 	}
 
-	return s.saveExtents(ctx, extents.Bytes())
+	key, err := s.saveExtents(ctx, extents.Bytes())
+	if err != nil {
+		return "", err
+	}
+	if s.config.catalog != nil && !createConfig.noCatalog {
+		entry := &CatalogEntry{
+			Key:    key,
+			Size:   int64(offset),
+			Source: createConfig.source,
+			Time:   time.Now().UTC().Truncate(time.Second),
+			Labels: createConfig.labels,
+		}
+		if err := s.config.catalog.Record(ctx, entry); err != nil {
+			return "", fmt.Errorf("catalog: %w", err)
+		}
+	}
+	span.SetAttributes(
+		attribute.String("plop.key", key),
+		attribute.Int64("plop.size", int64(offset)),
+		attribute.Int("plop.chunks", chunks),
+	)
+	return key, nil
 }
 
-func (s *Store) Open(ctx context.Context, key string) (*Handle, error) {
+func (s *Store) Open(ctx context.Context, key string) (_ *Handle, err error) {
+	ctx, span := tracer.Start(ctx, "cas.Open", trace.WithAttributes(
+		attribute.String("plop.key", key),
+	))
+	defer func() { endSpan(span, err) }()
 	return newHandle(ctx, s, key)
 }
 
@@ -562,6 +846,50 @@ func (s *Store) DebugReadBlob(ctx context.Context, blobKey string) ([]byte, erro
 	return buf, nil
 }
 
+// Locate reports the names of the buckets that hold the object, as
+// set by BucketName. Requests are retried, rate limited and skip
+// failing buckets like other requests; buckets that could not be
+// checked are reported in the error.
+//
+// Only the extents object is checked for, not the blobs it refers
+// to.
+func (s *Store) Locate(ctx context.Context, key string) ([]string, error) {
+	hash, err := zbase32.DecodeString(key)
+	if err != nil {
+		return nil, ErrBadKey
+	}
+	if len(hash) != dataHashSize {
+		return nil, ErrBadKey
+	}
+	boxedKeyRaw := s.boxKey(hash)
+	boxedKey := zbase32.EncodeToString(boxedKeyRaw)
+	var names []string
+	use, errs := s.usableBuckets()
+	for _, i := range use {
+		alt := &s.config.buckets[i]
+		objectName := s.objectName(alt, boxedKeyRaw, boxedKey)
+		var exists bool
+		err := alt.do(ctx, 0, func(ctx context.Context) error {
+			start := time.Now()
+			var err error
+			exists, err = alt.bucket.Exists(ctx, objectName)
+			alt.metrics.observeRequest(alt.name, "exists", start, err)
+			return err
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("bucket %s: %w", alt.name, err))
+			continue
+		}
+		if exists {
+			names = append(names, alt.name)
+		}
+	}
+	if len(errs) > 0 {
+		return names, multierr.New(errs)
+	}
+	return names, nil
+}
+
 func (s *Store) DebugBoxKey(key string) (string, error) {
 	hash, err := zbase32.DecodeString(key)
 	if err != nil {
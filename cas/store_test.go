@@ -2,10 +2,12 @@ package cas_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
 	"testing"
+	"time"
 
 	"bazil.org/plop/cas"
 	"gocloud.dev/blob"
@@ -342,3 +344,135 @@ func TestDebugBoxKey(t *testing.T) {
 		t.Errorf("bad boxed key: %q != %q", g, e)
 	}
 }
+
+func TestLocate(t *testing.T) {
+	ctx := context.Background()
+	b1 := memblob.OpenBucket(nil)
+	b2 := memblob.OpenBucket(nil)
+	one := cas.NewStore("s3kr1t", cas.WithBucket(b1, cas.BucketName("one")))
+	key, err := one.Create(ctx, strings.NewReader("hello, world\n"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	both := cas.NewStore("s3kr1t",
+		cas.WithBucket(b1, cas.BucketName("one")),
+		cas.WithBucket(b2, cas.BucketName("two")),
+	)
+	got, err := both.Locate(ctx, key)
+	if err != nil {
+		t.Fatalf("Locate: %v", err)
+	}
+	if g, e := strings.Join(got, ","), "one"; g != e {
+		t.Errorf("wrong buckets: %q != %q", g, e)
+	}
+}
+
+// copyAndCorrupt copies all objects from one bucket to another, and then
+// flips a bit in every object in the source bucket.
+func copyAndCorrupt(t testing.TB, src, dst *blob.Bucket) {
+	t.Helper()
+	ctx := context.Background()
+	iter := src.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		attrs, err := src.Attributes(ctx, obj.Key)
+		if err != nil {
+			t.Fatalf("attributes: %v", err)
+		}
+		buf, err := src.ReadAll(ctx, obj.Key)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		opts := &blob.WriterOptions{
+			ContentType: attrs.ContentType,
+		}
+		if err := dst.WriteAll(ctx, obj.Key, buf, opts); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		buf[len(buf)/2] ^= 0x01
+		if err := src.WriteAll(ctx, obj.Key, buf, opts); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+}
+
+func TestCorruptObject(t *testing.T) {
+	ctx := context.Background()
+	b1 := memblob.OpenBucket(nil)
+	b2 := memblob.OpenBucket(nil)
+	both := []cas.Option{
+		cas.WithBucket(b1, cas.BucketName("one")),
+		cas.WithBucket(b2, cas.BucketName("two"), cas.BucketAfter(1*time.Hour)),
+	}
+	const greeting = "hello, world\n"
+	key, err := cas.NewStore("s3kr1t", both...).Create(ctx, strings.NewReader(greeting))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	copyAndCorrupt(t, b1, b2)
+
+	t.Run("fallback", func(t *testing.T) {
+		s := cas.NewStore("s3kr1t", both...)
+		h, err := s.Open(ctx, key)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		buf, err := io.ReadAll(io.NewSectionReader(h.IO(ctx), 0, h.Size()))
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if g, e := string(buf), greeting; g != e {
+			t.Errorf("bad content: %q != %q", g, e)
+		}
+	})
+
+	t.Run("only", func(t *testing.T) {
+		s := cas.NewStore("s3kr1t", cas.WithBucket(b1, cas.BucketName("one")))
+		_, err := s.Open(ctx, key)
+		if !errors.Is(err, cas.ErrCorruptBlob) {
+			t.Fatalf("expected corrupt blob error: %v", err)
+		}
+		var corrupt *cas.CorruptObjectError
+		if !errors.As(err, &corrupt) {
+			t.Fatalf("expected CorruptObjectError: %T: %v", err, err)
+		}
+		if g, e := corrupt.Bucket, "one"; g != e {
+			t.Errorf("wrong bucket: %q != %q", g, e)
+		}
+	})
+}
+
+func TestCreateProgress(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	const chunkSize = 100
+	s := cas.NewStore("s3kr1t",
+		cas.WithBucket(b),
+		cas.WithChunkLimits(chunkSize, chunkSize),
+	)
+	greeting := strings.Repeat("hello, world\n", 20)
+	var progress []int64
+	fn := func(stored int64) {
+		progress = append(progress, stored)
+	}
+	if _, err := s.Create(ctx, strings.NewReader(greeting), cas.CreateProgress(fn)); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if len(progress) < 2 {
+		t.Fatalf("expected progress for every chunk: %v", progress)
+	}
+	for i := 1; i < len(progress); i++ {
+		if progress[i] <= progress[i-1] {
+			t.Errorf("progress went backwards: %v", progress)
+		}
+	}
+	if g, e := progress[len(progress)-1], int64(len(greeting)); g != e {
+		t.Errorf("wrong final progress: %d != %d", g, e)
+	}
+}
@@ -0,0 +1,30 @@
+package cas
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("bazil.org/plop/cas")
+
+// objectType names the type of object for span attributes.
+func objectType(prefix constantString) string {
+	switch prefix {
+	case prefixBlob:
+		return "blob"
+	case prefixExtents:
+		return "extents"
+	default:
+		return "unknown"
+	}
+}
+
+// endSpan records err, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
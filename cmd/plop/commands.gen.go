@@ -5,11 +5,22 @@ package main
 import (
 	_ "bazil.org/plop/internal/cli"
 	_ "bazil.org/plop/internal/cli/add"
+	_ "bazil.org/plop/internal/cli/annex-remote"
+	_ "bazil.org/plop/internal/cli/daemon"
 	_ "bazil.org/plop/internal/cli/debug/blob/read"
 	_ "bazil.org/plop/internal/cli/debug/boxkey"
 	_ "bazil.org/plop/internal/cli/debug/extents"
 	_ "bazil.org/plop/internal/cli/debug/shard"
+	_ "bazil.org/plop/internal/cli/init"
+	_ "bazil.org/plop/internal/cli/lfs-transfer"
+	_ "bazil.org/plop/internal/cli/ls"
 	_ "bazil.org/plop/internal/cli/mount"
 	_ "bazil.org/plop/internal/cli/read"
+	_ "bazil.org/plop/internal/cli/ref/get"
+	_ "bazil.org/plop/internal/cli/ref/log"
+	_ "bazil.org/plop/internal/cli/ref/set"
+	_ "bazil.org/plop/internal/cli/scrub"
+	_ "bazil.org/plop/internal/cli/status"
+	_ "bazil.org/plop/internal/cli/unlock"
 	_ "bazil.org/plop/internal/cli/write"
 )
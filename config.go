@@ -0,0 +1,133 @@
+package plop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"bazil.org/plop/internal/config"
+)
+
+// ErrNoPassphrase is returned by OpenVolume for volumes that have no
+// passphrase configured. Use OpenVolumeWithPassphrase for them.
+var ErrNoPassphrase = config.ErrNoPassphrase
+
+// ErrNoVolume is returned when opening a volume that is not in the
+// config.
+var ErrNoVolume = errors.New("no such volume")
+
+// DefaultConfigPath returns the path of the config file the plop
+// command uses by default.
+func DefaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "plop", "config.hcl"), nil
+}
+
+// Config is a plop configuration, as used by the plop command.
+type Config struct {
+	cfg *config.Config
+}
+
+type readConfig struct {
+	local bool
+}
+
+type readOption func(*readConfig)
+
+// ReadOption is an option passed to ReadConfig.
+type ReadOption readOption
+
+// WithLocalConfig makes ReadConfig fold in any local config files
+// (.plop.hcl) in the current directory and above it, like the plop
+// command does. Local config can change the default volume.
+func WithLocalConfig() ReadOption {
+	return func(conf *readConfig) {
+		conf.local = true
+	}
+}
+
+// ReadConfig reads a config file. Relative paths in the config are
+// interpreted relative to the directory of the file.
+func ReadConfig(path string, opts ...ReadOption) (*Config, error) {
+	var conf readConfig
+	for _, opt := range opts {
+		opt(&conf)
+	}
+	read := config.ReadConfigFile
+	if conf.local {
+		read = config.ReadConfig
+	}
+	cfg, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{cfg: cfg}, nil
+}
+
+// ParseConfig parses config file contents. The filename is used in
+// error messages, and to resolve relative paths.
+func ParseConfig(filename string, src []byte) (*Config, error) {
+	cfg, err := config.ParseConfig(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{cfg: cfg}, nil
+}
+
+// Volumes returns the names of the configured volumes.
+func (c *Config) Volumes() []string {
+	names := make([]string, 0, len(c.cfg.Volumes))
+	for _, vol := range c.cfg.Volumes {
+		names = append(names, vol.Name)
+	}
+	return names
+}
+
+// DefaultVolume returns the name of the default volume, or the empty
+// string if there is none.
+func (c *Config) DefaultVolume() string {
+	return c.cfg.DefaultVolume
+}
+
+// volume returns the volume by the given name, or the default volume
+// if name is empty.
+func (c *Config) volume(name string) (*config.Volume, error) {
+	if name == "" {
+		return c.cfg.GetDefaultVolume()
+	}
+	vol, ok := c.cfg.GetVolume(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrNoVolume, name)
+	}
+	return vol, nil
+}
+
+// OpenVolume opens the named volume, or the default volume if name is
+// empty. The passphrase is read from the configured source. The
+// caller must Close the returned Store.
+func (c *Config) OpenVolume(ctx context.Context, name string) (*Store, error) {
+	vol, err := c.volume(name)
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := c.cfg.VolumePassphrase(ctx, vol)
+	if err != nil {
+		return nil, err
+	}
+	return openStore(ctx, c.cfg, vol, passphrase)
+}
+
+// OpenVolumeWithPassphrase is like OpenVolume, but uses the given
+// passphrase instead of the configured one.
+func (c *Config) OpenVolumeWithPassphrase(ctx context.Context, name string, passphrase string) (*Store, error) {
+	vol, err := c.volume(name)
+	if err != nil {
+		return nil, err
+	}
+	return openStore(ctx, c.cfg, vol, passphrase)
+}
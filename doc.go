@@ -1,3 +1,15 @@
 // Package plop is a Content-Addressed Storage (CAS) system backed by
 // any blob store (as supported by gocloud,dev/blob).
+//
+// This package opens volumes as configured for the plop command:
+//
+//	cfg, err := plop.ReadConfig(path)
+//	...
+//	store, err := cfg.OpenVolume(ctx, "")
+//	...
+//	defer store.Close()
+//	key, err := store.Create(ctx, r)
+//
+// Programs that provide their own buckets can use package cas
+// directly.
 package plop
@@ -0,0 +1,307 @@
+package plop
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+	"time"
+
+	"bazil.org/plop/cas"
+)
+
+// FS presents the objects of a volume as a read-only file system,
+// with keys as file names in a single directory. Listing the
+// directory shows the objects recorded in the catalog; objects stored
+// elsewhere can still be opened by key.
+type FS struct {
+	ctx   context.Context
+	store *Store
+}
+
+var (
+	_ fs.FS         = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+)
+
+// FS returns the objects of the volume as a file system. The context
+// is used for all operations on it, and the files opened from it.
+func (s *Store) FS(ctx context.Context) *FS {
+	fsys := &FS{
+		ctx:   ctx,
+		store: s,
+	}
+	return fsys
+}
+
+// openObject opens the object by the given file name, for operation
+// op.
+func (fsys *FS) openObject(op string, name string) (*cas.Handle, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	h, err := fsys.store.Open(fsys.ctx, name)
+	if errors.Is(err, cas.ErrNotExist) || errors.Is(err, cas.ErrBadKey) {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return h, nil
+}
+
+// Open opens the object with the given key, or the directory listing
+// for ".".
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if name == "." {
+		names, err := fsys.list()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		d := &dir{
+			fs:    fsys,
+			names: names,
+		}
+		return d, nil
+	}
+	h, err := fsys.openObject("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f := &file{
+		info: fileInfo{
+			name: name,
+			size: h.Size(),
+		},
+		r: h.IO(fsys.ctx),
+	}
+	return f, nil
+}
+
+// Stat returns information about the object with the given key, or
+// the directory for ".".
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	if name == "." {
+		return dirInfo{}, nil
+	}
+	h, err := fsys.openObject("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info := fileInfo{
+		name: name,
+		size: h.Size(),
+	}
+	return info, nil
+}
+
+// ReadFile reads the content of the object with the given key.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	h, err := fsys.openObject("read", name)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, h.Size())
+	if _, err := h.IO(fsys.ctx).ReadAt(buf, 0); err != nil && err != io.EOF {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return buf, nil
+}
+
+// list returns the keys recorded in the catalog, sorted.
+func (fsys *FS) list() ([]string, error) {
+	if fsys.store.catalog == nil {
+		return nil, nil
+	}
+	entries, err := fsys.store.catalog.Entries()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{}, len(entries))
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if _, ok := seen[entry.Key]; ok {
+			continue
+		}
+		seen[entry.Key] = struct{}{}
+		names = append(names, entry.Key)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+type fileInfo struct {
+	name string
+	size int64
+}
+
+var _ fs.FileInfo = fileInfo{}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() fs.FileMode  { return 0o444 }
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() any           { return nil }
+
+// file is an opened object.
+type file struct {
+	info   fileInfo
+	r      *cas.Reader
+	offset int64
+	closed bool
+}
+
+var (
+	_ fs.File     = (*file)(nil)
+	_ io.ReaderAt = (*file)(nil)
+	_ io.Seeker   = (*file)(nil)
+)
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	return f.info, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+	n, err := f.r.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		// report EOF on the next call
+		err = nil
+	}
+	if err != nil && err != io.EOF {
+		err = &fs.PathError{Op: "read", Path: f.info.name, Err: err}
+	}
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	n, err := f.r.ReadAt(p, off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	if err != nil && err != io.EOF {
+		err = &fs.PathError{Op: "read", Path: f.info.name, Err: err}
+	}
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+type dirInfo struct{}
+
+var _ fs.FileInfo = dirInfo{}
+
+func (dirInfo) Name() string       { return "." }
+func (dirInfo) Size() int64        { return 0 }
+func (dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (dirInfo) ModTime() time.Time { return time.Time{} }
+func (dirInfo) IsDir() bool        { return true }
+func (dirInfo) Sys() any           { return nil }
+
+// dir is the opened root directory.
+type dir struct {
+	fs *FS
+	// names not yet returned by ReadDir.
+	names  []string
+	closed bool
+}
+
+var _ fs.ReadDirFile = (*dir)(nil)
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "stat", Path: ".", Err: fs.ErrClosed}
+	}
+	return dirInfo{}, nil
+}
+
+func (d *dir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: ".", Err: errors.New("is a directory")}
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: ".", Err: fs.ErrClosed}
+	}
+	names := d.names
+	if n > 0 {
+		if len(names) == 0 {
+			return nil, io.EOF
+		}
+		if len(names) > n {
+			names = names[:n]
+		}
+	}
+	d.names = d.names[len(names):]
+	entries := make([]fs.DirEntry, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, &dirEntry{fs: d.fs, name: name})
+	}
+	return entries, nil
+}
+
+func (d *dir) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: ".", Err: fs.ErrClosed}
+	}
+	d.closed = true
+	return nil
+}
+
+// dirEntry is an object listed in the directory. Its size is only
+// looked up if asked for.
+type dirEntry struct {
+	fs   *FS
+	name string
+}
+
+var _ fs.DirEntry = (*dirEntry)(nil)
+
+func (e *dirEntry) Name() string               { return e.name }
+func (e *dirEntry) IsDir() bool                { return false }
+func (e *dirEntry) Type() fs.FileMode          { return 0 }
+func (e *dirEntry) Info() (fs.FileInfo, error) { return e.fs.Stat(e.name) }
@@ -0,0 +1,79 @@
+package plop_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"bazil.org/plop"
+)
+
+const fsTestConfig = `
+mountpoint = "/does-not-exist"
+volume "testvolume" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = "file://%[1]s/bucket"
+  }
+  catalog {
+    path = "catalog.jsonl"
+  }
+}
+`
+
+func TestFS(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "bucket"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	src := fmt.Sprintf(fsTestConfig, dir)
+	cfg, err := plop.ParseConfig(filepath.Join(dir, "config.hcl"), []byte(src))
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	store, err := cfg.OpenVolume(ctx, "testvolume")
+	if err != nil {
+		t.Fatalf("OpenVolume: %v", err)
+	}
+	defer store.Close()
+
+	var keys []string
+	for _, content := range []string{
+		"hello, world\n",
+		"",
+		strings.Repeat("plop", 100000),
+	} {
+		key, err := store.Create(ctx, strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		keys = append(keys, key)
+	}
+
+	fsys := store.FS(ctx)
+	if err := fstest.TestFS(fsys, keys...); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := fs.ReadFile(fsys, keys[0])
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if g, e := string(buf), "hello, world\n"; g != e {
+		t.Errorf("wrong content: %q != %q", g, e)
+	}
+	const missing = "s4wfu6c18bh6ahfjgirpsqp7zmr6pg18d9rho7rrgzpkqonsz8jy"
+	if _, err := fs.Stat(fsys, missing); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist error: %v", err)
+	}
+	if _, err := fsys.Open("not-a-key"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist error: %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+// Package annexremote implements the git-annex external special
+// remote protocol, storing annexed content in a plop volume.
+//
+// Annex keys are mapped to plop keys with refs named "annex:KEY".
+// Like all refs, the mapping is encrypted and stored in the volume.
+// Removing content from the remote only removes the ref; the content
+// stays in the volume, as it may be shared with other objects.
+//
+// See https://git-annex.branchable.com/design/external_special_remote_protocol/
+package annexremote
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"bazil.org/plop/cas"
+)
+
+const refPrefix = "annex:"
+
+// progressInterval is how often, in bytes, transfers report
+// progress.
+const progressInterval = 1 << 20
+
+// OpenFunc opens the named volume. An empty name means the default
+// volume.
+type OpenFunc func(ctx context.Context, volume string) (*cas.Store, error)
+
+// Remote is one session of the protocol, with git-annex on the other
+// end.
+type Remote struct {
+	open  OpenFunc
+	r     *bufio.Reader
+	w     io.Writer
+	store *cas.Store
+}
+
+// New returns a remote that reads requests from r, and writes
+// replies to w. The volume is opened with open when git-annex asks
+// the remote to prepare.
+func New(r io.Reader, w io.Writer, open OpenFunc) *Remote {
+	remote := &Remote{
+		open: open,
+		r:    bufio.NewReader(r),
+		w:    w,
+	}
+	return remote
+}
+
+// protocolError is a violation of the protocol by git-annex.
+type protocolError struct {
+	msg string
+}
+
+func (e *protocolError) Error() string {
+	return "protocol error: " + e.msg
+}
+
+func (rem *Remote) send(words ...string) error {
+	line := strings.Join(words, " ")
+	// replies are line-based; messages must not break that
+	line = strings.ReplaceAll(line, "\n", " ")
+	if _, err := io.WriteString(rem.w, line+"\n"); err != nil {
+		return fmt.Errorf("annex remote: %w", err)
+	}
+	return nil
+}
+
+// readLine reads the next line from git-annex. It returns io.EOF when
+// git-annex is done.
+func (rem *Remote) readLine() (string, error) {
+	line, err := rem.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+// getConfig asks git-annex for a setting of the remote.
+func (rem *Remote) getConfig(name string) (string, error) {
+	if err := rem.send("GETCONFIG", name); err != nil {
+		return "", err
+	}
+	line, err := rem.readLine()
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	value, ok := strings.CutPrefix(line, "VALUE")
+	if !ok {
+		return "", &protocolError{msg: fmt.Sprintf("expected VALUE: %q", line)}
+	}
+	return strings.TrimPrefix(value, " "), nil
+}
+
+// Serve handles requests until git-annex closes the connection.
+func (rem *Remote) Serve(ctx context.Context) error {
+	if err := rem.send("VERSION", "1"); err != nil {
+		return err
+	}
+	for {
+		line, err := rem.readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("annex remote: %w", err)
+		}
+		if err := rem.handle(ctx, line); err != nil {
+			var perr *protocolError
+			if errors.As(err, &perr) {
+				// tell git-annex why we give up
+				_ = rem.send("ERROR", perr.msg)
+			}
+			return err
+		}
+	}
+}
+
+func (rem *Remote) handle(ctx context.Context, line string) error {
+	cmd, args, _ := strings.Cut(line, " ")
+	switch cmd {
+	case "EXTENSIONS":
+		// no extensions supported
+		return rem.send("EXTENSIONS")
+	case "INITREMOTE":
+		if _, err := rem.prepare(ctx); err != nil {
+			return rem.send("INITREMOTE-FAILURE", err.Error())
+		}
+		return rem.send("INITREMOTE-SUCCESS")
+	case "PREPARE":
+		if _, err := rem.prepare(ctx); err != nil {
+			return rem.send("PREPARE-FAILURE", err.Error())
+		}
+		return rem.send("PREPARE-SUCCESS")
+	case "LISTCONFIGS":
+		if err := rem.send("CONFIG", "volume", "plop volume to use, default volume if not set"); err != nil {
+			return err
+		}
+		return rem.send("CONFIGEND")
+	case "GETCOST":
+		// same as other remotes that use the network
+		return rem.send("COST", "200")
+	case "GETAVAILABILITY":
+		return rem.send("AVAILABILITY", "GLOBAL")
+	case "TRANSFER", "CHECKPRESENT", "REMOVE":
+		if rem.store == nil {
+			return &protocolError{msg: cmd + " before PREPARE"}
+		}
+		switch cmd {
+		case "TRANSFER":
+			return rem.handleTransfer(ctx, args)
+		case "CHECKPRESENT":
+			return rem.handleCheckPresent(ctx, args)
+		default:
+			return rem.handleRemove(ctx, args)
+		}
+	case "ERROR":
+		return fmt.Errorf("git-annex error: %s", args)
+	default:
+		return rem.send("UNSUPPORTED-REQUEST")
+	}
+}
+
+// prepare opens the volume configured for the remote, if not already
+// open.
+func (rem *Remote) prepare(ctx context.Context) (*cas.Store, error) {
+	if rem.store != nil {
+		return rem.store, nil
+	}
+	volume, err := rem.getConfig("volume")
+	if err != nil {
+		return nil, err
+	}
+	store, err := rem.open(ctx, volume)
+	if err != nil {
+		return nil, err
+	}
+	rem.store = store
+	return store, nil
+}
+
+func (rem *Remote) handleTransfer(ctx context.Context, args string) error {
+	direction, rest, _ := strings.Cut(args, " ")
+	// the file name is the rest of the line, and may contain spaces
+	key, file, ok := strings.Cut(rest, " ")
+	if !ok || key == "" || file == "" {
+		return &protocolError{msg: fmt.Sprintf("bad TRANSFER request: %q", args)}
+	}
+	var err error
+	switch direction {
+	case "STORE":
+		err = rem.storeFile(ctx, key, file)
+	case "RETRIEVE":
+		err = rem.retrieveFile(ctx, key, file)
+	default:
+		return &protocolError{msg: fmt.Sprintf("bad TRANSFER direction: %q", direction)}
+	}
+	if err != nil {
+		return rem.send("TRANSFER-FAILURE", direction, key, err.Error())
+	}
+	return rem.send("TRANSFER-SUCCESS", direction, key)
+}
+
+// storeFile stores the content of file as the annex key.
+func (rem *Remote) storeFile(ctx context.Context, annexKey string, file string) error {
+	store := rem.store
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	pr := &progressReader{r: f, remote: rem}
+	key, err := store.Create(ctx, pr, cas.CreateSource(annexKey))
+	if err != nil {
+		return err
+	}
+	if pr.err != nil {
+		return pr.err
+	}
+	return setRef(ctx, store, refPrefix+annexKey, key)
+}
+
+// setRef points the named ref at key, whatever its current state.
+func setRef(ctx context.Context, store *cas.Store, name string, key string) error {
+	for {
+		var prevSeq uint64
+		ref, err := store.GetRef(ctx, name)
+		switch {
+		case errors.Is(err, cas.ErrRefNotExist):
+		case err != nil:
+			return err
+		default:
+			if ref.Key == key {
+				return nil
+			}
+			prevSeq = ref.Seq
+		}
+		_, err = store.SetRef(ctx, name, key, prevSeq)
+		if errors.Is(err, cas.ErrRefConflict) {
+			continue
+		}
+		return err
+	}
+}
+
+// lookup returns the plop key for the annex key.
+func lookup(ctx context.Context, store *cas.Store, annexKey string) (string, error) {
+	ref, err := store.GetRef(ctx, refPrefix+annexKey)
+	if err != nil {
+		return "", err
+	}
+	if ref.Deleted() {
+		return "", cas.ErrRefNotExist
+	}
+	return ref.Key, nil
+}
+
+// retrieveFile writes the content of the annex key to file.
+func (rem *Remote) retrieveFile(ctx context.Context, annexKey string, file string) error {
+	store := rem.store
+	key, err := lookup(ctx, store, annexKey)
+	if err != nil {
+		return err
+	}
+	h, err := store.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	pr := &progressReader{r: io.NewSectionReader(h.IO(ctx), 0, h.Size()), remote: rem}
+	if _, err := io.Copy(f, pr); err != nil {
+		return err
+	}
+	if pr.err != nil {
+		return pr.err
+	}
+	return f.Close()
+}
+
+func (rem *Remote) handleCheckPresent(ctx context.Context, annexKey string) error {
+	store := rem.store
+	key, err := lookup(ctx, store, annexKey)
+	if errors.Is(err, cas.ErrRefNotExist) {
+		return rem.send("CHECKPRESENT-FAILURE", annexKey)
+	}
+	if err != nil {
+		return rem.send("CHECKPRESENT-UNKNOWN", annexKey, err.Error())
+	}
+	// make sure the content is still there
+	if _, err := store.Open(ctx, key); err != nil {
+		if errors.Is(err, cas.ErrNotExist) {
+			return rem.send("CHECKPRESENT-FAILURE", annexKey)
+		}
+		return rem.send("CHECKPRESENT-UNKNOWN", annexKey, err.Error())
+	}
+	return rem.send("CHECKPRESENT-SUCCESS", annexKey)
+}
+
+func (rem *Remote) handleRemove(ctx context.Context, annexKey string) error {
+	store := rem.store
+	name := refPrefix + annexKey
+	for {
+		ref, err := store.GetRef(ctx, name)
+		if errors.Is(err, cas.ErrRefNotExist) || (err == nil && ref.Deleted()) {
+			// removing content that is not there succeeds
+			return rem.send("REMOVE-SUCCESS", annexKey)
+		}
+		if err != nil {
+			return rem.send("REMOVE-FAILURE", annexKey, err.Error())
+		}
+		err = store.DeleteRef(ctx, name, ref.Seq)
+		if errors.Is(err, cas.ErrRefConflict) {
+			continue
+		}
+		if err != nil {
+			return rem.send("REMOVE-FAILURE", annexKey, err.Error())
+		}
+		return rem.send("REMOVE-SUCCESS", annexKey)
+	}
+}
+
+// progressReader reports progress of a transfer to git-annex, as
+// the content is read.
+type progressReader struct {
+	r        io.Reader
+	remote   *Remote
+	n        int64
+	reported int64
+	// err is set if sending progress failed.
+	err error
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.r.Read(buf)
+	p.n += int64(n)
+	if p.err == nil && p.n-p.reported >= progressInterval {
+		p.reported = p.n
+		p.err = p.remote.send("PROGRESS", fmt.Sprint(p.n))
+	}
+	return n, err
+}
@@ -0,0 +1,153 @@
+package annexremote_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bazil.org/plop/cas"
+	"bazil.org/plop/internal/annexremote"
+	"gocloud.dev/blob/memblob"
+)
+
+// run feeds the lines sent by git-annex to a remote, and returns
+// the lines sent back.
+func run(t testing.TB, store *cas.Store, input string) string {
+	t.Helper()
+	ctx := context.Background()
+	var volumes []string
+	open := func(ctx context.Context, volume string) (*cas.Store, error) {
+		volumes = append(volumes, volume)
+		return store, nil
+	}
+	var out bytes.Buffer
+	rem := annexremote.New(strings.NewReader(input), &out, open)
+	if err := rem.Serve(ctx); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	if g, e := strings.Join(volumes, ","), "myvol"; g != e {
+		t.Errorf("wrong volumes opened: %q != %q", g, e)
+	}
+	return out.String()
+}
+
+func checkTranscript(t testing.TB, got string, want string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("wrong transcript:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "source file")
+	const greeting = "hello, world\n"
+	if err := os.WriteFile(src, []byte(greeting), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dir, "retrieved")
+	const key = "SHA256E-s13--abc.txt"
+	store := cas.NewStore("s3kr1t", cas.WithBucket(memblob.OpenBucket(nil)))
+
+	input := fmt.Sprintf(`EXTENSIONS INFO ASYNC
+INITREMOTE
+VALUE myvol
+PREPARE
+CHECKPRESENT %[1]s
+TRANSFER STORE %[1]s %[2]s
+CHECKPRESENT %[1]s
+TRANSFER RETRIEVE %[1]s %[3]s
+GETCOST
+WHEREIS %[1]s
+REMOVE %[1]s
+CHECKPRESENT %[1]s
+REMOVE %[1]s
+TRANSFER RETRIEVE %[1]s %[3]s
+`, key, src, dst)
+	want := fmt.Sprintf(`VERSION 1
+EXTENSIONS
+GETCONFIG volume
+INITREMOTE-SUCCESS
+PREPARE-SUCCESS
+CHECKPRESENT-FAILURE %[1]s
+TRANSFER-SUCCESS STORE %[1]s
+CHECKPRESENT-SUCCESS %[1]s
+TRANSFER-SUCCESS RETRIEVE %[1]s
+COST 200
+UNSUPPORTED-REQUEST
+REMOVE-SUCCESS %[1]s
+CHECKPRESENT-FAILURE %[1]s
+REMOVE-SUCCESS %[1]s
+TRANSFER-FAILURE RETRIEVE %[1]s ref does not exist
+`, key)
+	checkTranscript(t, run(t, store, input), want)
+
+	buf, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, e := string(buf), greeting; g != e {
+		t.Errorf("wrong content retrieved: %q != %q", g, e)
+	}
+}
+
+func TestStoreMissingFile(t *testing.T) {
+	store := cas.NewStore("s3kr1t", cas.WithBucket(memblob.OpenBucket(nil)))
+	missing := filepath.Join(t.TempDir(), "missing")
+	input := fmt.Sprintf(`PREPARE
+VALUE myvol
+TRANSFER STORE KEY1 %[1]s
+CHECKPRESENT KEY1
+`, missing)
+	want := fmt.Sprintf(`VERSION 1
+GETCONFIG volume
+PREPARE-SUCCESS
+TRANSFER-FAILURE STORE KEY1 open %[1]s: no such file or directory
+CHECKPRESENT-FAILURE KEY1
+`, missing)
+	checkTranscript(t, run(t, store, input), want)
+}
+
+func TestBeforePrepare(t *testing.T) {
+	ctx := context.Background()
+	open := func(ctx context.Context, volume string) (*cas.Store, error) {
+		t.Fatal("unexpected open")
+		return nil, nil
+	}
+	var out bytes.Buffer
+	rem := annexremote.New(strings.NewReader("CHECKPRESENT KEY1\n"), &out, open)
+	err := rem.Serve(ctx)
+	if err == nil || !strings.Contains(err.Error(), "CHECKPRESENT before PREPARE") {
+		t.Errorf("expected protocol error: %v", err)
+	}
+	checkTranscript(t, out.String(), "VERSION 1\nERROR CHECKPRESENT before PREPARE\n")
+}
+
+func TestProgress(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "big")
+	data := bytes.Repeat([]byte("plop"), 3<<20/4)
+	if err := os.WriteFile(src, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store := cas.NewStore("s3kr1t", cas.WithBucket(memblob.OpenBucket(nil)))
+	input := fmt.Sprintf("PREPARE\nVALUE myvol\nTRANSFER STORE KEY1 %s\n", src)
+	out := run(t, store, input)
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if g, e := lines[len(lines)-1], "TRANSFER-SUCCESS STORE KEY1"; g != e {
+		t.Fatalf("wrong result: %q != %q", g, e)
+	}
+	var progress []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "PROGRESS ") {
+			progress = append(progress, line)
+		}
+	}
+	if len(progress) < 2 {
+		t.Errorf("expected progress reports: %q", progress)
+	}
+}
@@ -0,0 +1,285 @@
+// Package catalog keeps a record of objects created in a volume.
+//
+// The catalog is stored locally as a file of JSON lines, one per
+// created object. It can optionally be synchronized with a copy
+// stored in the volume itself, as a regular (encrypted) object
+// pointed to by a ref.
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"bazil.org/plop/cas"
+	"golang.org/x/sys/unix"
+)
+
+// RefName is the name of the ref pointing to the catalog stored in
+// the volume.
+const RefName = "plop/catalog"
+
+// Catalog is a local catalog file.
+type Catalog struct {
+	path string
+
+	// serialize access from this process, flock handles other
+	// processes
+	mu sync.Mutex
+}
+
+var _ cas.Cataloger = (*Catalog)(nil)
+
+// Open returns the catalog stored in the given file. The file is
+// created when the first entry is recorded.
+func Open(path string) *Catalog {
+	c := &Catalog{
+		path: path,
+	}
+	return c
+}
+
+// lock opens the catalog file and takes a lock on it. The caller
+// must close the returned file.
+func (c *Catalog) lock(flag int, how int) (*os.File, error) {
+	if flag&os.O_CREATE != 0 {
+		if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(c.path, flag, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), how); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("cannot lock catalog: %w", err)
+	}
+	return f, nil
+}
+
+// Record appends an entry to the catalog.
+func (c *Catalog) Record(ctx context.Context, entry *cas.CatalogEntry) error {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, err := c.lock(os.O_WRONLY|os.O_APPEND|os.O_CREATE, unix.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(buf); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func parseEntries(r io.Reader) ([]*cas.CatalogEntry, error) {
+	var entries []*cas.CatalogEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry cas.CatalogEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("corrupt catalog entry: %w", err)
+		}
+		entries = append(entries, &entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func writeEntries(w io.Writer, entries []*cas.CatalogEntry) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Entries returns all entries in the catalog, oldest first.
+func (c *Catalog) Entries() ([]*cas.CatalogEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, err := c.lock(os.O_RDONLY, unix.LOCK_SH)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseEntries(f)
+}
+
+// entryID identifies duplicate entries when merging catalogs.
+type entryID struct {
+	key    string
+	source string
+	time   int64
+}
+
+// merge combines entries from both lists, dropping duplicates, and
+// sorts the result by time.
+func merge(a, b []*cas.CatalogEntry) []*cas.CatalogEntry {
+	seen := make(map[entryID]struct{}, len(a)+len(b))
+	var result []*cas.CatalogEntry
+	for _, list := range [][]*cas.CatalogEntry{a, b} {
+		for _, entry := range list {
+			id := entryID{
+				key:    entry.Key,
+				source: entry.Source,
+				time:   entry.Time.Unix(),
+			}
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+			result = append(result, entry)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+	return result
+}
+
+// pull reads the catalog stored in the volume. It returns the ref
+// sequence number seen, for a later update.
+func pull(ctx context.Context, store *cas.Store) ([]*cas.CatalogEntry, uint64, error) {
+	ref, err := store.GetRef(ctx, RefName)
+	if errors.Is(err, cas.ErrRefNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if ref.Deleted() {
+		return nil, ref.Seq, nil
+	}
+	h, err := store.Open(ctx, ref.Key)
+	if err != nil {
+		return nil, 0, err
+	}
+	entries, err := parseEntries(h.IO(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, ref.Seq, nil
+}
+
+// replace overwrites the locked local catalog file with entries.
+//
+// The file is rewritten in place, instead of renaming a new file
+// over it, so that writers waiting for the lock do not end up
+// appending to an unlinked file.
+func replace(f *os.File, entries []*cas.CatalogEntry) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if err := writeEntries(f, entries); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Refresh merges the catalog stored in the volume into the local
+// catalog, without uploading anything.
+func (c *Catalog) Refresh(ctx context.Context, store *cas.Store) error {
+	_, _, _, err := c.refresh(ctx, store)
+	return err
+}
+
+// refresh merges the catalog stored in the volume into the local
+// catalog. It returns the merged entries, how many entries the volume
+// had, and the ref sequence number seen.
+func (c *Catalog) refresh(ctx context.Context, store *cas.Store) (_ []*cas.CatalogEntry, remoteLen int, remoteSeq uint64, _ error) {
+	remote, seq, err := pull(ctx, store)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("cannot read catalog from volume: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, err := c.lock(os.O_RDWR|os.O_CREATE, unix.LOCK_EX)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer f.Close()
+	local, err := parseEntries(f)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	merged := merge(local, remote)
+	if len(merged) != len(local) {
+		if err := replace(f, merged); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return nil, 0, 0, err
+	}
+	return merged, len(remote), seq, nil
+}
+
+// Sync merges the local catalog with the one stored in the volume,
+// and stores the result in both places.
+func (c *Catalog) Sync(ctx context.Context, store *cas.Store) error {
+	// Retry a few times if another writer updates the catalog
+	// concurrently.
+	const maxTries = 5
+	for tries := 0; ; tries++ {
+		merged, remoteLen, seq, err := c.refresh(ctx, store)
+		if err != nil {
+			return err
+		}
+		if seq > 0 && remoteLen == len(merged) {
+			// volume is up to date
+			return nil
+		}
+		var buf bytes.Buffer
+		if err := writeEntries(&buf, merged); err != nil {
+			return err
+		}
+		key, err := store.Create(ctx, &buf, cas.CreateWithoutCatalog())
+		if err != nil {
+			return fmt.Errorf("cannot store catalog in volume: %w", err)
+		}
+		_, err = store.SetRef(ctx, RefName, key, seq)
+		if errors.Is(err, cas.ErrRefConflict) && tries < maxTries {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot update catalog ref: %w", err)
+		}
+		return nil
+	}
+}
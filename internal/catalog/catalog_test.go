@@ -0,0 +1,106 @@
+package catalog_test
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"bazil.org/plop/cas"
+	"bazil.org/plop/internal/catalog"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestRecord(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.Open(filepath.Join(t.TempDir(), "catalog.jsonl"))
+	b := memblob.OpenBucket(nil)
+	s := cas.NewStore("s3kr1t", cas.WithBucket(b), cas.WithCatalog(c))
+
+	entries, err := c.Entries()
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected empty catalog: %v", entries)
+	}
+
+	const greeting = "hello, world\n"
+	key, err := s.Create(ctx, strings.NewReader(greeting),
+		cas.CreateSource("/tmp/greeting.txt"),
+		cas.CreateLabels(map[string]string{"lang": "en"}),
+	)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := s.Create(ctx, strings.NewReader("ignored"), cas.CreateWithoutCatalog()); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	entries, err = c.Entries()
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if g, e := len(entries), 1; g != e {
+		t.Fatalf("wrong number of entries: %d != %d", g, e)
+	}
+	entry := entries[0]
+	if g, e := entry.Key, key; g != e {
+		t.Errorf("wrong key: %q != %q", g, e)
+	}
+	if g, e := entry.Size, int64(len(greeting)); g != e {
+		t.Errorf("wrong size: %d != %d", g, e)
+	}
+	if g, e := entry.Source, "/tmp/greeting.txt"; g != e {
+		t.Errorf("wrong source: %q != %q", g, e)
+	}
+	if g, e := entry.Labels["lang"], "en"; g != e {
+		t.Errorf("wrong label: %q != %q", g, e)
+	}
+	if entry.Time.IsZero() {
+		t.Error("missing time")
+	}
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	one := catalog.Open(filepath.Join(t.TempDir(), "catalog.jsonl"))
+	storeOne := cas.NewStore("s3kr1t", cas.WithBucket(b), cas.WithCatalog(one))
+	two := catalog.Open(filepath.Join(t.TempDir(), "catalog.jsonl"))
+	storeTwo := cas.NewStore("s3kr1t", cas.WithBucket(b), cas.WithCatalog(two))
+
+	if _, err := storeOne.Create(ctx, strings.NewReader("one"), cas.CreateSource("one")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := storeTwo.Create(ctx, strings.NewReader("two"), cas.CreateSource("two")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if err := one.Sync(ctx, storeOne); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if err := two.Sync(ctx, storeTwo); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if err := one.Refresh(ctx, storeOne); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	for _, c := range []*catalog.Catalog{one, two} {
+		entries, err := c.Entries()
+		if err != nil {
+			t.Fatalf("Entries: %v", err)
+		}
+		var sources []string
+		for _, entry := range entries {
+			sources = append(sources, entry.Source)
+		}
+		// entries created within the same second have no set order
+		sort.Strings(sources)
+		if g, e := strings.Join(sources, ","), "one,two"; g != e {
+			t.Errorf("wrong entries: %q != %q", g, e)
+		}
+	}
+}
@@ -10,8 +10,8 @@ import (
 	"os"
 	"path/filepath"
 
-	"bazil.org/plop/cas"
 	cliplop "bazil.org/plop/internal/cli"
+	"bazil.org/plop/internal/flagx"
 	"github.com/tv42/cliutil/subcommands"
 	"golang.org/x/sys/unix"
 )
@@ -21,6 +21,7 @@ type addCommand struct {
 	flag.FlagSet
 	Flags struct {
 		Volume string
+		Labels flagx.Labels
 	}
 	Arguments struct {
 		File []string
@@ -64,12 +65,12 @@ func replaceWithSymlink(target, path string) error {
 	return nil
 }
 
-func (c *addCommand) addPath(ctx context.Context, store *cas.Store, targetPrefix string, p string) error {
+func (c *addCommand) addPath(ctx context.Context, objects cliplop.Objects, targetPrefix string, p string) error {
 	target, err := os.Readlink(p)
 	if err != nil {
 		if errors.Is(err, unix.EINVAL) {
 			// it's not a symlink
-			return c.addRegularFile(ctx, store, targetPrefix, p)
+			return c.addRegularFile(ctx, objects, targetPrefix, p)
 		}
 		// error from readlink
 		return err
@@ -85,8 +86,8 @@ func (c *addCommand) addPath(ctx context.Context, store *cas.Store, targetPrefix
 		// volume directory.
 		//
 		// Ensuring file has no extension rules out symlinks to any
-		// non-content data the filesystem might expose in the future,
-		// e.g. metadata on extents.
+		// non-content data the filesystem exposes, e.g. the
+		// KEY.extents and KEY.json sidecar files.
 		//
 		// We could parse the filename zbase32 too, but that seems
 		// brittle and unnecessary; now we have a path to other name
@@ -95,16 +96,20 @@ func (c *addCommand) addPath(ctx context.Context, store *cas.Store, targetPrefix
 	}
 
 	// not a recognized or acceptable symlink; do add
-	return c.addRegularFile(ctx, store, targetPrefix, p)
+	return c.addRegularFile(ctx, objects, targetPrefix, p)
 }
 
-func (c *addCommand) addRegularFile(ctx context.Context, store *cas.Store, targetPrefix string, p string) error {
+func (c *addCommand) addRegularFile(ctx context.Context, objects cliplop.Objects, targetPrefix string, p string) error {
 	f, err := os.Open(p)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	key, err := store.Create(ctx, f)
+	source, err := filepath.Abs(p)
+	if err != nil {
+		return err
+	}
+	key, err := objects.Create(ctx, f, source, c.Flags.Labels)
 	if err != nil {
 		return err
 	}
@@ -125,7 +130,7 @@ func (c *addCommand) Run() error {
 	if err != nil {
 		return err
 	}
-	store, err := cliplop.Plop.Store(vol)
+	objects, err := cliplop.Plop.Objects(ctx, vol)
 	if err != nil {
 		return err
 	}
@@ -135,10 +140,13 @@ func (c *addCommand) Run() error {
 	}
 	targetPrefix = filepath.Join(targetPrefix, vol.Name)
 	for _, p := range c.Arguments.File {
-		if err := c.addPath(ctx, store, targetPrefix, p); err != nil {
+		if err := c.addPath(ctx, objects, targetPrefix, p); err != nil {
 			return fmt.Errorf("cannot add to plop: %v", err)
 		}
 	}
+	if err := objects.SyncCatalog(ctx); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -148,5 +156,6 @@ var add = addCommand{
 
 func init() {
 	add.StringVar(&add.Flags.Volume, "volume", "", "volume to add file to")
+	add.Var(&add.Flags.Labels, "label", "label to record in the catalog, as KEY=VALUE (repeatable)")
 	subcommands.Register(&add)
 }
@@ -0,0 +1,38 @@
+package annexremote
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"bazil.org/plop/cas"
+	"bazil.org/plop/internal/annexremote"
+	cliplop "bazil.org/plop/internal/cli"
+	"github.com/tv42/cliutil/subcommands"
+)
+
+type annexRemoteCommand struct {
+	subcommands.Description
+	flag.FlagSet
+}
+
+func (c *annexRemoteCommand) Run() error {
+	ctx := context.TODO()
+	open := func(ctx context.Context, volume string) (*cas.Store, error) {
+		vol, err := cliplop.Plop.Volume(volume)
+		if err != nil {
+			return nil, err
+		}
+		return cliplop.Plop.Store(vol)
+	}
+	remote := annexremote.New(os.Stdin, os.Stdout, open)
+	return remote.Serve(ctx)
+}
+
+var annexRemote = annexRemoteCommand{
+	Description: "serve as a git-annex special remote, run by git-annex",
+}
+
+func init() {
+	subcommands.Register(&annexRemote)
+}
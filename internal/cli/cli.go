@@ -2,6 +2,7 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -9,9 +10,11 @@ import (
 	"path/filepath"
 	"runtime/pprof"
 	"sync"
+	"time"
 
 	"bazil.org/plop/cas"
 	"bazil.org/plop/internal/config"
+	"bazil.org/plop/internal/tracing"
 	"github.com/tv42/cliutil/subcommands"
 )
 
@@ -22,11 +25,14 @@ type plop struct {
 		Debug      bool
 		Config     string
 		CPUProfile string
+		NoDaemon   bool
 	}
 
 	configOnce sync.Once
 	config     *config.Config
 	configErr  error
+	// stopTracing is set once tracing has been started.
+	stopTracing func(context.Context) error
 }
 
 var _ Service = (*plop)(nil)
@@ -48,14 +54,38 @@ func (p *plop) Setup() (ok bool) {
 }
 
 func (p *plop) Teardown() (ok bool) {
+	ok = true
+	if p.stopTracing != nil {
+		// do not hang on exit if the collector is unreachable
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := p.stopTracing(ctx); err != nil {
+			log.Printf("%v", err)
+			ok = false
+		}
+	}
 	if p.Flags.CPUProfile != "" {
 		pprof.StopCPUProfile()
 	}
-	return true
+	return ok
 }
 
+// initConfig reads the config, and starts exporting traces if the
+// config asks for it. Changes to tracing in a reloaded config take
+// effect on restart.
 func (p *plop) initConfig() {
-	p.config, p.configErr = config.ReadConfig(p.Flags.Config)
+	cfg, err := config.ReadConfig(p.Flags.Config)
+	if err != nil {
+		p.configErr = err
+		return
+	}
+	stop, err := tracing.Start(context.Background(), cfg.TracingOptions())
+	if err != nil {
+		p.configErr = err
+		return
+	}
+	p.config = cfg
+	p.stopTracing = stop
 }
 
 func (p *plop) Config() (*config.Config, error) {
@@ -91,13 +121,40 @@ func (p *plop) Store(vol *config.Volume) (*cas.Store, error) {
 	if err != nil {
 		return nil, err
 	}
-	store, _, err := config.OpenVolume(ctx, cfg, vol)
+	passphrase, err := cfg.VolumePassphrase(ctx, vol)
+	if errors.Is(err, config.ErrNoPassphrase) {
+		passphrase, err = promptPassphrase(vol.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	store, _, err := config.OpenVolumeWithPassphrase(ctx, cfg, vol, passphrase)
 	if err != nil {
 		return nil, err
 	}
 	return store, nil
 }
 
+// SyncCatalog synchronizes the catalog of the volume with the copy
+// stored in the volume, if the volume is configured to do so.
+func (p *plop) SyncCatalog(ctx context.Context, vol *config.Volume, store *cas.Store) error {
+	if vol.Catalog == nil || !vol.Catalog.Sync {
+		return nil
+	}
+	cfg, err := p.Config()
+	if err != nil {
+		return err
+	}
+	catalog, err := config.OpenCatalog(cfg, vol)
+	if err != nil {
+		return err
+	}
+	if err := catalog.Sync(ctx, store); err != nil {
+		return fmt.Errorf("cannot sync catalog: %w", err)
+	}
+	return nil
+}
+
 // Plop allows command-line callables access to global flags, such as
 // verbosity.
 var Plop = plop{}
@@ -115,6 +172,7 @@ func init() {
 	}
 	Plop.StringVar(&Plop.Flags.Config, "config", defaultConfig, "config file to read")
 	Plop.StringVar(&Plop.Flags.CPUProfile, "cpuprofile", "", "write cpu profile to file")
+	Plop.BoolVar(&Plop.Flags.NoDaemon, "no-daemon", false, "do not use a running daemon, even if there is one")
 
 	subcommands.Register(&Plop)
 }
@@ -155,6 +213,11 @@ func run(result subcommands.Result) (ok bool) {
 	return true
 }
 
+// annexRemoteProgName is the name git-annex looks for, to run plop
+// as an external special remote. Installing a symlink by that name
+// runs "plop annex-remote".
+const annexRemoteProgName = "git-annex-remote-plop"
+
 // Main is primary entry point into the plop command line
 // application.
 func Main() (exitstatus int) {
@@ -162,7 +225,12 @@ func Main() (exitstatus int) {
 	log.SetFlags(0)
 	log.SetPrefix(progName + ": ")
 
-	result, err := subcommands.Parse(&Plop, progName, os.Args[1:])
+	args := os.Args[1:]
+	if progName == annexRemoteProgName {
+		// git-annex runs external special remotes by this name
+		args = append([]string{"annex-remote"}, args...)
+	}
+	result, err := subcommands.Parse(&Plop, progName, args)
 	if err == flag.ErrHelp {
 		result.Usage()
 		return 0
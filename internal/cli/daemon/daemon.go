@@ -0,0 +1,98 @@
+package daemon
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	cliplop "bazil.org/plop/internal/cli"
+	"bazil.org/plop/internal/config"
+	plopdaemon "bazil.org/plop/internal/daemon"
+	"bazil.org/plop/internal/sdnotify"
+	"github.com/tv42/cliutil/subcommands"
+)
+
+type daemonCommand struct {
+	subcommands.Description
+	flag.FlagSet
+	Flags struct {
+		MetricsListen string
+	}
+}
+
+func (c *daemonCommand) Run() error {
+	cfg, err := cliplop.Plop.Config()
+	if err != nil {
+		return err
+	}
+	socket := cfg.ControlSocketPath()
+	l, err := plopdaemon.Listen(socket)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	volumes := plopdaemon.NewLocal(cfg)
+	defer func() {
+		if err := volumes.Close(); err != nil {
+			log.Printf("error closing volumes: %v", err)
+		}
+	}()
+	srv := plopdaemon.NewServer(volumes)
+
+	stopMetrics, err := cliplop.ServeMetrics(c.Flags.MetricsListen)
+	if err != nil {
+		return err
+	}
+	defer stopMetrics()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(l)
+	}()
+	if err := sdnotify.Notify(sdnotify.Ready); err != nil {
+		log.Printf("cannot notify service manager: %v", err)
+	}
+	for {
+		select {
+		case err := <-errCh:
+			return err
+
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				newCfg, err := config.ReadConfig(cliplop.Plop.Flags.Config)
+				if err != nil {
+					log.Printf("cannot reload config: %v", err)
+					continue
+				}
+				volumes.Update(newCfg)
+				if newCfg.ControlSocketPath() != socket {
+					log.Printf("config reloaded, control socket change needs a restart")
+				} else {
+					log.Printf("config reloaded")
+				}
+				continue
+			}
+			log.Printf("%v: stopping", sig)
+			if err := sdnotify.Notify(sdnotify.Stopping); err != nil {
+				log.Printf("cannot notify service manager: %v", err)
+			}
+			_ = l.Close()
+			return <-errCh
+		}
+	}
+}
+
+var daemon = daemonCommand{
+	Description: "serve volumes to other plop commands over the control socket",
+}
+
+func init() {
+	daemon.StringVar(&daemon.Flags.MetricsListen, "metrics-listen", "", "serve Prometheus metrics over HTTP on this address, at /metrics")
+	subcommands.Register(&daemon)
+}
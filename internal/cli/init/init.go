@@ -0,0 +1,43 @@
+package init
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	cliplop "bazil.org/plop/internal/cli"
+	"github.com/tv42/cliutil/subcommands"
+)
+
+type initCommand struct {
+	subcommands.Description
+	flag.FlagSet
+	Flags struct {
+		Volume string
+	}
+}
+
+func (c *initCommand) Run() error {
+	ctx := context.TODO()
+	vol, err := cliplop.Plop.Volume(c.Flags.Volume)
+	if err != nil {
+		return err
+	}
+	store, err := cliplop.Plop.Store(vol)
+	if err != nil {
+		return err
+	}
+	if err := store.InitVolume(ctx); err != nil {
+		return fmt.Errorf("cannot initialize volume: %v", err)
+	}
+	return nil
+}
+
+var initCmd = initCommand{
+	Description: "initialize a volume by writing its descriptor",
+}
+
+func init() {
+	initCmd.StringVar(&initCmd.Flags.Volume, "volume", "", "volume to initialize")
+	subcommands.Register(&initCmd)
+}
@@ -0,0 +1,49 @@
+package lfstransfer
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"bazil.org/plop/cas"
+	cliplop "bazil.org/plop/internal/cli"
+	"bazil.org/plop/internal/lfstransfer"
+	"github.com/tv42/cliutil/subcommands"
+)
+
+type lfsTransferCommand struct {
+	subcommands.Description
+	flag.FlagSet
+	Flags struct {
+		Volume  string
+		TempDir string
+	}
+}
+
+func (c *lfsTransferCommand) Run() error {
+	ctx := context.TODO()
+	open := func(ctx context.Context) (*cas.Store, error) {
+		vol, err := cliplop.Plop.Volume(c.Flags.Volume)
+		if err != nil {
+			return nil, err
+		}
+		return cliplop.Plop.Store(vol)
+	}
+	agent := lfstransfer.New(os.Stdin, os.Stdout, open, c.Flags.TempDir)
+	return agent.Serve(ctx)
+}
+
+// Configure git with
+//
+//	git config lfs.standalonetransferagent plop
+//	git config lfs.customtransfer.plop.path plop
+//	git config lfs.customtransfer.plop.args "lfs-transfer -volume NAME"
+var lfsTransfer = lfsTransferCommand{
+	Description: "serve as a Git LFS custom transfer agent, run by git-lfs",
+}
+
+func init() {
+	lfsTransfer.StringVar(&lfsTransfer.Flags.Volume, "volume", "", "volume to use")
+	lfsTransfer.StringVar(&lfsTransfer.Flags.TempDir, "tmpdir", "", "directory for downloaded objects, before git-lfs moves them in place")
+	subcommands.Register(&lfsTransfer)
+}
@@ -0,0 +1,96 @@
+package ls
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"bazil.org/plop/cas"
+	cliplop "bazil.org/plop/internal/cli"
+	"bazil.org/plop/internal/config"
+	"bazil.org/plop/internal/flagx"
+	"github.com/tv42/cliutil/subcommands"
+)
+
+type lsCommand struct {
+	subcommands.Description
+	flag.FlagSet
+	Flags struct {
+		Volume string
+		Labels flagx.Labels
+		Sync   bool
+	}
+}
+
+func (c *lsCommand) match(entry *cas.CatalogEntry) bool {
+	for k, v := range c.Flags.Labels {
+		if got, ok := entry.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *lsCommand) list(entries []*cas.CatalogEntry, w io.Writer) error {
+	for _, entry := range entries {
+		if !c.match(entry) {
+			continue
+		}
+		source := entry.Source
+		if source == "" {
+			source = "-"
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", entry.Key, entry.Size, entry.Time.Format(time.RFC3339), source); err != nil {
+			return fmt.Errorf("writing to output: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *lsCommand) Run() error {
+	ctx := context.TODO()
+	cfg, err := cliplop.Plop.Config()
+	if err != nil {
+		return err
+	}
+	vol, err := cliplop.Plop.Volume(c.Flags.Volume)
+	if err != nil {
+		return err
+	}
+	catalog, err := config.OpenCatalog(cfg, vol)
+	if err != nil {
+		return err
+	}
+	if catalog == nil {
+		return errors.New("volume has no catalog configured")
+	}
+	if c.Flags.Sync {
+		store, err := cliplop.Plop.Store(vol)
+		if err != nil {
+			return err
+		}
+		if err := catalog.Refresh(ctx, store); err != nil {
+			return fmt.Errorf("cannot refresh catalog: %v", err)
+		}
+	}
+	entries, err := catalog.Entries()
+	if err != nil {
+		return fmt.Errorf("cannot read catalog: %v", err)
+	}
+	return c.list(entries, os.Stdout)
+}
+
+var ls = lsCommand{
+	Description: "list objects recorded in the catalog",
+}
+
+func init() {
+	ls.StringVar(&ls.Flags.Volume, "volume", "", "volume to list")
+	ls.Var(&ls.Flags.Labels, "label", "only list objects with label KEY=VALUE (repeatable)")
+	ls.BoolVar(&ls.Flags.Sync, "sync", false, "merge the catalog stored in the volume before listing")
+	subcommands.Register(&ls)
+}
@@ -0,0 +1,87 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// readyFDEnv tells a background child which file descriptor to
+// signal readiness on.
+const readyFDEnv = "PLOP_MOUNT_READY_FD"
+
+const readyMessage = "ready\n"
+
+// background runs the current command again as a child process in a
+// new session, and waits until it is serving or fails.
+func background() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	cmd := exec.Command(exe, os.Args[1:]...)
+	// ExtraFiles start at file descriptor 3.
+	cmd.Env = append(os.Environ(), readyFDEnv+"=3")
+	cmd.ExtraFiles = []*os.File{w}
+	// Keep logging to our stderr, stdin and stdout are /dev/null.
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+	if err := cmd.Start(); err != nil {
+		_ = w.Close()
+		return err
+	}
+	_ = w.Close()
+
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if string(buf) == readyMessage {
+		// leave the child running
+		return cmd.Process.Release()
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("background mount failed: %w", err)
+	}
+	return errors.New("background mount exited without serving")
+}
+
+// backgroundChild returns the readiness pipe, if this process was
+// started by background.
+func backgroundChild() (_ *os.File, isChild bool, _ error) {
+	s, ok := os.LookupEnv(readyFDEnv)
+	if !ok {
+		return nil, false, nil
+	}
+	// don't pass it on to any children of ours
+	_ = os.Unsetenv(readyFDEnv)
+	fd, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid %s: %q", readyFDEnv, s)
+	}
+	syscall.CloseOnExec(fd)
+	f := os.NewFile(uintptr(fd), "ready")
+	return f, true, nil
+}
+
+// notifyParent tells the waiting parent that we are serving.
+func notifyParent(f *os.File) {
+	if _, err := io.WriteString(f, readyMessage); err != nil {
+		log.Printf("cannot notify parent: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Printf("cannot notify parent: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package mount
+
+import (
+	"errors"
+	"log"
+
+	"bazil.org/plop/internal/config"
+	"bazil.org/plop/internal/daemon"
+	"bazil.org/plop/internal/plopfs"
+)
+
+// serveControl serves the volumes of the mount on the control socket,
+// so other commands can use them without opening them again. If a
+// daemon is already running, nothing is served.
+func serveControl(cfg *config.Config, filesys *plopfs.PlopFS) (func(), error) {
+	l, err := daemon.Listen(cfg.ControlSocketPath())
+	if errors.Is(err, daemon.ErrRunning) {
+		log.Printf("not serving the control socket: %v", err)
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	srv := daemon.NewServer(filesys)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := srv.Serve(l); err != nil {
+			log.Printf("control socket: %v", err)
+		}
+	}()
+	stop := func() {
+		_ = l.Close()
+		<-done
+	}
+	return stop, nil
+}
@@ -1,13 +1,28 @@
 package mount
 
 import (
+	"flag"
+	"log"
+	"os"
+	"strconv"
+
+	"bazil.org/fuse"
 	cliplop "bazil.org/plop/internal/cli"
+	"bazil.org/plop/internal/config"
 	"bazil.org/plop/internal/plopfs"
+	"bazil.org/plop/internal/sdnotify"
 	"github.com/tv42/cliutil/subcommands"
 )
 
 type mountCommand struct {
 	subcommands.Description
+	flag.FlagSet
+	Flags struct {
+		Daemon        bool
+		PIDFile       string
+		Unmount       bool
+		MetricsListen string
+	}
 }
 
 func (c *mountCommand) Run() error {
@@ -15,8 +30,65 @@ func (c *mountCommand) Run() error {
 	if err != nil {
 		return err
 	}
-	// TODO `plop -debug mount` should enable fuse debug log
-	if err := plopfs.Mount(cfg); err != nil {
+	if c.Flags.Unmount {
+		return plopfs.Unmount(cfg)
+	}
+	readyPipe, isChild, err := backgroundChild()
+	if err != nil {
+		return err
+	}
+	if c.Flags.Daemon && !isChild {
+		return background()
+	}
+
+	if cliplop.Plop.Flags.Debug {
+		fuse.Debug = func(msg interface{}) {
+			log.Printf("fuse: %v", msg)
+		}
+	}
+	stopMetrics, err := cliplop.ServeMetrics(c.Flags.MetricsListen)
+	if err != nil {
+		return err
+	}
+	defer stopMetrics()
+	reload := func() (*config.Config, error) {
+		return config.ReadConfig(cliplop.Plop.Flags.Config)
+	}
+	serve := func(filesys *plopfs.PlopFS) (func(), error) {
+		return serveControl(cfg, filesys)
+	}
+	ready := func() {
+		if c.Flags.PIDFile != "" {
+			pid := strconv.Itoa(os.Getpid()) + "\n"
+			if err := os.WriteFile(c.Flags.PIDFile, []byte(pid), 0o644); err != nil {
+				log.Printf("cannot write pidfile: %v", err)
+			}
+		}
+		if err := sdnotify.Notify(sdnotify.Ready); err != nil {
+			log.Printf("cannot notify service manager: %v", err)
+		}
+		if readyPipe != nil {
+			notifyParent(readyPipe)
+		}
+	}
+	stopping := func() {
+		if err := sdnotify.Notify(sdnotify.Stopping); err != nil {
+			log.Printf("cannot notify service manager: %v", err)
+		}
+	}
+	if c.Flags.PIDFile != "" {
+		defer func() {
+			if err := os.Remove(c.Flags.PIDFile); err != nil && !os.IsNotExist(err) {
+				log.Printf("cannot remove pidfile: %v", err)
+			}
+		}()
+	}
+	if err := plopfs.Mount(cfg,
+		plopfs.WithServe(serve),
+		plopfs.WithReload(reload),
+		plopfs.WithReady(ready),
+		plopfs.WithStopping(stopping),
+	); err != nil {
 		return err
 	}
 	return nil
@@ -27,5 +99,9 @@ var mount = mountCommand{
 }
 
 func init() {
+	mount.BoolVar(&mount.Flags.Daemon, "daemon", false, "run in the background, returning once the mount is serving")
+	mount.StringVar(&mount.Flags.PIDFile, "pidfile", "", "write process ID to file once serving")
+	mount.BoolVar(&mount.Flags.Unmount, "unmount", false, "unmount the configured mountpoints and exit")
+	mount.StringVar(&mount.Flags.MetricsListen, "metrics-listen", "", "serve Prometheus metrics over HTTP on this address, at /metrics")
 	subcommands.Register(&mount)
 }
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+
+	"bazil.org/plop/cas"
+	"bazil.org/plop/internal/config"
+	"bazil.org/plop/internal/daemon"
+)
+
+// Objects accesses the objects of a volume, either through a running
+// daemon, or in this process.
+type Objects interface {
+	// Create stores the content of r, recording source and labels in
+	// the catalog.
+	Create(ctx context.Context, r io.Reader, source string, labels map[string]string) (string, error)
+	Open(ctx context.Context, key string) (Object, error)
+	// SyncCatalog synchronizes the catalog with the copy stored in
+	// the volume, if the volume is configured to do so.
+	SyncCatalog(ctx context.Context) error
+}
+
+// Object is an object opened with Objects.
+type Object interface {
+	Size() int64
+	NewReader(ctx context.Context) (io.ReadCloser, error)
+}
+
+// Objects returns access to the objects of the volume. A daemon
+// listening on the control socket is used if it can serve the
+// volume; otherwise the volume is opened in this process, prompting
+// for the passphrase if needed.
+func (p *plop) Objects(ctx context.Context, vol *config.Volume) (Objects, error) {
+	cfg, err := p.Config()
+	if err != nil {
+		return nil, err
+	}
+	if !p.Flags.NoDaemon {
+		client, err := daemon.Dial(cfg.ControlSocketPath())
+		switch {
+		case errors.Is(err, daemon.ErrNotRunning):
+			// open in process
+		case err != nil:
+			return nil, err
+		default:
+			err := client.OpenVolume(ctx, vol.Name)
+			if err == nil {
+				objects := &daemonObjects{
+					client: client,
+					volume: vol.Name,
+				}
+				return objects, nil
+			}
+			client.Close()
+			if !errors.Is(err, daemon.ErrLocked) && !errors.Is(err, daemon.ErrNoVolume) {
+				return nil, err
+			}
+			if p.Flags.Verbose {
+				log.Printf("not using daemon: %v", err)
+			}
+		}
+	}
+	store, err := p.Store(vol)
+	if err != nil {
+		return nil, err
+	}
+	objects := &localObjects{
+		vol:   vol,
+		store: store,
+	}
+	return objects, nil
+}
+
+type localObjects struct {
+	vol   *config.Volume
+	store *cas.Store
+}
+
+var _ Objects = (*localObjects)(nil)
+
+func (l *localObjects) Create(ctx context.Context, r io.Reader, source string, labels map[string]string) (string, error) {
+	var opts []cas.CreateOption
+	if source != "" {
+		opts = append(opts, cas.CreateSource(source))
+	}
+	opts = append(opts, cas.CreateLabels(labels))
+	return l.store.Create(ctx, r, opts...)
+}
+
+func (l *localObjects) Open(ctx context.Context, key string) (Object, error) {
+	h, err := l.store.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return localObject{h: h}, nil
+}
+
+func (l *localObjects) SyncCatalog(ctx context.Context) error {
+	return Plop.SyncCatalog(ctx, l.vol, l.store)
+}
+
+type localObject struct {
+	h *cas.Handle
+}
+
+func (o localObject) Size() int64 {
+	return o.h.Size()
+}
+
+func (o localObject) NewReader(ctx context.Context) (io.ReadCloser, error) {
+	return io.NopCloser(o.h.IO(ctx)), nil
+}
+
+type daemonObjects struct {
+	client *daemon.Client
+	volume string
+}
+
+var _ Objects = (*daemonObjects)(nil)
+
+func (d *daemonObjects) Create(ctx context.Context, r io.Reader, source string, labels map[string]string) (string, error) {
+	return d.client.Create(ctx, d.volume, r, source, labels)
+}
+
+func (d *daemonObjects) Open(ctx context.Context, key string) (Object, error) {
+	return d.client.Open(ctx, d.volume, key)
+}
+
+func (d *daemonObjects) SyncCatalog(ctx context.Context) error {
+	return d.client.SyncCatalog(ctx, d.volume)
+}
@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// promptPassphrase asks the user for the passphrase of the named
+// volume, on the controlling terminal.
+func promptPassphrase(volumeName string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("volume %q has no passphrase configured and cannot prompt for one: %w", volumeName, err)
+	}
+	defer tty.Close()
+	if _, err := fmt.Fprintf(tty, "Passphrase for volume %q: ", volumeName); err != nil {
+		return "", err
+	}
+	buf, err := term.ReadPassword(int(tty.Fd()))
+	// ReadPassword swallows the newline
+	_, _ = fmt.Fprintln(tty)
+	if err != nil {
+		return "", fmt.Errorf("cannot read passphrase: %w", err)
+	}
+	if len(buf) == 0 {
+		return "", errors.New("empty passphrase")
+	}
+	return string(buf), nil
+}
+
+// PromptPassphrase asks the user for the passphrase of the named
+// volume, on the controlling terminal.
+func (p *plop) PromptPassphrase(volumeName string) (string, error) {
+	return promptPassphrase(volumeName)
+}
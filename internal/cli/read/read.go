@@ -7,7 +7,6 @@ import (
 	"io"
 	"os"
 
-	"bazil.org/plop/cas"
 	cliplop "bazil.org/plop/internal/cli"
 	"github.com/tv42/cliutil/subcommands"
 )
@@ -23,12 +22,17 @@ type readCommand struct {
 	}
 }
 
-func (c *readCommand) readKey(ctx context.Context, store *cas.Store, k string, w io.Writer) error {
-	h, err := store.Open(ctx, k)
+func (c *readCommand) readKey(ctx context.Context, objects cliplop.Objects, k string, w io.Writer) error {
+	obj, err := objects.Open(ctx, k)
 	if err != nil {
 		return err
 	}
-	if _, err := io.Copy(w, h.IO(ctx)); err != nil {
+	r, err := obj.NewReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if _, err := io.Copy(w, r); err != nil {
 		return err
 	}
 	return nil
@@ -40,13 +44,13 @@ func (c *readCommand) Run() error {
 	if err != nil {
 		return err
 	}
-	store, err := cliplop.Plop.Store(vol)
+	objects, err := cliplop.Plop.Objects(ctx, vol)
 	if err != nil {
 		return err
 	}
 
 	for _, k := range c.Arguments.Key {
-		if err := c.readKey(ctx, store, k, os.Stdout); err != nil {
+		if err := c.readKey(ctx, objects, k, os.Stdout); err != nil {
 			return fmt.Errorf("cannot read from plop: %v", err)
 		}
 	}
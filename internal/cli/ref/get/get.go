@@ -0,0 +1,55 @@
+package get
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"bazil.org/plop/cas"
+	cliplop "bazil.org/plop/internal/cli"
+	"github.com/tv42/cliutil/subcommands"
+)
+
+type getCommand struct {
+	subcommands.Description
+	flag.FlagSet
+	Flags struct {
+		Volume string
+	}
+	Arguments struct {
+		Name []string
+	}
+}
+
+func (c *getCommand) Run() error {
+	ctx := context.TODO()
+	vol, err := cliplop.Plop.Volume(c.Flags.Volume)
+	if err != nil {
+		return err
+	}
+	store, err := cliplop.Plop.Store(vol)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range c.Arguments.Name {
+		ref, err := store.GetRef(ctx, name)
+		if err != nil {
+			return fmt.Errorf("cannot read ref: %v", err)
+		}
+		if ref.Deleted() {
+			return fmt.Errorf("cannot read ref: %v: %s", cas.ErrRefNotExist, name)
+		}
+		fmt.Println(ref.Key)
+	}
+	return nil
+}
+
+var get = getCommand{
+	Description: "show the key a named ref points to",
+}
+
+func init() {
+	get.StringVar(&get.Flags.Volume, "volume", "", "volume to use")
+	subcommands.Register(&get)
+}
@@ -0,0 +1,67 @@
+package log
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"bazil.org/plop/cas"
+	cliplop "bazil.org/plop/internal/cli"
+	"github.com/tv42/cliutil/subcommands"
+)
+
+type logCommand struct {
+	subcommands.Description
+	flag.FlagSet
+	Flags struct {
+		Volume string
+	}
+	Arguments struct {
+		Name string
+	}
+}
+
+func (c *logCommand) showLog(ctx context.Context, store *cas.Store, name string, w io.Writer) error {
+	refs, err := store.RefLog(ctx, name)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		key := ref.Key
+		if ref.Deleted() {
+			key = "-"
+		}
+		if _, err := fmt.Fprintf(w, "%d\t%s\t%s\n", ref.Seq, ref.Time.Format(time.RFC3339), key); err != nil {
+			return fmt.Errorf("writing to output: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *logCommand) Run() error {
+	ctx := context.TODO()
+	vol, err := cliplop.Plop.Volume(c.Flags.Volume)
+	if err != nil {
+		return err
+	}
+	store, err := cliplop.Plop.Store(vol)
+	if err != nil {
+		return err
+	}
+	if err := c.showLog(ctx, store, c.Arguments.Name, os.Stdout); err != nil {
+		return fmt.Errorf("cannot read ref log: %v", err)
+	}
+	return nil
+}
+
+var log = logCommand{
+	Description: "show the history of a named ref",
+}
+
+func init() {
+	log.StringVar(&log.Flags.Volume, "volume", "", "volume to use")
+	subcommands.Register(&log)
+}
@@ -0,0 +1,68 @@
+package set
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"bazil.org/plop/cas"
+	cliplop "bazil.org/plop/internal/cli"
+	"github.com/tv42/cliutil/subcommands"
+)
+
+type setCommand struct {
+	subcommands.Description
+	flag.FlagSet
+	Flags struct {
+		Volume string
+		Old    string
+	}
+	Arguments struct {
+		Name string
+		Key  string
+	}
+}
+
+func (c *setCommand) Run() error {
+	ctx := context.TODO()
+	vol, err := cliplop.Plop.Volume(c.Flags.Volume)
+	if err != nil {
+		return err
+	}
+	store, err := cliplop.Plop.Store(vol)
+	if err != nil {
+		return err
+	}
+
+	var prevSeq uint64
+	cur, err := store.GetRef(ctx, c.Arguments.Name)
+	switch {
+	case errors.Is(err, cas.ErrRefNotExist):
+		if c.Flags.Old != "" {
+			return fmt.Errorf("ref does not exist: %s", c.Arguments.Name)
+		}
+	case err != nil:
+		return fmt.Errorf("cannot read ref: %v", err)
+	default:
+		if c.Flags.Old != "" && cur.Key != c.Flags.Old {
+			return fmt.Errorf("ref %s points to %s, not %s", c.Arguments.Name, cur.Key, c.Flags.Old)
+		}
+		prevSeq = cur.Seq
+	}
+
+	if _, err := store.SetRef(ctx, c.Arguments.Name, c.Arguments.Key, prevSeq); err != nil {
+		return fmt.Errorf("cannot set ref: %v", err)
+	}
+	return nil
+}
+
+var set = setCommand{
+	Description: "point a named ref at a key",
+}
+
+func init() {
+	set.StringVar(&set.Flags.Volume, "volume", "", "volume to use")
+	set.StringVar(&set.Flags.Old, "old", "", "only update if the ref currently points to this key")
+	subcommands.Register(&set)
+}
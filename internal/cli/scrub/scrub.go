@@ -0,0 +1,86 @@
+package scrub
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"bazil.org/plop/cas"
+	cliplop "bazil.org/plop/internal/cli"
+	"bazil.org/plop/internal/config"
+	"github.com/tv42/cliutil/subcommands"
+)
+
+type scrubCommand struct {
+	subcommands.Description
+	flag.FlagSet
+	Flags struct {
+		Bucket string
+		Prefix string
+	}
+}
+
+// report prints objects that failed the scrub, and counts them.
+type report struct {
+	w         io.Writer
+	total     int
+	bad       int
+	unchecked int
+}
+
+func (r *report) add(res cas.ScrubResult) error {
+	r.total++
+	if res.Err == nil {
+		return nil
+	}
+	if errors.Is(res.Err, cas.ErrNoChecksum) {
+		r.unchecked++
+	} else {
+		r.bad++
+	}
+	if _, err := fmt.Fprintf(r.w, "%s\t%d\t%v\n", res.Key, res.Size, res.Err); err != nil {
+		return fmt.Errorf("writing to output: %w", err)
+	}
+	return nil
+}
+
+func (c *scrubCommand) Run() error {
+	ctx := context.TODO()
+	if c.Flags.Bucket == "" {
+		return errors.New("-bucket is required")
+	}
+	cfg, err := cliplop.Plop.Config()
+	if err != nil {
+		return err
+	}
+	bucket, err := config.OpenBucketURL(ctx, cfg, c.Flags.Bucket)
+	if err != nil {
+		return err
+	}
+	defer bucket.Close()
+
+	r := &report{w: os.Stdout}
+	if err := cas.Scrub(ctx, bucket, c.Flags.Prefix, r.add); err != nil {
+		return err
+	}
+	if r.unchecked > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d objects have no checksum to check\n", r.unchecked, r.total)
+	}
+	if r.bad > 0 {
+		return fmt.Errorf("%d of %d objects failed the scrub", r.bad, r.total)
+	}
+	return nil
+}
+
+var scrub = scrubCommand{
+	Description: "check stored objects against the checksums of the bucket",
+}
+
+func init() {
+	scrub.StringVar(&scrub.Flags.Bucket, "bucket", "", "URL of bucket to scrub")
+	scrub.StringVar(&scrub.Flags.Prefix, "prefix", "", "only scrub objects with this key prefix")
+	subcommands.Register(&scrub)
+}
@@ -0,0 +1,73 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	cliplop "bazil.org/plop/internal/cli"
+	"bazil.org/plop/internal/daemon"
+	"github.com/tv42/cliutil/subcommands"
+)
+
+type statusCommand struct {
+	subcommands.Description
+	flag.FlagSet
+}
+
+func (c *statusCommand) print(status *daemon.Status, w io.Writer) error {
+	for _, vol := range status.Volumes {
+		if !vol.Open {
+			if _, err := fmt.Fprintf(w, "%s\t-\tnot open\n", vol.Name); err != nil {
+				return fmt.Errorf("writing to output: %w", err)
+			}
+			continue
+		}
+		for _, b := range vol.Buckets {
+			line := fmt.Sprintf("%s\t%s\t%s", vol.Name, b.Name, b.State)
+			if b.Since != nil {
+				line += "\tsince " + b.Since.Format(time.RFC3339)
+			}
+			if b.Failures > 0 {
+				line += fmt.Sprintf("\t%d failures: %s", b.Failures, b.LastError)
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return fmt.Errorf("writing to output: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *statusCommand) Run() error {
+	ctx := context.TODO()
+	cfg, err := cliplop.Plop.Config()
+	if err != nil {
+		return err
+	}
+	client, err := daemon.Dial(cfg.ControlSocketPath())
+	if errors.Is(err, daemon.ErrNotRunning) {
+		return errors.New("bucket health is tracked by the daemon, and it is not running")
+	}
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	status, err := client.Status(ctx)
+	if err != nil {
+		return err
+	}
+	return c.print(status, os.Stdout)
+}
+
+var status = statusCommand{
+	Description: "show the health of the buckets of volumes open in the daemon",
+}
+
+func init() {
+	subcommands.Register(&status)
+}
@@ -0,0 +1,85 @@
+package unlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"bazil.org/plop/cas"
+	cliplop "bazil.org/plop/internal/cli"
+	"bazil.org/plop/internal/config"
+	"bazil.org/plop/internal/daemon"
+	"github.com/tv42/cliutil/subcommands"
+)
+
+type unlockCommand struct {
+	subcommands.Description
+	Arguments struct {
+		Volume string
+	}
+}
+
+func (c *unlockCommand) Run() error {
+	cfg, err := cliplop.Plop.Config()
+	if err != nil {
+		return err
+	}
+	vol, err := cliplop.Plop.Volume(c.Arguments.Volume)
+	if err != nil {
+		return err
+	}
+	passphrase, err := cliplop.Plop.PromptPassphrase(vol.Name)
+	if err != nil {
+		return err
+	}
+	client, err := daemon.Dial(cfg.ControlSocketPath())
+	if errors.Is(err, daemon.ErrNotRunning) {
+		// a mount that is not serving the control socket
+		return unlockFile(cfg, vol, passphrase)
+	}
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ctx := context.Background()
+	if err := client.Unlock(ctx, vol.Name, passphrase); err != nil {
+		if errors.Is(err, cas.ErrWrongPassphrase) {
+			return fmt.Errorf("cannot unlock volume %q: wrong passphrase", vol.Name)
+		}
+		return fmt.Errorf("cannot unlock: %w", err)
+	}
+	return nil
+}
+
+// unlockFile gives the passphrase through the unlock directory of
+// the mount. Only writable mounts accept it.
+func unlockFile(cfg *config.Config, vol *config.Volume, passphrase string) error {
+	p := filepath.Join(cfg.MountPoint, ".unlock", vol.Name)
+	f, err := os.OpenFile(p, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("cannot unlock: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(passphrase); err != nil {
+		return fmt.Errorf("cannot unlock: %w", err)
+	}
+	// the passphrase is checked on close
+	if err := f.Close(); err != nil {
+		if errors.Is(err, syscall.EACCES) {
+			return fmt.Errorf("cannot unlock volume %q: wrong passphrase", vol.Name)
+		}
+		return fmt.Errorf("cannot unlock: %w", err)
+	}
+	return nil
+}
+
+var unlock = unlockCommand{
+	Description: "give the passphrase of a volume to a running mount or daemon",
+}
+
+func init() {
+	subcommands.Register(&unlock)
+}
@@ -7,9 +7,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 
-	"bazil.org/plop/cas"
 	cliplop "bazil.org/plop/internal/cli"
+	"bazil.org/plop/internal/flagx"
 	"github.com/tv42/cliutil/positional"
 	"github.com/tv42/cliutil/subcommands"
 	"golang.org/x/term"
@@ -20,6 +21,7 @@ type writeCommand struct {
 	flag.FlagSet
 	Flags struct {
 		Volume string
+		Labels flagx.Labels
 	}
 	Arguments struct {
 		positional.Optional
@@ -27,8 +29,8 @@ type writeCommand struct {
 	}
 }
 
-func (c *writeCommand) writeFromReader(ctx context.Context, store *cas.Store, r io.Reader) error {
-	key, err := store.Create(ctx, r)
+func (c *writeCommand) writeFromReader(ctx context.Context, objects cliplop.Objects, r io.Reader, source string) error {
+	key, err := objects.Create(ctx, r, source, c.Flags.Labels)
 	if err != nil {
 		return err
 	}
@@ -36,13 +38,17 @@ func (c *writeCommand) writeFromReader(ctx context.Context, store *cas.Store, r
 	return nil
 }
 
-func (c *writeCommand) writeFromPath(ctx context.Context, store *cas.Store, p string) error {
+func (c *writeCommand) writeFromPath(ctx context.Context, objects cliplop.Objects, p string) error {
 	f, err := os.Open(p)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	return c.writeFromReader(ctx, store, f)
+	source, err := filepath.Abs(p)
+	if err != nil {
+		return err
+	}
+	return c.writeFromReader(ctx, objects, f, source)
 }
 
 func (c *writeCommand) Run() error {
@@ -51,7 +57,7 @@ func (c *writeCommand) Run() error {
 	if err != nil {
 		return err
 	}
-	store, err := cliplop.Plop.Store(vol)
+	objects, err := cliplop.Plop.Objects(ctx, vol)
 	if err != nil {
 		return err
 	}
@@ -60,18 +66,18 @@ func (c *writeCommand) Run() error {
 		if term.IsTerminal(0) {
 			return errors.New("refusing to read from terminal")
 		}
-		if err := c.writeFromReader(ctx, store, os.Stdin); err != nil {
+		if err := c.writeFromReader(ctx, objects, os.Stdin, ""); err != nil {
 			return fmt.Errorf("cannot write to plop: %v", err)
 		}
-		return nil
+		return objects.SyncCatalog(ctx)
 	}
 
 	for _, p := range c.Arguments.File {
-		if err := c.writeFromPath(ctx, store, p); err != nil {
+		if err := c.writeFromPath(ctx, objects, p); err != nil {
 			return fmt.Errorf("cannot write to plop: %v", err)
 		}
 	}
-	return nil
+	return objects.SyncCatalog(ctx)
 }
 
 var write = writeCommand{
@@ -80,5 +86,6 @@ var write = writeCommand{
 
 func init() {
 	write.StringVar(&write.Flags.Volume, "volume", "", "volume to write to")
+	write.Var(&write.Flags.Labels, "label", "label to record in the catalog, as KEY=VALUE (repeatable)")
 	subcommands.Register(&write)
 }
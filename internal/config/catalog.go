@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"bazil.org/plop/internal/catalog"
+)
+
+// userDataDir returns the directory for user-specific data files,
+// following the XDG Base Directory Specification.
+func userDataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
+
+// OpenCatalog returns the catalog of the volume, or nil if the volume
+// has no catalog configured.
+func OpenCatalog(cfg *Config, vol *Volume) (*catalog.Catalog, error) {
+	if vol.Catalog == nil {
+		return nil, nil
+	}
+	p := vol.Catalog.Path
+	if p == "" {
+		dir, err := userDataDir()
+		if err != nil {
+			return nil, fmt.Errorf("volume %q catalog: %w", vol.Name, err)
+		}
+		p = filepath.Join(dir, "plop", "catalog", vol.Name+".jsonl")
+	} else {
+		p = cfg.resolvePath(p)
+	}
+	return catalog.Open(p), nil
+}
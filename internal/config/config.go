@@ -27,6 +27,22 @@ type Config struct {
 	Volumes       []*Volume `hcl:"volume,block"`
 	volumes       map[string]*Volume
 	Chunker       *ChunkerConfig `hcl:"chunker,block"`
+	Mount         *MountConfig   `hcl:"mount,block"`
+	mount         *MountOptions
+	Tracing       *TracingConfig `hcl:"tracing,block"`
+	tracing       *TracingOptions
+	// ControlSocket is the path of the unix socket where the daemon
+	// listens. See ControlSocketPath for the default.
+	ControlSocket string `hcl:"control_socket,optional"`
+}
+
+// resolvePath interprets relative paths relative to the directory of
+// the config file.
+func (cfg *Config) resolvePath(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(filepath.Dir(cfg.path), p)
 }
 
 func (cfg *Config) GetDefaultVolume() (*Volume, error) {
@@ -43,13 +59,45 @@ func (cfg *Config) GetVolume(name string) (_ *Volume, ok bool) {
 }
 
 type Volume struct {
-	Name       string         `hcl:"volume,label"`
-	Passphrase string         `hcl:"passphrase"`
-	Buckets    []*Bucket      `hcl:"bucket,block"`
-	Chunker    *ChunkerConfig `hcl:"chunker,block"`
+	Name string `hcl:"volume,label"`
+	// Passphrase is the volume secret as plaintext. At most one of
+	// the passphrase fields may be set. If none are set, the
+	// passphrase must be provided at runtime, for example by
+	// prompting the user.
+	Passphrase string `hcl:"passphrase,optional"`
+	// PassphraseFile is a path to a file containing the passphrase.
+	// The file must not be accessible by group or other users.
+	//
+	// Relative paths are interpreted relative to the Plop
+	// configuration directory.
+	PassphraseFile string `hcl:"passphrase_file,optional"`
+	// PassphraseEnv is the name of an environment variable holding
+	// the passphrase.
+	PassphraseEnv string `hcl:"passphrase_env,optional"`
+	// PassphraseCommand is a shell command that outputs the
+	// passphrase. It is run in the Plop configuration directory.
+	PassphraseCommand string `hcl:"passphrase_command,optional"`
+	// Prefix is prepended to the names of all objects stored in the
+	// buckets. This lets multiple volumes share a bucket.
+	Prefix  string         `hcl:"prefix,optional"`
+	Buckets []*Bucket      `hcl:"bucket,block"`
+	Chunker *ChunkerConfig `hcl:"chunker,block"`
+	Catalog *CatalogConfig `hcl:"catalog,block"`
+	// Incoming enables a writable incoming directory in the volume,
+	// when mounted with plopfs. Files written there are stored in
+	// the volume when closed.
+	Incoming bool `hcl:"incoming,optional"`
+	// MountPoint is an optional path where plopfs serves this volume
+	// on its own, in addition to the main mountpoint.
+	MountPoint string `hcl:"mountpoint,optional"`
 }
 
 type Bucket struct {
+	// Delay is how long to wait for the buckets before this one,
+	// before trying this one too, as a duration string. Once the
+	// latency of the buckets is known, requests are hedged sooner,
+	// and buckets may be tried in another order; the delays then
+	// only bound how long requests wait.
 	Delay *string `hcl:"delay"`
 	delay time.Duration
 	URL   string `hcl:"url"`
@@ -62,6 +110,20 @@ type Bucket struct {
 	// bytes of the encoding key, but is instead the corresponding
 	// `zbase32.EncodeBitsToString` of the binary boxed key.
 	ShardBits uint8 `hcl:"shard_bits,optional"`
+	// Retry sets how failed requests to the bucket are retried.
+	Retry *RetryConfig `hcl:"retry,block"`
+	retry cas.RetryPolicy
+	// MaxUploadBandwidth and MaxDownloadBandwidth limit the bytes
+	// per second written to and read from the bucket. Use the KiB
+	// and MiB variables for readability.
+	MaxUploadBandwidth   *uint64 `hcl:"max_upload_bandwidth,optional"`
+	MaxDownloadBandwidth *uint64 `hcl:"max_download_bandwidth,optional"`
+	// MaxRequestsPerSecond limits the requests sent to the bucket.
+	//
+	// Limits are shared by all volumes using the same bucket URL.
+	// Reads from a mount are served before other requests, and
+	// storing files written to a mount comes last.
+	MaxRequestsPerSecond *float64 `hcl:"max_requests_per_second,optional"`
 }
 
 type AWSConfig struct {
@@ -80,6 +142,20 @@ type AWSCredentialsFile struct {
 	Profile *string `hcl:"profile"`
 }
 
+type CatalogConfig struct {
+	// Path to the local catalog file.
+	//
+	// Relative paths are interpreted relative to the Plop
+	// configuration directory.
+	//
+	// Empty string means a file named after the volume in the user
+	// data directory.
+	Path string `hcl:"path,optional"`
+	// Sync keeps a copy of the catalog in the volume itself, and
+	// merges it with the local catalog.
+	Sync bool `hcl:"sync,optional"`
+}
+
 type ChunkerConfig struct {
 	// hcl doesn't have convenient custom unmarshaling, so we're doing
 	// byte sizes by defining "MiB" etc variables and letting config
@@ -134,19 +210,31 @@ func ParseConfig(filename string, src []byte) (*Config, error) {
 	return &cfg, nil
 }
 
+// ReadConfig reads the config file at p, and folds in any local
+// config found in the current directory and above it.
 func ReadConfig(p string) (*Config, error) {
+	return readConfig(p, true)
+}
+
+// ReadConfigFile reads the config file at p, ignoring local config.
+func ReadConfigFile(p string) (*Config, error) {
+	return readConfig(p, false)
+}
+
+func readConfig(p string, withLocal bool) (*Config, error) {
 	var cfg Config
 	if err := hclsimple.DecodeFile(p, evalCtx, &cfg); err != nil {
 		return nil, fmt.Errorf("cannot read config: %w", err)
 	}
 	cfg.path = p
-	// fold in any local config; TODO flag to disable?
-	local, err := ReadLocalConfig()
-	if err != nil {
-		return nil, err
-	}
-	if n := local.DefaultVolume; n != "" {
-		cfg.DefaultVolume = n
+	if withLocal {
+		local, err := ReadLocalConfig()
+		if err != nil {
+			return nil, err
+		}
+		if n := local.DefaultVolume; n != "" {
+			cfg.DefaultVolume = n
+		}
 	}
 
 	if err := parseConfig(&cfg); err != nil {
@@ -188,8 +276,8 @@ func parseConfig(cfg *Config) error {
 		if strings.ContainsAny(vol.Name, "/\x00") {
 			return fmt.Errorf("config field volume %q name must not contain slashes or zero bytes", vol.Name)
 		}
-		if vol.Passphrase == "" {
-			return fmt.Errorf("config block volume %q passphrase must be set", vol.Name)
+		if n := vol.numPassphraseSources(); n > 1 {
+			return fmt.Errorf("config block volume %q must set only one of passphrase, passphrase_file, passphrase_env, passphrase_command", vol.Name)
 		}
 		if len(vol.Buckets) == 0 {
 			return fmt.Errorf("config block volume %q bucket must be present", vol.Name)
@@ -218,8 +306,24 @@ func parseConfig(cfg *Config) error {
 				}
 				bucket.delay = d
 			}
+
+			policy, err := parseRetry(bucket.Retry)
+			if err != nil {
+				return fmt.Errorf("config block volume %q bucket %v retry: %v", vol.Name, bucket.url.String(), err)
+			}
+			bucket.retry = policy
+
+			if err := parseLimits(bucket); err != nil {
+				return fmt.Errorf("config block volume %q bucket %v: %v", vol.Name, bucket.url.String(), err)
+			}
 		}
 	}
 
+	if err := parseMount(cfg); err != nil {
+		return err
+	}
+	if err := parseTracing(cfg); err != nil {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ControlSocketPath returns the path of the unix socket where the
+// daemon listens, and where commands look for it. Defaults to
+// plop/control.sock in $XDG_RUNTIME_DIR, or in a per-user directory
+// under the temporary directory if that is not set.
+func (cfg *Config) ControlSocketPath() string {
+	if p := cfg.ControlSocket; p != "" {
+		return cfg.resolvePath(p)
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "plop", "control.sock")
+	}
+	dir := "plop-" + strconv.Itoa(os.Getuid())
+	return filepath.Join(os.TempDir(), dir, "control.sock")
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestControlSocketPathDefault(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	cfg, _ := parseTestVolume(t, t.TempDir(), `passphrase = "s3kr1t"`)
+	if g, e := cfg.ControlSocketPath(), filepath.Join(dir, "plop", "control.sock"); g != e {
+		t.Errorf("wrong socket path: %q != %q", g, e)
+	}
+}
+
+func TestControlSocketPathRelative(t *testing.T) {
+	dir := t.TempDir()
+	src := `
+mountpoint = "/does-not-exist"
+control_socket = "run/plop.sock"
+volume "testvolume" {
+  bucket {
+    url = "mem://"
+  }
+}
+`
+	cfg, err := ParseConfig(filepath.Join(dir, "config.hcl"), []byte(src))
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	if g, e := cfg.ControlSocketPath(), filepath.Join(dir, "run", "plop.sock"); g != e {
+		t.Errorf("wrong socket path: %q != %q", g, e)
+	}
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"sync"
+
+	"bazil.org/plop/cas"
+	"bazil.org/plop/internal/ratelimit"
+)
+
+func parseLimits(bucket *Bucket) error {
+	if bucket.MaxUploadBandwidth != nil && *bucket.MaxUploadBandwidth == 0 {
+		return fmt.Errorf("max_upload_bandwidth must be positive")
+	}
+	if bucket.MaxDownloadBandwidth != nil && *bucket.MaxDownloadBandwidth == 0 {
+		return fmt.Errorf("max_download_bandwidth must be positive")
+	}
+	if bucket.MaxRequestsPerSecond != nil && !(*bucket.MaxRequestsPerSecond > 0) {
+		return fmt.Errorf("max_requests_per_second must be positive")
+	}
+	return nil
+}
+
+// bucketLimiters are the rate limiters for one bucket URL.
+type bucketLimiters struct {
+	upload   *ratelimit.Limiter
+	download *ratelimit.Limiter
+	requests *ratelimit.Limiter
+}
+
+// limiters holds the rate limiters of every bucket opened in this
+// process, by bucket URL, so that all volumes and stores using a
+// bucket share its limits.
+var limiters = struct {
+	mu    sync.Mutex
+	byURL map[string]*bucketLimiters
+}{
+	byURL: make(map[string]*bucketLimiters),
+}
+
+// setLimiter creates or updates a limiter allowing rate tokens per
+// second. Bursts are up to one second worth of tokens.
+func setLimiter(l **ratelimit.Limiter, rate float64) *ratelimit.Limiter {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	if *l == nil {
+		*l = ratelimit.New(rate, burst)
+	} else {
+		(*l).SetRate(rate, burst)
+	}
+	return *l
+}
+
+// bucketLimits returns the rate limits configured for the bucket.
+// Limiters are shared by all users of the same bucket URL in the
+// process, and take the most recently opened configuration.
+func bucketLimits(bucket *Bucket) cas.Limits {
+	limiters.mu.Lock()
+	defer limiters.mu.Unlock()
+	key := bucket.url.String()
+	shared, ok := limiters.byURL[key]
+	if !ok {
+		shared = &bucketLimiters{}
+		limiters.byURL[key] = shared
+	}
+	var limits cas.Limits
+	if bucket.MaxUploadBandwidth != nil {
+		limits.Upload = setLimiter(&shared.upload, float64(*bucket.MaxUploadBandwidth))
+	}
+	if bucket.MaxDownloadBandwidth != nil {
+		limits.Download = setLimiter(&shared.download, float64(*bucket.MaxDownloadBandwidth))
+	}
+	if bucket.MaxRequestsPerSecond != nil {
+		limits.Requests = setLimiter(&shared.requests, *bucket.MaxRequestsPerSecond)
+	}
+	return limits
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+const limitsTestConfig = `
+mountpoint = "/does-not-exist"
+volume "one" {
+  bucket {
+    url = "mem://limits-test"
+    max_upload_bandwidth = 2 * MiB
+    max_download_bandwidth = 512 * KiB
+    max_requests_per_second = 0.5
+  }
+  bucket {
+    url = "mem://limits-test-unlimited"
+  }
+}
+volume "two" {
+  bucket {
+    url = "mem://limits-test"
+    max_requests_per_second = 10
+  }
+}
+`
+
+func TestLimitsConfig(t *testing.T) {
+	cfg, err := ParseConfig("<test literal>.hcl", []byte(limitsTestConfig))
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	one, _ := cfg.GetVolume("one")
+	if g, e := *one.Buckets[0].MaxUploadBandwidth, uint64(2*1024*1024); g != e {
+		t.Errorf("wrong upload bandwidth: %v != %v", g, e)
+	}
+	if g, e := *one.Buckets[0].MaxDownloadBandwidth, uint64(512*1024); g != e {
+		t.Errorf("wrong download bandwidth: %v != %v", g, e)
+	}
+	limits := bucketLimits(one.Buckets[0])
+	if limits.Upload == nil || limits.Download == nil || limits.Requests == nil {
+		t.Errorf("missing limiters: %+v", limits)
+	}
+	if limits := bucketLimits(one.Buckets[1]); limits.Upload != nil || limits.Download != nil || limits.Requests != nil {
+		t.Errorf("unexpected limiters: %+v", limits)
+	}
+
+	// the same bucket is limited once per process
+	two, _ := cfg.GetVolume("two")
+	shared := bucketLimits(two.Buckets[0])
+	if shared.Requests != limits.Requests {
+		t.Error("request limiter is not shared")
+	}
+	if shared.Upload != nil {
+		t.Error("upload limit is not configured for volume two")
+	}
+}
+
+func TestLimitsConfigBad(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		from string
+		to   string
+		want string
+	}{
+		{"upload", `max_upload_bandwidth = 2 * MiB`, `max_upload_bandwidth = 0`, "max_upload_bandwidth must be positive"},
+		{"download", `max_download_bandwidth = 512 * KiB`, `max_download_bandwidth = 0`, "max_download_bandwidth must be positive"},
+		{"requests", `max_requests_per_second = 0.5`, `max_requests_per_second = -1`, "max_requests_per_second must be positive"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			src := strings.Replace(limitsTestConfig, tc.from, tc.to, 1)
+			_, err := ParseConfig("<test literal>.hcl", []byte(src))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("wrong error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+type MountConfig struct {
+	// AllowOther lets users other than the one running plopfs access
+	// the mount. Requires user_allow_other in /etc/fuse.conf, unless
+	// running as root.
+	AllowOther bool `hcl:"allow_other,optional"`
+	// UID and GID own all files and directories. Default to the
+	// user running plopfs.
+	UID *uint32 `hcl:"uid,optional"`
+	GID *uint32 `hcl:"gid,optional"`
+	// FileMode and DirMode are the permission bits of files and
+	// directories, as octal strings. Default to "0444" and "0555".
+	FileMode string `hcl:"file_mode,optional"`
+	DirMode  string `hcl:"dir_mode,optional"`
+	// Readahead is the maximum kernel readahead, in bytes. Defaults
+	// to 8 MiB.
+	Readahead uint32 `hcl:"readahead,optional"`
+	// RequestTimeout limits how long a single filesystem request
+	// waits for the buckets, as a duration string. Defaults to "1m".
+	// Must be positive: a request waiting on an unreachable bucket
+	// would otherwise hang the calling process until interrupted.
+	RequestTimeout *string `hcl:"request_timeout,optional"`
+	// Volumes lists the volumes to expose at the mountpoint. Default
+	// is all volumes. Volumes with their own mountpoint are served
+	// there regardless. If empty, only the volume mountpoints are
+	// mounted.
+	Volumes []string `hcl:"volumes,optional"`
+}
+
+// MountOptions is the resolved mount configuration, with defaults
+// filled in.
+type MountOptions struct {
+	AllowOther bool
+	UID        uint32
+	GID        uint32
+	FileMode   os.FileMode
+	DirMode    os.FileMode
+	Readahead  uint32
+	// RequestTimeout is always positive.
+	RequestTimeout time.Duration
+	// Volumes to expose at the main mountpoint.
+	Volumes []*Volume
+}
+
+// MountOptions returns the mount configuration.
+func (cfg *Config) MountOptions() *MountOptions {
+	return cfg.mount
+}
+
+func parseMode(s string, def os.FileMode) (os.FileMode, error) {
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid octal mode: %q", s)
+	}
+	if n&^0o777 != 0 {
+		return 0, fmt.Errorf("mode can only contain permission bits: %q", s)
+	}
+	return os.FileMode(n), nil
+}
+
+func parseMount(cfg *Config) error {
+	opts := &MountOptions{
+		UID:            uint32(os.Getuid()),
+		GID:            uint32(os.Getgid()),
+		FileMode:       0o444,
+		DirMode:        0o555,
+		Readahead:      8 * 1024 * 1024,
+		RequestTimeout: 1 * time.Minute,
+		Volumes:        cfg.Volumes,
+	}
+	if m := cfg.Mount; m != nil {
+		opts.AllowOther = m.AllowOther
+		if m.UID != nil {
+			opts.UID = *m.UID
+		}
+		if m.GID != nil {
+			opts.GID = *m.GID
+		}
+		mode, err := parseMode(m.FileMode, opts.FileMode)
+		if err != nil {
+			return fmt.Errorf("config block mount file_mode: %w", err)
+		}
+		opts.FileMode = mode
+		mode, err = parseMode(m.DirMode, opts.DirMode)
+		if err != nil {
+			return fmt.Errorf("config block mount dir_mode: %w", err)
+		}
+		opts.DirMode = mode
+		if m.Readahead != 0 {
+			opts.Readahead = m.Readahead
+		}
+		if m.RequestTimeout != nil {
+			d, err := time.ParseDuration(*m.RequestTimeout)
+			if err != nil {
+				return fmt.Errorf("config block mount request_timeout: %v", err)
+			}
+			if d <= 0 {
+				return fmt.Errorf("config block mount request_timeout must be positive: %v", d)
+			}
+			opts.RequestTimeout = d
+		}
+		if m.Volumes != nil {
+			opts.Volumes = make([]*Volume, 0, len(m.Volumes))
+			for _, name := range m.Volumes {
+				vol, ok := cfg.volumes[name]
+				if !ok {
+					return fmt.Errorf("config block mount volume %q not found", name)
+				}
+				opts.Volumes = append(opts.Volumes, vol)
+			}
+		}
+	}
+
+	seen := map[string]struct{}{
+		filepath.Clean(cfg.MountPoint): {},
+	}
+	for _, vol := range cfg.Volumes {
+		p := vol.MountPoint
+		if p == "" {
+			continue
+		}
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("config field volume %q mountpoint must be an absolute path, if set", vol.Name)
+		}
+		p = filepath.Clean(p)
+		if _, ok := seen[p]; ok {
+			return fmt.Errorf("config field volume %q mountpoint is already in use: %s", vol.Name, p)
+		}
+		seen[p] = struct{}{}
+	}
+	cfg.mount = opts
+	return nil
+}
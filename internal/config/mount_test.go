@@ -0,0 +1,124 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMountDefaults(t *testing.T) {
+	cfg, _ := parseTestVolume(t, t.TempDir(), `passphrase = "s3kr1t"`)
+	opts := cfg.MountOptions()
+	if g, e := opts.UID, uint32(os.Getuid()); g != e {
+		t.Errorf("wrong uid: %d != %d", g, e)
+	}
+	if g, e := opts.FileMode, os.FileMode(0o444); g != e {
+		t.Errorf("wrong file mode: %v != %v", g, e)
+	}
+	if g, e := opts.DirMode, os.FileMode(0o555); g != e {
+		t.Errorf("wrong dir mode: %v != %v", g, e)
+	}
+	if g, e := opts.Readahead, uint32(8*1024*1024); g != e {
+		t.Errorf("wrong readahead: %d != %d", g, e)
+	}
+	if g, e := opts.RequestTimeout, 1*time.Minute; g != e {
+		t.Errorf("wrong request timeout: %v != %v", g, e)
+	}
+	if g, e := len(opts.Volumes), 1; g != e {
+		t.Errorf("wrong number of volumes: %d != %d", g, e)
+	}
+}
+
+const mountTestConfig = `
+mountpoint = "/does-not-exist"
+mount {
+  allow_other = true
+  uid = 1234
+  gid = 5678
+  file_mode = "0440"
+  dir_mode = "0750"
+  readahead = 1 * MiB
+  request_timeout = "30s"
+  volumes = ["one"]
+}
+volume "one" {
+  bucket {
+    url = "mem://"
+  }
+}
+volume "two" {
+  mountpoint = "/srv/two"
+  bucket {
+    url = "mem://"
+  }
+}
+`
+
+func TestMountConfig(t *testing.T) {
+	cfg, err := ParseConfig("<test literal>.hcl", []byte(mountTestConfig))
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	opts := cfg.MountOptions()
+	if !opts.AllowOther {
+		t.Error("expected allow_other")
+	}
+	if g, e := opts.UID, uint32(1234); g != e {
+		t.Errorf("wrong uid: %d != %d", g, e)
+	}
+	if g, e := opts.GID, uint32(5678); g != e {
+		t.Errorf("wrong gid: %d != %d", g, e)
+	}
+	if g, e := opts.FileMode, os.FileMode(0o440); g != e {
+		t.Errorf("wrong file mode: %v != %v", g, e)
+	}
+	if g, e := opts.DirMode, os.FileMode(0o750); g != e {
+		t.Errorf("wrong dir mode: %v != %v", g, e)
+	}
+	if g, e := opts.Readahead, uint32(1024*1024); g != e {
+		t.Errorf("wrong readahead: %d != %d", g, e)
+	}
+	if g, e := opts.RequestTimeout, 30*time.Second; g != e {
+		t.Errorf("wrong request timeout: %v != %v", g, e)
+	}
+	if g, e := len(opts.Volumes), 1; g != e {
+		t.Fatalf("wrong number of volumes: %d != %d", g, e)
+	}
+	if g, e := opts.Volumes[0].Name, "one"; g != e {
+		t.Errorf("wrong volume: %q != %q", g, e)
+	}
+	two, _ := cfg.GetVolume("two")
+	if g, e := two.MountPoint, "/srv/two"; g != e {
+		t.Errorf("wrong volume mountpoint: %q != %q", g, e)
+	}
+}
+
+func TestMountConfigBad(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		from string
+		to   string
+		want string
+	}{
+		{"mode", `file_mode = "0440"`, `file_mode = "rw"`, "invalid octal mode"},
+		{"mode bits", `dir_mode = "0750"`, `dir_mode = "4755"`, "only contain permission bits"},
+		{"timeout", `request_timeout = "30s"`, `request_timeout = "soon"`, "request_timeout"},
+		{"negative timeout", `request_timeout = "30s"`, `request_timeout = "-1s"`, "must be positive"},
+		{"zero timeout", `request_timeout = "30s"`, `request_timeout = "0"`, "must be positive"},
+		{"volume", `volumes = ["one"]`, `volumes = ["three"]`, `volume "three" not found`},
+		{"relative", `mountpoint = "/srv/two"`, `mountpoint = "two"`, "must be an absolute path"},
+		{"duplicate", `mountpoint = "/srv/two"`, `mountpoint = "/does-not-exist/"`, "already in use"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			src := strings.Replace(mountTestConfig, tc.from, tc.to, 1)
+			_, err := ParseConfig("<test literal>.hcl", []byte(src))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("wrong error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// ErrNoPassphrase is returned when a volume has no passphrase source
+// configured. Callers may choose to ask the user for one.
+var ErrNoPassphrase = errors.New("no passphrase configured")
+
+func (vol *Volume) numPassphraseSources() int {
+	n := 0
+	for _, s := range []string{
+		vol.Passphrase,
+		vol.PassphraseFile,
+		vol.PassphraseEnv,
+		vol.PassphraseCommand,
+	} {
+		if s != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// trimNewline removes a single trailing newline, as commonly left by
+// editors and `echo`.
+func trimNewline(b []byte) []byte {
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	b = bytes.TrimSuffix(b, []byte("\r"))
+	return b
+}
+
+// VolumePassphrase returns the passphrase for the volume, from
+// whichever source is configured.
+//
+// If the volume has no passphrase source, the error wraps
+// ErrNoPassphrase.
+func (cfg *Config) VolumePassphrase(ctx context.Context, vol *Volume) (string, error) {
+	var passphrase []byte
+	switch {
+	case vol.Passphrase != "":
+		return vol.Passphrase, nil
+
+	case vol.PassphraseFile != "":
+		buf, err := readPassphraseFile(cfg.resolvePath(vol.PassphraseFile))
+		if err != nil {
+			return "", fmt.Errorf("volume %q passphrase_file: %w", vol.Name, err)
+		}
+		passphrase = buf
+
+	case vol.PassphraseEnv != "":
+		s, ok := os.LookupEnv(vol.PassphraseEnv)
+		if !ok {
+			return "", fmt.Errorf("volume %q passphrase_env: environment variable not set: %s", vol.Name, vol.PassphraseEnv)
+		}
+		passphrase = []byte(s)
+
+	case vol.PassphraseCommand != "":
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", vol.PassphraseCommand)
+		cmd.Dir = filepath.Dir(cfg.path)
+		cmd.Stderr = os.Stderr
+		buf, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("volume %q passphrase_command: %w", vol.Name, err)
+		}
+		passphrase = buf
+
+	default:
+		return "", fmt.Errorf("volume %q: %w", vol.Name, ErrNoPassphrase)
+	}
+
+	passphrase = trimNewline(passphrase)
+	if len(passphrase) == 0 {
+		return "", fmt.Errorf("volume %q: passphrase is empty", vol.Name)
+	}
+	return string(passphrase), nil
+}
+
+func readPassphraseFile(p string) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("not a regular file: %s", p)
+	}
+	if perm := fi.Mode().Perm(); perm&0o077 != 0 {
+		return nil, fmt.Errorf("file must not be accessible by group or others: %s: mode %04o", p, perm)
+	}
+	buf, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
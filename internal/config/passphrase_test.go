@@ -0,0 +1,135 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func parseTestVolume(t testing.TB, dir string, volumeBody string) (*Config, *Volume) {
+	t.Helper()
+	src := fmt.Sprintf(`
+mountpoint = "/does-not-exist"
+volume "testvolume" {
+%s
+  bucket {
+    url = "mem://"
+  }
+}
+`, volumeBody)
+	cfg, err := ParseConfig(filepath.Join(dir, "config.hcl"), []byte(src))
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	vol, ok := cfg.GetVolume("testvolume")
+	if !ok {
+		t.Fatal("volume not found")
+	}
+	return cfg, vol
+}
+
+func TestPassphraseLiteral(t *testing.T) {
+	ctx := context.Background()
+	cfg, vol := parseTestVolume(t, t.TempDir(), `passphrase = "s3kr1t"`)
+	got, err := cfg.VolumePassphrase(ctx, vol)
+	if err != nil {
+		t.Fatalf("VolumePassphrase: %v", err)
+	}
+	if g, e := got, "s3kr1t"; g != e {
+		t.Errorf("wrong passphrase: %q != %q", g, e)
+	}
+}
+
+func TestPassphraseFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	p := filepath.Join(dir, "secret")
+	if err := os.WriteFile(p, []byte("s3kr1t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// relative to config file
+	cfg, vol := parseTestVolume(t, dir, `passphrase_file = "secret"`)
+	got, err := cfg.VolumePassphrase(ctx, vol)
+	if err != nil {
+		t.Fatalf("VolumePassphrase: %v", err)
+	}
+	if g, e := got, "s3kr1t"; g != e {
+		t.Errorf("wrong passphrase: %q != %q", g, e)
+	}
+}
+
+func TestPassphraseFileInsecure(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	p := filepath.Join(dir, "secret")
+	if err := os.WriteFile(p, []byte("s3kr1t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// avoid umask
+	if err := os.Chmod(p, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	cfg, vol := parseTestVolume(t, dir, `passphrase_file = "secret"`)
+	_, err := cfg.VolumePassphrase(ctx, vol)
+	if err == nil {
+		t.Fatal("expected an error for group-readable passphrase file")
+	}
+	if !strings.Contains(err.Error(), "must not be accessible by group or others") {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+func TestPassphraseEnv(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("PLOP_TEST_PASSPHRASE", "s3kr1t")
+	cfg, vol := parseTestVolume(t, t.TempDir(), `passphrase_env = "PLOP_TEST_PASSPHRASE"`)
+	got, err := cfg.VolumePassphrase(ctx, vol)
+	if err != nil {
+		t.Fatalf("VolumePassphrase: %v", err)
+	}
+	if g, e := got, "s3kr1t"; g != e {
+		t.Errorf("wrong passphrase: %q != %q", g, e)
+	}
+}
+
+func TestPassphraseCommand(t *testing.T) {
+	ctx := context.Background()
+	cfg, vol := parseTestVolume(t, t.TempDir(), `passphrase_command = "echo s3kr1t"`)
+	got, err := cfg.VolumePassphrase(ctx, vol)
+	if err != nil {
+		t.Fatalf("VolumePassphrase: %v", err)
+	}
+	if g, e := got, "s3kr1t"; g != e {
+		t.Errorf("wrong passphrase: %q != %q", g, e)
+	}
+}
+
+func TestPassphraseNone(t *testing.T) {
+	ctx := context.Background()
+	cfg, vol := parseTestVolume(t, t.TempDir(), ``)
+	_, err := cfg.VolumePassphrase(ctx, vol)
+	if !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("expected ErrNoPassphrase, got %v", err)
+	}
+}
+
+func TestPassphraseConflict(t *testing.T) {
+	src := `
+mountpoint = "/does-not-exist"
+volume "testvolume" {
+  passphrase = "s3kr1t"
+  passphrase_env = "PLOP_TEST_PASSPHRASE"
+  bucket {
+    url = "mem://"
+  }
+}
+`
+	_, err := ParseConfig("<test literal>.hcl", []byte(src))
+	if err == nil {
+		t.Fatal("expected an error for multiple passphrase sources")
+	}
+}
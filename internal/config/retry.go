@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"bazil.org/plop/cas"
+)
+
+// RetryConfig sets how failed requests to a bucket are retried.
+// Without a retry block, requests are tried once.
+type RetryConfig struct {
+	// MaxAttempts is how many times a request is tried, including
+	// the first attempt. Defaults to 5.
+	MaxAttempts *int `hcl:"max_attempts,optional"`
+	// MinBackoff and MaxBackoff bound the wait between attempts, as
+	// duration strings. The wait doubles after every attempt, with
+	// random jitter. Default to "100ms" and "10s".
+	MinBackoff string `hcl:"min_backoff,optional"`
+	MaxBackoff string `hcl:"max_backoff,optional"`
+	// AttemptTimeout limits how long a single attempt may take, as a
+	// duration string. Default is no limit.
+	AttemptTimeout string `hcl:"attempt_timeout,optional"`
+}
+
+func parseDurationField(s string, name string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", name, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s cannot be negative", name)
+	}
+	return d, nil
+}
+
+func parseRetry(r *RetryConfig) (cas.RetryPolicy, error) {
+	if r == nil {
+		return cas.RetryPolicy{}, nil
+	}
+	policy := cas.RetryPolicy{
+		MaxAttempts: 5,
+	}
+	if r.MaxAttempts != nil {
+		if *r.MaxAttempts < 1 {
+			return cas.RetryPolicy{}, fmt.Errorf("max_attempts must be at least 1")
+		}
+		policy.MaxAttempts = *r.MaxAttempts
+	}
+	var err error
+	if policy.MinBackoff, err = parseDurationField(r.MinBackoff, "min_backoff", 100*time.Millisecond); err != nil {
+		return cas.RetryPolicy{}, err
+	}
+	if policy.MaxBackoff, err = parseDurationField(r.MaxBackoff, "max_backoff", 10*time.Second); err != nil {
+		return cas.RetryPolicy{}, err
+	}
+	if policy.MaxBackoff < policy.MinBackoff {
+		return cas.RetryPolicy{}, fmt.Errorf("max_backoff cannot be less than min_backoff")
+	}
+	if policy.AttemptTimeout, err = parseDurationField(r.AttemptTimeout, "attempt_timeout", 0); err != nil {
+		return cas.RetryPolicy{}, err
+	}
+	return policy, nil
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"bazil.org/plop/cas"
+)
+
+const retryTestConfig = `
+mountpoint = "/does-not-exist"
+volume "one" {
+  bucket {
+    url = "mem://"
+    retry {
+      max_attempts = 3
+      min_backoff = "50ms"
+      max_backoff = "2s"
+      attempt_timeout = "30s"
+    }
+  }
+  bucket {
+    url = "mem://"
+    retry {}
+  }
+  bucket {
+    url = "mem://"
+  }
+}
+`
+
+func TestRetryConfig(t *testing.T) {
+	cfg, err := ParseConfig("<test literal>.hcl", []byte(retryTestConfig))
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	vol, _ := cfg.GetVolume("one")
+	for i, want := range []cas.RetryPolicy{
+		{MaxAttempts: 3, MinBackoff: 50 * time.Millisecond, MaxBackoff: 2 * time.Second, AttemptTimeout: 30 * time.Second},
+		{MaxAttempts: 5, MinBackoff: 100 * time.Millisecond, MaxBackoff: 10 * time.Second},
+		{},
+	} {
+		if g := vol.Buckets[i].retry; g != want {
+			t.Errorf("bucket #%d: wrong retry policy: %+v != %+v", i+1, g, want)
+		}
+	}
+}
+
+func TestRetryConfigBad(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		from string
+		to   string
+		want string
+	}{
+		{"attempts", `max_attempts = 3`, `max_attempts = 0`, "max_attempts must be at least 1"},
+		{"backoff", `min_backoff = "50ms"`, `min_backoff = "soon"`, "min_backoff"},
+		{"negative", `attempt_timeout = "30s"`, `attempt_timeout = "-1s"`, "cannot be negative"},
+		{"order", `max_backoff = "2s"`, `max_backoff = "10ms"`, "less than min_backoff"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			src := strings.Replace(retryTestConfig, tc.from, tc.to, 1)
+			_, err := ParseConfig("<test literal>.hcl", []byte(src))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("wrong error: %v", err)
+			}
+		})
+	}
+}
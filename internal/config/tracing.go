@@ -0,0 +1,64 @@
+package config
+
+import (
+	"errors"
+)
+
+// TracingConfig sets where traces of requests are sent. Exactly one
+// of file and otlp_endpoint must be set. Without a tracing block,
+// nothing is recorded.
+type TracingConfig struct {
+	// File is a path where spans are appended as JSON. Relative
+	// paths are interpreted relative to the Plop configuration
+	// directory.
+	File string `hcl:"file,optional"`
+	// OTLPEndpoint is the host and port of an OTLP/HTTP collector,
+	// such as "localhost:4318".
+	OTLPEndpoint string `hcl:"otlp_endpoint,optional"`
+	// OTLPInsecure uses plain HTTP to talk to the collector.
+	OTLPInsecure bool `hcl:"otlp_insecure,optional"`
+	// SampleRatio is the fraction of traces recorded. Defaults to 1.
+	SampleRatio *float64 `hcl:"sample_ratio,optional"`
+}
+
+// TracingOptions is the resolved tracing configuration.
+type TracingOptions struct {
+	// File is an absolute path, or empty.
+	File         string
+	OTLPEndpoint string
+	OTLPInsecure bool
+	SampleRatio  float64
+}
+
+// TracingOptions returns the tracing configuration, or nil if
+// tracing is not configured.
+func (cfg *Config) TracingOptions() *TracingOptions {
+	return cfg.tracing
+}
+
+func parseTracing(cfg *Config) error {
+	t := cfg.Tracing
+	if t == nil {
+		return nil
+	}
+	if (t.File == "") == (t.OTLPEndpoint == "") {
+		return errors.New("config block tracing must set one of file, otlp_endpoint")
+	}
+	opts := &TracingOptions{
+		OTLPEndpoint: t.OTLPEndpoint,
+		OTLPInsecure: t.OTLPInsecure,
+		SampleRatio:  1,
+	}
+	if t.File != "" {
+		opts.File = cfg.resolvePath(t.File)
+	}
+	if t.SampleRatio != nil {
+		r := *t.SampleRatio
+		if r < 0 || r > 1 {
+			return errors.New("config block tracing sample_ratio must be between 0 and 1")
+		}
+		opts.SampleRatio = r
+	}
+	cfg.tracing = opts
+	return nil
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+const tracingTestConfig = `
+mountpoint = "/does-not-exist"
+tracing {
+  file = "traces.json"
+  sample_ratio = 0.25
+}
+volume "one" {
+  bucket {
+    url = "mem://"
+  }
+}
+`
+
+func TestTracingConfig(t *testing.T) {
+	cfg, err := ParseConfig("/etc/plop/config.hcl", []byte(tracingTestConfig))
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	opts := cfg.TracingOptions()
+	if opts == nil {
+		t.Fatal("expected tracing options")
+	}
+	if g, e := opts.File, "/etc/plop/traces.json"; g != e {
+		t.Errorf("wrong file: %q != %q", g, e)
+	}
+	if g, e := opts.SampleRatio, 0.25; g != e {
+		t.Errorf("wrong sample ratio: %v != %v", g, e)
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	cfg, _ := parseTestVolume(t, t.TempDir(), `passphrase = "s3kr1t"`)
+	if opts := cfg.TracingOptions(); opts != nil {
+		t.Errorf("expected no tracing: %+v", opts)
+	}
+}
+
+func TestTracingConfigBad(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		from string
+		to   string
+		want string
+	}{
+		{"none", `file = "traces.json"`, ``, "must set one of file, otlp_endpoint"},
+		{"both", `file = "traces.json"`, `file = "traces.json"
+  otlp_endpoint = "localhost:4318"`, "must set one of file, otlp_endpoint"},
+		{"ratio", `sample_ratio = 0.25`, `sample_ratio = 2`, "sample_ratio must be between 0 and 1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			src := strings.Replace(tracingTestConfig, tc.from, tc.to, 1)
+			_, err := ParseConfig("<test literal>.hcl", []byte(src))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("wrong error: %v", err)
+			}
+		})
+	}
+}
@@ -2,15 +2,24 @@ package config
 
 import (
 	"context"
+	"fmt"
 	"path/filepath"
+	"reflect"
 
 	"bazil.org/plop/cas"
 	aws_credentials "github.com/aws/aws-sdk-go/aws/credentials"
 	aws_session "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"gocloud.dev/blob"
 	"gocloud.dev/blob/s3blob"
 )
 
+var tracer = otel.Tracer("bazil.org/plop/internal/config")
+
 func openBucket(ctx context.Context, cfg *Config, bucketConfig *Bucket) (*blob.Bucket, error) {
 	if bucketConfig.AWS != nil {
 		options := aws_session.Options{}
@@ -42,6 +51,20 @@ func openBucket(ctx context.Context, cfg *Config, bucketConfig *Bucket) (*blob.B
 	return bucket, err
 }
 
+// OpenBucketURL opens the bucket at bucketURL. If a volume has a
+// bucket with that URL, its configuration, such as AWS credentials,
+// is used.
+func OpenBucketURL(ctx context.Context, cfg *Config, bucketURL string) (*blob.Bucket, error) {
+	for _, vol := range cfg.Volumes {
+		for _, bucketConfig := range vol.Buckets {
+			if bucketConfig.URL == bucketURL {
+				return openBucket(ctx, cfg, bucketConfig)
+			}
+		}
+	}
+	return blob.OpenBucket(ctx, bucketURL)
+}
+
 func openBuckets(ctx context.Context, cfg *Config, vol *Volume) ([]*blob.Bucket, error) {
 	var buckets []*blob.Bucket
 	defer func() {
@@ -64,22 +87,96 @@ func openBuckets(ctx context.Context, cfg *Config, vol *Volume) ([]*blob.Bucket,
 	return result, nil
 }
 
+// OpenVolume opens the buckets and the CAS store for the volume.
+//
+// If the volume has no passphrase configured, the error wraps
+// ErrNoPassphrase; use OpenVolumeWithPassphrase to provide one.
 func OpenVolume(ctx context.Context, cfg *Config, vol *Volume) (*cas.Store, []*blob.Bucket, error) {
+	passphrase, err := cfg.VolumePassphrase(ctx, vol)
+	if err != nil {
+		return nil, nil, err
+	}
+	return OpenVolumeWithPassphrase(ctx, cfg, vol, passphrase)
+}
+
+// OpenVolumeWithPassphrase is like OpenVolume, but uses the given
+// passphrase instead of the one configured.
+func OpenVolumeWithPassphrase(ctx context.Context, cfg *Config, vol *Volume, passphrase string) (_ *cas.Store, _ []*blob.Bucket, err error) {
+	ctx, span := tracer.Start(ctx, "config.OpenVolume", trace.WithAttributes(
+		attribute.String("plop.volume", vol.Name),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	var buckets []*blob.Bucket
-	buckets, err := openBuckets(ctx, cfg, vol)
+	buckets, err = openBuckets(ctx, cfg, vol)
 	if err != nil {
 		return nil, nil, err
 	}
-	var opts []cas.Option
+	opts := []cas.Option{
+		// served at /metrics by the daemon and mount
+		cas.WithMetrics(prometheus.DefaultRegisterer),
+	}
 	for i, b := range buckets {
 		bucketConfig := vol.Buckets[i]
 		opts = append(opts, cas.WithBucket(b,
+			cas.BucketName(bucketConfig.URL),
 			cas.BucketAfter(bucketConfig.delay),
 			cas.BucketShardBits(bucketConfig.ShardBits),
+			cas.BucketRetry(bucketConfig.retry),
+			cas.BucketLimits(bucketLimits(bucketConfig)),
 		))
 	}
+	if vol.Prefix != "" {
+		opts = append(opts, cas.WithObjectPrefix(vol.Prefix))
+	}
+	catalog, err := OpenCatalog(cfg, vol)
+	if err != nil {
+		for _, b := range buckets {
+			_ = b.Close()
+		}
+		return nil, nil, err
+	}
+	if catalog != nil {
+		opts = append(opts, cas.WithCatalog(catalog))
+	}
 	opts = append(opts, cfg.Chunker.CASOptions()...)
 	opts = append(opts, vol.Chunker.CASOptions()...)
-	store := cas.NewStore(vol.Passphrase, opts...)
+	// key derivation is slow on purpose
+	_, kdfSpan := tracer.Start(ctx, "cas.NewStore")
+	store := cas.NewStore(passphrase, opts...)
+	kdfSpan.End()
+	// Opening only checks the descriptor. New volumes get one on
+	// their first write, volumes created before descriptors existed
+	// are only readable until initialized.
+	if err := store.PrepareVolume(ctx); err != nil {
+		for _, b := range buckets {
+			_ = b.Close()
+		}
+		return nil, nil, fmt.Errorf("volume %q: %w", vol.Name, err)
+	}
 	return store, buckets, nil
 }
+
+// SameStore reports whether opening volume b of config cfgB would
+// result in the same store as volume a of config cfgA. Settings that
+// do not affect the store, such as how the volume is mounted, are
+// ignored.
+func SameStore(cfgA *Config, a *Volume, cfgB *Config, b *Volume) bool {
+	if filepath.Dir(cfgA.path) != filepath.Dir(cfgB.path) {
+		// relative paths would resolve differently
+		return false
+	}
+	if !reflect.DeepEqual(cfgA.Chunker, cfgB.Chunker) {
+		return false
+	}
+	storeA, storeB := *a, *b
+	storeA.Incoming, storeB.Incoming = false, false
+	storeA.MountPoint, storeB.MountPoint = "", ""
+	return reflect.DeepEqual(storeA, storeB)
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestSameStore(t *testing.T) {
+	dir := t.TempDir()
+	cfgA, a := parseTestVolume(t, dir, `passphrase = "s3kr1t"`)
+	for _, tc := range []struct {
+		name string
+		body string
+		same bool
+	}{
+		{"identical", `passphrase = "s3kr1t"`, true},
+		{"incoming", "passphrase = \"s3kr1t\"\nincoming = true", true},
+		{"mountpoint", "passphrase = \"s3kr1t\"\nmountpoint = \"/srv/test\"", true},
+		{"passphrase", `passphrase = "hunter2"`, false},
+		{"prefix", "passphrase = \"s3kr1t\"\nprefix = \"test/\"", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfgB, b := parseTestVolume(t, dir, tc.body)
+			if g, e := SameStore(cfgA, a, cfgB, b), tc.same; g != e {
+				t.Errorf("SameStore = %v, want %v", g, e)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// Client talks to a daemon over its unix socket.
+type Client struct {
+	http *http.Client
+}
+
+// Dial connects to the daemon listening at path. If no daemon is
+// running, the error is ErrNotRunning.
+func Dial(path string) (*Client, error) {
+	c, err := net.Dial("unix", path)
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+		return nil, ErrNotRunning
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to daemon: %w", err)
+	}
+	_ = c.Close()
+
+	var dialer net.Dialer
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		},
+		// the body is our content, not something to decompress
+		DisableCompression: true,
+	}
+	client := &Client{
+		http: &http.Client{Transport: transport},
+	}
+	return client, nil
+}
+
+// Close releases idle connections.
+func (c *Client) Close() {
+	c.http.CloseIdleConnections()
+}
+
+func volumeURL(volume string, elem ...string) string {
+	u, _ := url.JoinPath("http://plop/volume/", append([]string{volume}, elem...)...)
+	return u
+}
+
+func (c *Client) do(req *http.Request, okStatus ...int) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("daemon request: %w", err)
+	}
+	for _, status := range okStatus {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	return nil, readError(resp)
+}
+
+// OpenVolume opens the volume in the daemon, or checks that it is
+// open.
+func (c *Client) OpenVolume(ctx context.Context, volume string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, volumeURL(volume), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Unlock opens a volume that has no passphrase configured, with the
+// given passphrase. A wrong passphrase is cas.ErrWrongPassphrase.
+func (c *Client) Unlock(ctx context.Context, volume string, passphrase string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, volumeURL(volume, "unlock"), strings.NewReader(passphrase))
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Create stores the content of r in the volume, recording source
+// and labels in the catalog.
+func (c *Client) Create(ctx context.Context, volume string, r io.Reader, source string, labels map[string]string) (string, error) {
+	query := url.Values{}
+	if source != "" {
+		query.Set("source", source)
+	}
+	for k, v := range labels {
+		query.Add("label", k+"="+v)
+	}
+	u := volumeURL(volume, "create") + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, r)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var created createResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("daemon response: %w", err)
+	}
+	return created.Key, nil
+}
+
+// SyncCatalog synchronizes the catalog of the volume, if the volume
+// is configured to do so.
+func (c *Client) SyncCatalog(ctx context.Context, volume string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, volumeURL(volume, "catalog", "sync"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Open looks up an object in the volume.
+func (c *Client) Open(ctx context.Context, volume string, key string) (*Object, error) {
+	u := volumeURL(volume, "object", key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.ContentLength < 0 {
+		return nil, errors.New("daemon response has no size")
+	}
+	obj := &Object{
+		client: c,
+		url:    u,
+		size:   resp.ContentLength,
+	}
+	return obj, nil
+}
+
+// Object is an object opened through the daemon.
+type Object struct {
+	client *Client
+	url    string
+	size   int64
+}
+
+// Size returns the size of the object content.
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// IO returns a reader for the object content. The context is used
+// for all requests made by the reader.
+func (o *Object) IO(ctx context.Context) *Reader {
+	r := &Reader{
+		obj: o,
+		ctx: ctx,
+	}
+	return r
+}
+
+// NewReader streams the whole object content, with one request to
+// the daemon.
+func (o *Object) NewReader(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.client.do(req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Reader reads object content through the daemon.
+type Reader struct {
+	obj *Object
+	ctx context.Context
+}
+
+var _ io.ReaderAt = (*Reader)(nil)
+
+// ReadAt reads len(p) bytes at offset off, with one request to the
+// daemon. Callers should use large buffers.
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.obj.size {
+		return 0, io.EOF
+	}
+	want := int64(len(p))
+	if remaining := r.obj.size - off; want > remaining {
+		want = remaining
+	}
+	if want == 0 {
+		return 0, nil
+	}
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.obj.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+want-1))
+	resp, err := r.obj.client.do(req, http.StatusPartialContent)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	n, err := io.ReadFull(resp.Body, p[:want])
+	if err != nil {
+		return n, fmt.Errorf("daemon response: %w", err)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteMetrics copies the metrics of the daemon, in Prometheus text
+// format, to w.
+func (c *Client) WriteMetrics(ctx context.Context, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://plop/metrics", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("daemon response: %w", err)
+	}
+	return nil
+}
+
+// Status returns the state of the volumes served by the daemon.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://plop/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("daemon response: %w", err)
+	}
+	return &status, nil
+}
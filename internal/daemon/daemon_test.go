@@ -0,0 +1,221 @@
+package daemon_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bazil.org/plop/cas"
+	"bazil.org/plop/internal/config"
+	"bazil.org/plop/internal/daemon"
+	_ "gocloud.dev/blob/memblob"
+)
+
+const testConfig = `
+mountpoint = "/does-not-exist"
+volume "testvolume" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = "mem://"
+  }
+}
+volume "locked" {
+  bucket {
+    url = "mem://"
+  }
+}
+`
+
+func startDaemon(t testing.TB) (*daemon.Client, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg, err := config.ParseConfig(filepath.Join(dir, "config.hcl"), []byte(testConfig))
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	socket := filepath.Join(dir, "run", "control.sock")
+	l, err := daemon.Listen(socket)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	volumes := daemon.NewLocal(cfg)
+	srv := daemon.NewServer(volumes)
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(l)
+	}()
+	t.Cleanup(func() {
+		_ = l.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+		if err := volumes.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	})
+	client, err := daemon.Dial(socket)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(client.Close)
+	return client, socket
+}
+
+func TestRoundtrip(t *testing.T) {
+	client, _ := startDaemon(t)
+	ctx := context.Background()
+	if err := client.OpenVolume(ctx, "testvolume"); err != nil {
+		t.Fatalf("OpenVolume: %v", err)
+	}
+	const greeting = "hello, world\n"
+	key, err := client.Create(ctx, "testvolume", strings.NewReader(greeting), "/tmp/greeting", map[string]string{"lang": "en"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	obj, err := client.Open(ctx, "testvolume", key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if g, e := obj.Size(), int64(len(greeting)); g != e {
+		t.Errorf("wrong size: %d != %d", g, e)
+	}
+	buf, err := io.ReadAll(io.NewSectionReader(obj.IO(ctx), 0, obj.Size()))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if g, e := string(buf), greeting; g != e {
+		t.Errorf("wrong content: %q != %q", g, e)
+	}
+
+	r, err := obj.NewReader(ctx)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	defer r.Close()
+	buf, err = io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read stream: %v", err)
+	}
+	if g, e := string(buf), greeting; g != e {
+		t.Errorf("wrong streamed content: %q != %q", g, e)
+	}
+
+	part := make([]byte, 100)
+	n, err := obj.IO(ctx).ReadAt(part, 7)
+	if err != io.EOF {
+		t.Errorf("expected EOF: %v", err)
+	}
+	if g, e := string(part[:n]), "world\n"; g != e {
+		t.Errorf("wrong partial content: %q != %q", g, e)
+	}
+	if err := client.SyncCatalog(ctx, "testvolume"); err != nil {
+		t.Errorf("SyncCatalog: %v", err)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	client, _ := startDaemon(t)
+	ctx := context.Background()
+	if _, err := client.Create(ctx, "testvolume", strings.NewReader("hello, world\n"), "", nil); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	var buf strings.Builder
+	if err := client.WriteMetrics(ctx, &buf); err != nil {
+		t.Fatalf("WriteMetrics: %v", err)
+	}
+	if !strings.Contains(buf.String(), `plop_bucket_requests_total{bucket="mem://",code="OK",op="write"} `) {
+		t.Errorf("expected bucket metrics:\n%s", buf.String())
+	}
+}
+
+func TestStatus(t *testing.T) {
+	client, _ := startDaemon(t)
+	ctx := context.Background()
+	if _, err := client.Create(ctx, "testvolume", strings.NewReader("hello, world\n"), "", nil); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	status, err := client.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if g, e := len(status.Volumes), 2; g != e {
+		t.Fatalf("wrong number of volumes: %d != %d", g, e)
+	}
+	locked, vol := status.Volumes[0], status.Volumes[1]
+	if locked.Name != "locked" || locked.Open {
+		t.Errorf("wrong status for locked volume: %+v", locked)
+	}
+	if vol.Name != "testvolume" || !vol.Open {
+		t.Fatalf("wrong status for volume: %+v", vol)
+	}
+	if g, e := len(vol.Buckets), 1; g != e {
+		t.Fatalf("wrong number of buckets: %d != %d", g, e)
+	}
+	if g, e := vol.Buckets[0], (daemon.BucketStatus{Name: "mem://", State: "healthy"}); g != e {
+		t.Errorf("wrong bucket status: %+v != %+v", g, e)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	client, socket := startDaemon(t)
+	ctx := context.Background()
+	const missing = "s4wfu6c18bh6ahfjgirpsqp7zmr6pg18d9rho7rrgzpkqonsz8jy"
+	if _, err := client.Open(ctx, "testvolume", missing); !errors.Is(err, cas.ErrNotExist) {
+		t.Errorf("expected not exist error: %v", err)
+	}
+	if _, err := client.Open(ctx, "testvolume", "bad"); !errors.Is(err, cas.ErrBadKey) {
+		t.Errorf("expected bad key error: %v", err)
+	}
+	if err := client.OpenVolume(ctx, "locked"); !errors.Is(err, daemon.ErrLocked) {
+		t.Errorf("expected locked error: %v", err)
+	}
+	if err := client.OpenVolume(ctx, "nope"); !errors.Is(err, daemon.ErrNoVolume) {
+		t.Errorf("expected no volume error: %v", err)
+	}
+	if _, err := daemon.Listen(socket); !errors.Is(err, daemon.ErrRunning) {
+		t.Errorf("expected already running error: %v", err)
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	client, _ := startDaemon(t)
+	ctx := context.Background()
+	if err := client.OpenVolume(ctx, "locked"); !errors.Is(err, daemon.ErrLocked) {
+		t.Fatalf("expected locked error: %v", err)
+	}
+	if err := client.Unlock(ctx, "locked", "s3kr1t"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if err := client.OpenVolume(ctx, "locked"); err != nil {
+		t.Errorf("OpenVolume after unlock: %v", err)
+	}
+	if err := client.Unlock(ctx, "nope", "s3kr1t"); !errors.Is(err, daemon.ErrNoVolume) {
+		t.Errorf("expected no volume error: %v", err)
+	}
+}
+
+func TestNotRunning(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "control.sock")
+	if _, err := daemon.Dial(socket); !errors.Is(err, daemon.ErrNotRunning) {
+		t.Errorf("expected not running error: %v", err)
+	}
+}
+
+func TestStaleSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "control.sock")
+	l, err := daemon.Listen(socket)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	// leave the socket file behind, as a crash would
+	l.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+	_ = l.Close()
+	l, err = daemon.Listen(socket)
+	if err != nil {
+		t.Fatalf("Listen over stale socket: %v", err)
+	}
+	_ = l.Close()
+}
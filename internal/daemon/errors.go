@@ -0,0 +1,108 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"bazil.org/plop/cas"
+)
+
+var (
+	// ErrNotRunning is returned by Dial when no daemon is listening.
+	ErrNotRunning = errors.New("daemon is not running")
+	// ErrRunning is returned by Listen when another daemon is
+	// already listening.
+	ErrRunning = errors.New("daemon is already running")
+	// ErrLocked is returned for volumes that have no passphrase
+	// configured, until unlocked, as the daemon cannot prompt for
+	// one.
+	ErrLocked = errors.New("volume is locked in the daemon")
+	// ErrNoVolume is returned for volumes not in the daemon
+	// configuration.
+	ErrNoVolume = errors.New("no such volume in the daemon")
+
+	errNoPassphrase = errors.New("empty passphrase")
+	errNotFound     = errors.New("not found")
+	errMethod       = errors.New("method not allowed")
+)
+
+// errorCodeHeader carries the error code, so that it is available
+// for HEAD requests too.
+const errorCodeHeader = "Plop-Error"
+
+// errorCodes maps errors that clients may want to handle to codes
+// sent over the wire.
+var errorCodes = []struct {
+	code   string
+	err    error
+	status int
+}{
+	{"not_exist", cas.ErrNotExist, http.StatusNotFound},
+	{"bad_key", cas.ErrBadKey, http.StatusBadRequest},
+	{"corrupt", cas.ErrCorruptBlob, http.StatusInternalServerError},
+	{"wrong_passphrase", cas.ErrWrongPassphrase, http.StatusForbidden},
+	{"locked", ErrLocked, http.StatusLocked},
+	{"no_volume", ErrNoVolume, http.StatusNotFound},
+	{"", errNoPassphrase, http.StatusBadRequest},
+	{"", errNotFound, http.StatusNotFound},
+	{"", errMethod, http.StatusMethodNotAllowed},
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	for _, ec := range errorCodes {
+		if errors.Is(err, ec.err) {
+			if ec.code != "" {
+				w.Header().Set(errorCodeHeader, ec.code)
+			}
+			status = ec.status
+			break
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+}
+
+// RemoteError is an error reported by the daemon. It matches the
+// corresponding local error with errors.Is, where there is one.
+type RemoteError struct {
+	Message string
+	err     error
+}
+
+var _ error = (*RemoteError)(nil)
+
+func (e *RemoteError) Error() string {
+	return "daemon: " + e.Message
+}
+
+func (e *RemoteError) Unwrap() error {
+	return e.err
+}
+
+func readError(resp *http.Response) error {
+	remote := &RemoteError{
+		Message: resp.Status,
+	}
+	if code := resp.Header.Get(errorCodeHeader); code != "" {
+		for _, ec := range errorCodes {
+			if ec.code == code {
+				// HEAD responses have no body
+				remote.Message = ec.err.Error()
+				remote.err = ec.err
+				break
+			}
+		}
+	}
+	var body errorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err == nil && body.Error != "" {
+		remote.Message = body.Error
+	}
+	return remote
+}
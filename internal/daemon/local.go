@@ -0,0 +1,283 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"bazil.org/plop/cas"
+	"bazil.org/plop/internal/config"
+	"bazil.org/plop/internal/multierr"
+	"gocloud.dev/blob"
+)
+
+// Local holds the volumes of a standalone daemon. Volumes are opened
+// on first use, and kept open.
+type Local struct {
+	// updateMu serializes Update.
+	updateMu sync.Mutex
+
+	mu      sync.Mutex
+	cfg     *config.Config
+	volumes map[string]*volume
+	// retired holds buckets of volumes replaced by Update. Requests
+	// in flight may still use them, so they are only closed by
+	// Close.
+	retired []*blob.Bucket
+}
+
+var _ Volumes = (*Local)(nil)
+
+type volume struct {
+	mu     sync.Mutex
+	cfg    *config.Config
+	config *config.Volume
+	// store is nil until opened.
+	store   *cas.Store
+	buckets []*blob.Bucket
+	// opening is closed when the open in progress finishes, and nil
+	// if none is. Opening talks to the buckets and derives keys, so
+	// it is done without holding mu.
+	opening chan struct{}
+	// retired is set when the volume is no longer served.
+	retired bool
+}
+
+// NewLocal returns the volumes in cfg.
+func NewLocal(cfg *config.Config) *Local {
+	l := &Local{
+		volumes: make(map[string]*volume),
+	}
+	l.Update(cfg)
+	return l
+}
+
+// Update changes the volumes to match cfg. Volumes whose storage
+// settings did not change stay open.
+func (l *Local) Update(cfg *config.Config) {
+	l.updateMu.Lock()
+	defer l.updateMu.Unlock()
+
+	l.mu.Lock()
+	oldCfg := l.cfg
+	old := l.volumes
+	l.mu.Unlock()
+
+	// Compare volumes without holding the lock, which every request
+	// takes.
+	volumes := make(map[string]*volume, len(cfg.Volumes))
+	for _, vol := range cfg.Volumes {
+		if v, ok := old[vol.Name]; ok && v.keep(oldCfg, cfg, vol) {
+			volumes[vol.Name] = v
+			continue
+		}
+		volumes[vol.Name] = &volume{
+			cfg:    cfg,
+			config: vol,
+		}
+	}
+	l.mu.Lock()
+	l.cfg = cfg
+	l.volumes = volumes
+	l.mu.Unlock()
+
+	var retired []*blob.Bucket
+	for name, v := range old {
+		if volumes[name] == v {
+			continue
+		}
+		retired = append(retired, v.retire()...)
+	}
+	l.mu.Lock()
+	l.retired = append(l.retired, retired...)
+	l.mu.Unlock()
+}
+
+// keep updates the volume to a new configuration, if that does not
+// change its storage. It reports whether the volume was kept.
+func (v *volume) keep(oldCfg *config.Config, cfg *config.Config, vol *config.Volume) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !config.SameStore(oldCfg, v.config, cfg, vol) {
+		return false
+	}
+	v.cfg = cfg
+	v.config = vol
+	return true
+}
+
+// retire marks the volume as no longer served, and returns the
+// buckets it had open. An open in progress closes its buckets when
+// done.
+func (v *volume) retire() []*blob.Bucket {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.retired = true
+	buckets := v.buckets
+	v.buckets = nil
+	return buckets
+}
+
+// open opens the volume if needed. Volumes with no passphrase
+// configured use runtime, if set. Failures are not remembered, so the
+// next request tries again. Concurrent calls wait for one open.
+func (v *volume) open(ctx context.Context, runtime string) (*cas.Store, error) {
+	for {
+		v.mu.Lock()
+		if v.retired {
+			v.mu.Unlock()
+			return nil, ErrNoVolume
+		}
+		if store := v.store; store != nil {
+			v.mu.Unlock()
+			return store, nil
+		}
+		if wait := v.opening; wait != nil {
+			v.mu.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		done := make(chan struct{})
+		v.opening = done
+		cfg, vol := v.cfg, v.config
+		v.mu.Unlock()
+
+		store, buckets, err := openLocal(ctx, cfg, vol, runtime)
+
+		v.mu.Lock()
+		v.opening = nil
+		close(done)
+		if err != nil {
+			v.mu.Unlock()
+			return nil, err
+		}
+		if v.retired {
+			v.mu.Unlock()
+			for _, b := range buckets {
+				_ = b.Close()
+			}
+			return nil, ErrNoVolume
+		}
+		v.store = store
+		v.buckets = buckets
+		v.mu.Unlock()
+		return store, nil
+	}
+}
+
+// openLocal opens a volume with its configured passphrase, or
+// runtime if it has none.
+func openLocal(ctx context.Context, cfg *config.Config, vol *config.Volume, runtime string) (*cas.Store, []*blob.Bucket, error) {
+	passphrase, err := cfg.VolumePassphrase(ctx, vol)
+	if errors.Is(err, config.ErrNoPassphrase) {
+		if runtime == "" {
+			// the daemon cannot prompt
+			return nil, nil, ErrLocked
+		}
+		passphrase, err = runtime, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return config.OpenVolumeWithPassphrase(ctx, cfg, vol, passphrase)
+}
+
+// Close closes all buckets. The volumes must not be used after
+// Close.
+func (l *Local) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var errs []error
+	for name, v := range l.volumes {
+		for i, b := range v.retire() {
+			if err := b.Close(); err != nil {
+				err = fmt.Errorf("error closing bucket #%d for %q: %w", i, name, err)
+				errs = append(errs, err)
+			}
+		}
+	}
+	for _, b := range l.retired {
+		if err := b.Close(); err != nil {
+			err = fmt.Errorf("error closing retired bucket: %w", err)
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return multierr.New(errs)
+	}
+	return nil
+}
+
+func (l *Local) volume(name string) (*volume, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	v, ok := l.volumes[name]
+	if !ok {
+		return nil, ErrNoVolume
+	}
+	return v, nil
+}
+
+func (l *Local) OpenStore(ctx context.Context, name string) (*cas.Store, error) {
+	v, err := l.volume(name)
+	if err != nil {
+		return nil, err
+	}
+	return v.open(ctx, "")
+}
+
+func (l *Local) Unlock(ctx context.Context, name string, passphrase string) error {
+	v, err := l.volume(name)
+	if err != nil {
+		return err
+	}
+	_, err = v.open(ctx, passphrase)
+	return err
+}
+
+func (l *Local) SyncCatalog(ctx context.Context, name string) error {
+	v, err := l.volume(name)
+	if err != nil {
+		return err
+	}
+	store, err := v.open(ctx, "")
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	cfg, vol := v.cfg, v.config
+	v.mu.Unlock()
+	if vol.Catalog == nil || !vol.Catalog.Sync {
+		return nil
+	}
+	catalog, err := config.OpenCatalog(cfg, vol)
+	if err != nil {
+		return err
+	}
+	if err := catalog.Sync(ctx, store); err != nil {
+		return fmt.Errorf("cannot sync catalog: %w", err)
+	}
+	return nil
+}
+
+func (l *Local) Stores() map[string]*cas.Store {
+	l.mu.Lock()
+	volumes := make(map[string]*volume, len(l.volumes))
+	for name, v := range l.volumes {
+		volumes[name] = v
+	}
+	l.mu.Unlock()
+
+	stores := make(map[string]*cas.Store, len(volumes))
+	for name, v := range volumes {
+		v.mu.Lock()
+		stores[name] = v.store
+		v.mu.Unlock()
+	}
+	return stores
+}
@@ -0,0 +1,97 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/plop/internal/config"
+	_ "gocloud.dev/blob/fileblob"
+)
+
+func TestLocalUpdateDuringSlowOpen(t *testing.T) {
+	tmp := t.TempDir()
+	fifo := filepath.Join(tmp, "passphrase")
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	text := fmt.Sprintf(`mountpoint = "/does-not-exist"
+volume "one" {
+  passphrase_command = "cat %s"
+  bucket {
+    url = "file://%s"
+  }
+}
+volume "two" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = "mem://"
+  }
+}
+`, fifo, tmp)
+	parse := func() *config.Config {
+		t.Helper()
+		cfg, err := config.ParseConfig("<test literal>.hcl", []byte(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+	l := NewLocal(parse())
+	defer func() {
+		if err := l.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	v, err := l.volume("one")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	opened := make(chan error, 1)
+	go func() {
+		_, err := l.OpenStore(ctx, "one")
+		opened <- err
+	}()
+	// the passphrase command blocks until the fifo is written
+	for {
+		v.mu.Lock()
+		opening := v.opening != nil
+		v.mu.Unlock()
+		if opening {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		l.Update(parse())
+		_ = l.Stores()
+		_, err := l.OpenStore(ctx, "two")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("OpenStore: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("blocked by open in progress")
+	}
+
+	if err := os.WriteFile(fifo, []byte("s3kr1t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-opened; err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if again, err := l.volume("one"); err != nil || again != v {
+		t.Error("volume was not kept")
+	}
+}
@@ -0,0 +1,216 @@
+// Package daemon lets command-line invocations share one long-running
+// process that holds opened volumes, with their derived keys, bucket
+// connections and caches. That process is either a standalone daemon,
+// or a mount serving the volumes of its filesystem.
+//
+// The daemon serves HTTP on a unix socket:
+//
+//	GET  /volume/NAME                 open the volume
+//	POST /volume/NAME/unlock          open the volume with the passphrase in the request body
+//	POST /volume/NAME/create          store the request body
+//	POST /volume/NAME/catalog/sync    synchronize the catalog
+//	HEAD /volume/NAME/object/KEY      object size
+//	GET  /volume/NAME/object/KEY      object content, with Range support
+//	GET  /metrics                     metrics, in Prometheus text format
+//	GET  /status                      health of open volumes, see Status
+//
+// Errors are returned as JSON, see errorResponse.
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"bazil.org/plop/cas"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Volumes holds the volumes a Server serves. A standalone daemon
+// uses Local; a mount serves the volumes it has open.
+type Volumes interface {
+	// OpenStore returns the store of the named volume, opening it
+	// if needed. Unknown volumes are ErrNoVolume, and volumes whose
+	// passphrase is not known are ErrLocked.
+	OpenStore(ctx context.Context, name string) (*cas.Store, error)
+	// Unlock opens a volume that has no passphrase configured,
+	// with the given passphrase. A wrong passphrase is
+	// cas.ErrWrongPassphrase.
+	Unlock(ctx context.Context, name string, passphrase string) error
+	// SyncCatalog synchronizes the catalog of the named volume with
+	// the copy stored in it, if the volume is configured to do so.
+	SyncCatalog(ctx context.Context, name string) error
+	// Stores returns the store of each served volume, nil for
+	// volumes not open yet.
+	Stores() map[string]*cas.Store
+}
+
+// Server serves volumes to clients.
+type Server struct {
+	volumes Volumes
+}
+
+// NewServer returns a server for volumes.
+func NewServer(volumes Volumes) *Server {
+	s := &Server{
+		volumes: volumes,
+	}
+	return s
+}
+
+// Serve accepts connections on l until it is closed. Connections from
+// other users are refused.
+func (s *Server) Serve(l net.Listener) error {
+	srv := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	err := srv.Serve(&peerListener{Listener: l})
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+var _ http.Handler = (*Server)(nil)
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/metrics" {
+		if req.Method != http.MethodGet {
+			writeError(w, errMethod)
+			return
+		}
+		promhttp.Handler().ServeHTTP(w, req)
+		return
+	}
+	if req.URL.Path == "/status" {
+		if req.Method != http.MethodGet {
+			writeError(w, errMethod)
+			return
+		}
+		s.serveStatus(w, req)
+		return
+	}
+	rest, ok := strings.CutPrefix(req.URL.Path, "/volume/")
+	if !ok {
+		writeError(w, errNotFound)
+		return
+	}
+	name, rest, _ := strings.Cut(rest, "/")
+	if rest == "unlock" {
+		if req.Method != http.MethodPost {
+			writeError(w, errMethod)
+			return
+		}
+		s.serveUnlock(w, req, name)
+		return
+	}
+	store, err := s.volumes.OpenStore(req.Context(), name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	switch {
+	case rest == "":
+		if req.Method != http.MethodGet {
+			writeError(w, errMethod)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case rest == "create":
+		if req.Method != http.MethodPost {
+			writeError(w, errMethod)
+			return
+		}
+		s.serveCreate(w, req, store)
+
+	case rest == "catalog/sync":
+		if req.Method != http.MethodPost {
+			writeError(w, errMethod)
+			return
+		}
+		if err := s.volumes.SyncCatalog(req.Context(), name); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case strings.HasPrefix(rest, "object/"):
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			writeError(w, errMethod)
+			return
+		}
+		key := strings.TrimPrefix(rest, "object/")
+		s.serveObject(w, req, store, key)
+
+	default:
+		writeError(w, errNotFound)
+	}
+}
+
+// maxPassphraseSize limits the request body of unlock.
+const maxPassphraseSize = 4096
+
+func (s *Server) serveUnlock(w http.ResponseWriter, req *http.Request, name string) {
+	buf, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxPassphraseSize))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(buf) == 0 {
+		writeError(w, errNoPassphrase)
+		return
+	}
+	if err := s.volumes.Unlock(req.Context(), name, string(buf)); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type createResponse struct {
+	Key string `json:"key"`
+}
+
+func (s *Server) serveCreate(w http.ResponseWriter, req *http.Request, store *cas.Store) {
+	query := req.URL.Query()
+	var opts []cas.CreateOption
+	if source := query.Get("source"); source != "" {
+		opts = append(opts, cas.CreateSource(source))
+	}
+	if labelList := query["label"]; len(labelList) > 0 {
+		labels := make(map[string]string, len(labelList))
+		for _, label := range labelList {
+			k, v, _ := strings.Cut(label, "=")
+			labels[k] = v
+		}
+		opts = append(opts, cas.CreateLabels(labels))
+	}
+	key, err := store.Create(req.Context(), req.Body, opts...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(createResponse{Key: key})
+}
+
+func (s *Server) serveObject(w http.ResponseWriter, req *http.Request, store *cas.Store, key string) {
+	ctx := req.Context()
+	h, err := store.Open(ctx, key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// keep ServeContent from sniffing
+	w.Header().Set("Content-Type", "application/octet-stream")
+	r := io.NewSectionReader(h.IO(ctx), 0, h.Size())
+	http.ServeContent(w, req, "", time.Time{}, r)
+}
@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Status is the state of the volumes served by the daemon.
+type Status struct {
+	Volumes []VolumeStatus `json:"volumes"`
+}
+
+// VolumeStatus is the state of one volume.
+type VolumeStatus struct {
+	Name string `json:"name"`
+	// Open is false for volumes no client has used yet.
+	Open    bool           `json:"open"`
+	Buckets []BucketStatus `json:"buckets,omitempty"`
+}
+
+// BucketStatus is the health of one bucket of a volume. See
+// cas.BucketHealth.
+type BucketStatus struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+	// Since is when the bucket entered its current state, or nil
+	// if it has always been healthy.
+	Since     *time.Time `json:"since,omitempty"`
+	Failures  int        `json:"failures,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+func (s *Server) status() *Status {
+	status := &Status{
+		Volumes: []VolumeStatus{},
+	}
+	for name, store := range s.volumes.Stores() {
+		vs := VolumeStatus{
+			Name: name,
+			Open: store != nil,
+		}
+		if store != nil {
+			for _, h := range store.BucketHealth() {
+				bs := BucketStatus{
+					Name:     h.Name,
+					State:    h.State.String(),
+					Failures: h.Failures,
+				}
+				if !h.Since.IsZero() {
+					since := h.Since
+					bs.Since = &since
+				}
+				if h.LastError != nil {
+					bs.LastError = h.LastError.Error()
+				}
+				vs.Buckets = append(vs.Buckets, bs)
+			}
+		}
+		status.Volumes = append(status.Volumes, vs)
+	}
+	sort.Slice(status.Volumes, func(i, j int) bool {
+		return status.Volumes[i].Name < status.Volumes[j].Name
+	})
+	return status
+}
+
+func (s *Server) serveStatus(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.status())
+}
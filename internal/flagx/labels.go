@@ -0,0 +1,34 @@
+package flagx
+
+import (
+	"errors"
+	"flag"
+	"sort"
+	"strings"
+)
+
+// Labels collects repeated KEY=VALUE arguments.
+type Labels map[string]string
+
+var _ flag.Value = (*Labels)(nil)
+
+func (l *Labels) String() string {
+	var list []string
+	for k, v := range *l {
+		list = append(list, k+"="+v)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+func (l *Labels) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return errors.New("label must be in the form KEY=VALUE")
+	}
+	if *l == nil {
+		*l = make(Labels)
+	}
+	(*l)[k] = v
+	return nil
+}
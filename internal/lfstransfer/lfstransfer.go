@@ -0,0 +1,337 @@
+// Package lfstransfer implements a Git LFS custom transfer agent,
+// storing LFS objects in a plop volume.
+//
+// LFS object IDs are mapped to plop keys with refs named "lfs:OID".
+// Like all refs, the mapping is encrypted and stored in the volume.
+// Objects already in the volume are not uploaded again.
+//
+// See https://github.com/git-lfs/git-lfs/blob/main/docs/custom-transfers.md
+package lfstransfer
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"bazil.org/plop/cas"
+)
+
+const refPrefix = "lfs:"
+
+// OpenFunc opens the volume to use.
+type OpenFunc func(ctx context.Context) (*cas.Store, error)
+
+// Agent is one session of the protocol, with git-lfs on the other
+// end.
+type Agent struct {
+	open    OpenFunc
+	tempDir string
+	dec     *json.Decoder
+	w       *bufio.Writer
+	store   *cas.Store
+}
+
+// New returns an agent that reads requests from r, and writes
+// replies to w. The volume is opened with open when git-lfs starts
+// the session. Downloaded objects are written to files in tempDir,
+// or the default directory for temporary files if empty.
+func New(r io.Reader, w io.Writer, open OpenFunc, tempDir string) *Agent {
+	agent := &Agent{
+		open:    open,
+		tempDir: tempDir,
+		dec:     json.NewDecoder(r),
+		w:       bufio.NewWriter(w),
+	}
+	return agent
+}
+
+// Error codes sent to git-lfs. They are not interpreted by git-lfs,
+// beyond being shown to the user.
+const (
+	codeInit     = 32
+	codeTransfer = 2
+	codeNotFound = 404
+)
+
+type transferError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type request struct {
+	Event     string `json:"event"`
+	Operation string `json:"operation"`
+	OID       string `json:"oid"`
+	Size      int64  `json:"size"`
+	Path      string `json:"path"`
+}
+
+type initResponse struct {
+	Error *transferError `json:"error,omitempty"`
+}
+
+type progressResponse struct {
+	Event          string `json:"event"`
+	OID            string `json:"oid"`
+	BytesSoFar     int64  `json:"bytesSoFar"`
+	BytesSinceLast int64  `json:"bytesSinceLast"`
+}
+
+type completeResponse struct {
+	Event string         `json:"event"`
+	OID   string         `json:"oid"`
+	Path  string         `json:"path,omitempty"`
+	Error *transferError `json:"error,omitempty"`
+}
+
+func (a *Agent) send(msg interface{}) error {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	if _, err := a.w.Write(buf); err != nil {
+		return fmt.Errorf("lfs transfer: %w", err)
+	}
+	if err := a.w.Flush(); err != nil {
+		return fmt.Errorf("lfs transfer: %w", err)
+	}
+	return nil
+}
+
+// Serve handles requests until git-lfs asks the agent to terminate,
+// or closes the connection.
+func (a *Agent) Serve(ctx context.Context) error {
+	for {
+		var req request
+		if err := a.dec.Decode(&req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("lfs transfer: bad request: %w", err)
+		}
+		switch req.Event {
+		case "init":
+			if err := a.handleInit(ctx, &req); err != nil {
+				return err
+			}
+		case "upload", "download":
+			if a.store == nil {
+				return fmt.Errorf("lfs transfer: %s before init", req.Event)
+			}
+			if err := a.handleTransfer(ctx, &req); err != nil {
+				return err
+			}
+		case "terminate":
+			return nil
+		default:
+			return fmt.Errorf("lfs transfer: unknown event: %q", req.Event)
+		}
+	}
+}
+
+func (a *Agent) handleInit(ctx context.Context, req *request) error {
+	if req.Operation != "upload" && req.Operation != "download" {
+		resp := initResponse{
+			Error: &transferError{Code: codeInit, Message: fmt.Sprintf("unknown operation: %q", req.Operation)},
+		}
+		return a.send(resp)
+	}
+	if a.store == nil {
+		store, err := a.open(ctx)
+		if err != nil {
+			resp := initResponse{
+				Error: &transferError{Code: codeInit, Message: err.Error()},
+			}
+			return a.send(resp)
+		}
+		a.store = store
+	}
+	return a.send(initResponse{})
+}
+
+func (a *Agent) handleTransfer(ctx context.Context, req *request) error {
+	resp := completeResponse{
+		Event: "complete",
+		OID:   req.OID,
+	}
+	var err error
+	if !isOID(req.OID) {
+		err = fmt.Errorf("bad object id: %q", req.OID)
+	} else if req.Event == "upload" {
+		err = a.upload(ctx, req)
+	} else {
+		resp.Path, err = a.download(ctx, req)
+	}
+	if err != nil {
+		code := codeTransfer
+		if errors.Is(err, cas.ErrRefNotExist) {
+			code = codeNotFound
+		}
+		resp.Error = &transferError{Code: code, Message: err.Error()}
+	}
+	return a.send(resp)
+}
+
+// isOID reports whether oid is a SHA-256 object ID, as used by Git
+// LFS.
+func isOID(oid string) bool {
+	if len(oid) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(oid)
+	return err == nil && strings.ToLower(oid) == oid
+}
+
+// lookup returns the plop key for the object.
+func (a *Agent) lookup(ctx context.Context, oid string) (*cas.Ref, error) {
+	ref, err := a.store.GetRef(ctx, refPrefix+oid)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Deleted() {
+		return nil, cas.ErrRefNotExist
+	}
+	return ref, nil
+}
+
+// progress reports progress of a transfer to git-lfs.
+type progress struct {
+	agent *Agent
+	oid   string
+	last  int64
+	// err is set if sending progress failed.
+	err error
+}
+
+func (p *progress) report(soFar int64) {
+	if p.err != nil || soFar == p.last {
+		return
+	}
+	resp := progressResponse{
+		Event:          "progress",
+		OID:            p.oid,
+		BytesSoFar:     soFar,
+		BytesSinceLast: soFar - p.last,
+	}
+	p.last = soFar
+	p.err = p.agent.send(resp)
+}
+
+func (a *Agent) upload(ctx context.Context, req *request) error {
+	ref, err := a.lookup(ctx, req.OID)
+	switch {
+	case errors.Is(err, cas.ErrRefNotExist):
+	case err != nil:
+		return err
+	default:
+		// already uploaded, unless the content went missing
+		if _, err := a.store.Open(ctx, ref.Key); err == nil {
+			return nil
+		}
+	}
+
+	f, err := os.Open(req.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	p := &progress{agent: a, oid: req.OID}
+	key, err := a.store.Create(ctx, io.TeeReader(f, hash), cas.CreateProgress(p.report))
+	if err != nil {
+		return err
+	}
+	if p.err != nil {
+		return p.err
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != req.OID {
+		return fmt.Errorf("content does not match object id: %s", got)
+	}
+	return a.setRef(ctx, refPrefix+req.OID, key)
+}
+
+// setRef points the named ref at key, whatever its current state.
+func (a *Agent) setRef(ctx context.Context, name string, key string) error {
+	for {
+		var prevSeq uint64
+		ref, err := a.store.GetRef(ctx, name)
+		switch {
+		case errors.Is(err, cas.ErrRefNotExist):
+		case err != nil:
+			return err
+		default:
+			prevSeq = ref.Seq
+		}
+		_, err = a.store.SetRef(ctx, name, key, prevSeq)
+		if errors.Is(err, cas.ErrRefConflict) {
+			continue
+		}
+		return err
+	}
+}
+
+// download writes the object to a temporary file, and returns its
+// path. git-lfs moves the file in place.
+func (a *Agent) download(ctx context.Context, req *request) (string, error) {
+	ref, err := a.lookup(ctx, req.OID)
+	if err != nil {
+		return "", err
+	}
+	h, err := a.store.Open(ctx, ref.Key)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(a.tempDir, "plop-lfs-")
+	if err != nil {
+		return "", err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	hash := sha256.New()
+	p := &progress{agent: a, oid: req.OID}
+	r := io.NewSectionReader(h.IO(ctx), 0, h.Size())
+	buf := make([]byte, 1<<20)
+	var n int64
+	for {
+		m, err := r.Read(buf)
+		if m > 0 {
+			if _, err := f.Write(buf[:m]); err != nil {
+				return "", err
+			}
+			_, _ = hash.Write(buf[:m])
+			n += int64(m)
+			p.report(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if p.err != nil {
+		return "", p.err
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != req.OID {
+		return "", fmt.Errorf("stored content does not match object id: %s", got)
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	ok = true
+	return f.Name(), nil
+}
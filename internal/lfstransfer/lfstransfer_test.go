@@ -0,0 +1,151 @@
+package lfstransfer_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bazil.org/plop/cas"
+	"bazil.org/plop/internal/lfstransfer"
+	"gocloud.dev/blob/memblob"
+)
+
+func oid(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// run feeds the requests to an agent, and returns the responses,
+// with download paths replaced by "TEMP".
+func run(t testing.TB, store *cas.Store, tempDir string, input string) (string, []string) {
+	t.Helper()
+	ctx := context.Background()
+	open := func(ctx context.Context) (*cas.Store, error) {
+		return store, nil
+	}
+	var out bytes.Buffer
+	agent := lfstransfer.New(strings.NewReader(input), &out, open, tempDir)
+	if err := agent.Serve(ctx); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	var lines []string
+	var paths []string
+	for _, line := range strings.SplitAfter(out.String(), "\n") {
+		var msg struct {
+			Path string `json:"path"`
+		}
+		if line == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("bad response: %q: %v", line, err)
+		}
+		if msg.Path != "" {
+			paths = append(paths, msg.Path)
+			line = strings.Replace(line, msg.Path, "TEMP", 1)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, ""), paths
+}
+
+func checkTranscript(t testing.TB, got string, want string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("wrong transcript:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	dir := t.TempDir()
+	const greeting = "hello, world\n"
+	src := filepath.Join(dir, "greeting")
+	if err := os.WriteFile(src, []byte(greeting), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store := cas.NewStore("s3kr1t", cas.WithBucket(memblob.OpenBucket(nil)))
+	id := oid(greeting)
+
+	t.Run("upload", func(t *testing.T) {
+		input := fmt.Sprintf(`{"event":"init","operation":"upload","remote":"origin","concurrent":true,"concurrenttransfers":3}
+{"event":"upload","oid":"%[1]s","size":13,"path":"%[2]s","action":null}
+{"event":"upload","oid":"%[1]s","size":13,"path":"%[2]s","action":null}
+{"event":"terminate"}
+`, id, src)
+		want := fmt.Sprintf(`{}
+{"event":"progress","oid":"%[1]s","bytesSoFar":13,"bytesSinceLast":13}
+{"event":"complete","oid":"%[1]s"}
+{"event":"complete","oid":"%[1]s"}
+`, id)
+		got, _ := run(t, store, dir, input)
+		checkTranscript(t, got, want)
+	})
+
+	t.Run("download", func(t *testing.T) {
+		missing := oid("missing")
+		input := fmt.Sprintf(`{"event":"init","operation":"download","remote":"origin","concurrent":true,"concurrenttransfers":3}
+{"event":"download","oid":"%[1]s","size":13,"action":null}
+{"event":"download","oid":"%[2]s","size":13,"action":null}
+{"event":"terminate"}
+`, id, missing)
+		want := fmt.Sprintf(`{}
+{"event":"progress","oid":"%[1]s","bytesSoFar":13,"bytesSinceLast":13}
+{"event":"complete","oid":"%[1]s","path":"TEMP"}
+{"event":"complete","oid":"%[2]s","error":{"code":404,"message":"ref does not exist"}}
+`, id, missing)
+		got, paths := run(t, store, dir, input)
+		checkTranscript(t, got, want)
+		if len(paths) != 1 {
+			t.Fatalf("expected one download: %q", paths)
+		}
+		buf, err := os.ReadFile(paths[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g, e := string(buf), greeting; g != e {
+			t.Errorf("wrong content downloaded: %q != %q", g, e)
+		}
+	})
+}
+
+func TestUploadMismatch(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "greeting")
+	if err := os.WriteFile(src, []byte("hello, world\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store := cas.NewStore("s3kr1t", cas.WithBucket(memblob.OpenBucket(nil)))
+	wrong := oid("something else")
+	input := fmt.Sprintf(`{"event":"init","operation":"upload"}
+{"event":"upload","oid":"%[1]s","size":13,"path":"%[2]s"}
+`, wrong, src)
+	got, _ := run(t, store, dir, input)
+	if !strings.Contains(got, `"error":{"code":2,"message":"content does not match object id: `+oid("hello, world\n")+`"}`) {
+		t.Errorf("expected mismatch error: %s", got)
+	}
+	if _, err := store.GetRef(context.Background(), "lfs:"+wrong); !errors.Is(err, cas.ErrRefNotExist) {
+		t.Errorf("expected no ref: %v", err)
+	}
+}
+
+func TestInitError(t *testing.T) {
+	ctx := context.Background()
+	open := func(ctx context.Context) (*cas.Store, error) {
+		return nil, errors.New("no such volume")
+	}
+	var out bytes.Buffer
+	input := `{"event":"init","operation":"download"}` + "\n"
+	agent := lfstransfer.New(strings.NewReader(input), &out, open, "")
+	if err := agent.Serve(ctx); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	checkTranscript(t, out.String(), `{"error":{"code":32,"message":"no such volume"}}`+"\n")
+}
@@ -44,6 +44,11 @@ func (m MultiErr) Error() string {
 	return b.String()
 }
 
+// Unwrap lets errors.Is and errors.As look at the individual errors.
+func (m MultiErr) Unwrap() []error {
+	return m
+}
+
 // All reports whether all errors in a MultiErr (or, the singular
 // non-multi error) pass the test.
 func All(err error, test func(err error) bool) bool {
@@ -25,3 +25,18 @@ func TestToSlice(t *testing.T) {
 		t.Errorf("bad inner error: %v != %v", g, e)
 	}
 }
+
+func TestIs(t *testing.T) {
+	var (
+		errOne   = errors.New("one")
+		errTwo   = errors.New("two")
+		errThree = errors.New("three")
+	)
+	err := multierr.New([]error{errOne, errTwo})
+	if !errors.Is(err, errTwo) {
+		t.Errorf("expected to match inner error: %v", err)
+	}
+	if errors.Is(err, errThree) {
+		t.Errorf("unexpected match: %v", err)
+	}
+}
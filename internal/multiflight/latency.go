@@ -0,0 +1,62 @@
+package multiflight
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// minLatencySamples is how many durations Latency needs before it
+// estimates percentiles.
+const minLatencySamples = 20
+
+// Latency keeps the durations of recent attempts at an operation, to
+// estimate percentiles. It is safe for concurrent use.
+type Latency struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	// next is where the next sample goes, once samples is full
+	next int
+}
+
+// NewLatency returns a Latency that remembers the last size
+// durations.
+func NewLatency(size int) *Latency {
+	l := &Latency{
+		samples: make([]time.Duration, 0, size),
+	}
+	return l
+}
+
+// Observe records the duration of an attempt.
+func (l *Latency) Observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) < cap(l.samples) {
+		l.samples = append(l.samples, d)
+		return
+	}
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % len(l.samples)
+}
+
+// Quantile returns the q-quantile of the recent durations, for q
+// between 0 and 1. It returns false if there are too few samples to
+// tell.
+func (l *Latency) Quantile(q float64) (time.Duration, bool) {
+	l.mu.Lock()
+	sorted := append([]time.Duration(nil), l.samples...)
+	l.mu.Unlock()
+	if len(sorted) < minLatencySamples {
+		return 0, false
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(q * float64(len(sorted)-1))
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx], true
+}
@@ -0,0 +1,32 @@
+package multiflight_test
+
+import (
+	"testing"
+	"time"
+
+	"bazil.org/plop/internal/multiflight"
+)
+
+func TestLatency(t *testing.T) {
+	l := multiflight.NewLatency(100)
+	if _, ok := l.Quantile(0.5); ok {
+		t.Fatal("expected no estimate without samples")
+	}
+	for i := 1; i <= 100; i++ {
+		l.Observe(time.Duration(i) * time.Millisecond)
+	}
+	if g, ok := l.Quantile(0.5); !ok || g != 50*time.Millisecond {
+		t.Errorf("wrong median: %v %v", g, ok)
+	}
+	if g, ok := l.Quantile(0.95); !ok || g != 95*time.Millisecond {
+		t.Errorf("wrong p95: %v %v", g, ok)
+	}
+
+	// old samples are forgotten
+	for i := 0; i < 100; i++ {
+		l.Observe(time.Second)
+	}
+	if g, ok := l.Quantile(0); !ok || g != time.Second {
+		t.Errorf("wrong minimum: %v %v", g, ok)
+	}
+}
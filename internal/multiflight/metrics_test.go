@@ -0,0 +1,49 @@
+package multiflight
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOnFallback(t *testing.T) {
+	fallbacks := map[string]int{}
+	count := func(reason string) {
+		fallbacks[reason]++
+	}
+	m := New()
+	m.SetOnFallback(count)
+	m.Add(0, func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("fail for test")
+	})
+	m.Add(time.Hour, func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	// the first failure starts the second alternative early
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := m.Run(ctx); err == nil {
+		t.Fatal("expected error")
+	}
+	if g, e := fallbacks["error"], 1; g != e {
+		t.Errorf("wrong error fallbacks: %v != %v", g, e)
+	}
+
+	m = New()
+	m.SetOnFallback(count)
+	m.Add(0, func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	m.Add(time.Millisecond, func(ctx context.Context) (interface{}, error) {
+		return "ok", nil
+	})
+	if _, err := m.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if g, e := fallbacks["delay"], 1; g != e {
+		t.Errorf("wrong delay fallbacks: %v != %v", g, e)
+	}
+}
@@ -9,8 +9,14 @@ import (
 	"time"
 
 	"bazil.org/plop/internal/multierr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("bazil.org/plop/internal/multiflight")
+
 const debugLog = false
 
 func debugf(fmt string, args ...interface{}) {
@@ -25,8 +31,10 @@ type op struct {
 }
 
 type Multiflight struct {
+	name       string
 	maxWorkers int
 	actions    []op
+	onFallback func(reason string)
 }
 
 func New() *Multiflight {
@@ -40,6 +48,18 @@ func (m *Multiflight) SetMaxWorkers(n int) {
 	m.maxWorkers = n
 }
 
+// SetName sets the name of the operation, used in traces.
+func (m *Multiflight) SetName(name string) {
+	m.name = name
+}
+
+// SetOnFallback sets a function called whenever an alternative is
+// started because earlier ones were slow (reason "delay") or failed
+// (reason "error"), for metrics.
+func (m *Multiflight) SetOnFallback(fn func(reason string)) {
+	m.onFallback = fn
+}
+
 // Add an action to run later.
 //
 // In general, avoid side effects in the action function, and where unavoidable make them goroutine safe.
@@ -84,6 +104,9 @@ func (m *Multiflight) Run(ctx context.Context) (interface{}, error) {
 	var errs []error
 	exited := make(chan result, 1)
 	numWorkers := 0
+	started := 0
+	// reason why the next worker is started, for metrics
+	reason := ""
 	next := time.NewTimer(0)
 	// Reusing timers is subtle. Guarantee the timer is stopped and
 	// drained at the beginning of every iteration.
@@ -119,6 +142,7 @@ func (m *Multiflight) Run(ctx context.Context) (interface{}, error) {
 				// fallback.
 				debugf("trigger by time")
 				wantMore = true
+				reason = "delay"
 
 			case r := <-exited:
 				if timeBased != nil {
@@ -141,6 +165,7 @@ func (m *Multiflight) Run(ctx context.Context) (interface{}, error) {
 				debugf("worker error: %v", r.err)
 				errs = append(errs, r.err)
 				numWorkers--
+				reason = "error"
 			}
 		}
 
@@ -154,9 +179,27 @@ func (m *Multiflight) Run(ctx context.Context) (interface{}, error) {
 			act := actions[0]
 			actions = actions[1:]
 			numWorkers++
+			if started > 0 && m.onFallback != nil {
+				m.onFallback(reason)
+			}
+			attrs := []attribute.KeyValue{
+				attribute.String("multiflight.op", m.name),
+				attribute.Int("multiflight.attempt", started),
+				attribute.Int64("multiflight.delay_ms", act.delay.Milliseconds()),
+			}
+			if started > 0 {
+				attrs = append(attrs, attribute.String("multiflight.reason", reason))
+			}
+			started++
 			debugf("start worker: num=%d", numWorkers)
 			go func() {
+				ctx, span := tracer.Start(ctx, "multiflight.attempt", trace.WithAttributes(attrs...))
 				success, err := act.fn(ctx)
+				if err != nil {
+					span.RecordError(err)
+					span.SetStatus(codes.Error, err.Error())
+				}
+				span.End()
 				exited <- result{success: success, err: err}
 			}()
 		}
@@ -0,0 +1,93 @@
+package plopfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"bazil.org/plop/cas"
+	"bazil.org/plop/internal/daemon"
+)
+
+// PlopFS serves its volumes over the control socket, so other
+// commands share the stores of the mount instead of opening the
+// volumes again.
+var _ daemon.Volumes = (*PlopFS)(nil)
+
+// openControl returns the named volume for the control socket,
+// opening it if needed.
+func (f *PlopFS) openControl(ctx context.Context, name string) (*openVolume, error) {
+	sv, ok := f.served(name)
+	if !ok {
+		return nil, daemon.ErrNoVolume
+	}
+	ctx, cancel := f.withTimeout(ctx)
+	defer cancel()
+	ov, err := sv.open(ctx, f)
+	switch {
+	case errors.Is(err, errVolumeLocked):
+		return nil, daemon.ErrLocked
+	case errors.Is(err, errVolumeRetired):
+		return nil, daemon.ErrNoVolume
+	case err != nil:
+		return nil, err
+	}
+	return ov, nil
+}
+
+func (f *PlopFS) OpenStore(ctx context.Context, name string) (*cas.Store, error) {
+	ov, err := f.openControl(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return ov.store, nil
+}
+
+func (f *PlopFS) Unlock(ctx context.Context, name string, passphrase string) error {
+	sv, ok := f.served(name)
+	if !ok {
+		return daemon.ErrNoVolume
+	}
+	ctx, cancel := f.withTimeout(ctx)
+	defer cancel()
+	err := sv.unlock(ctx, f, passphrase)
+	if errors.Is(err, errVolumeRetired) {
+		return daemon.ErrNoVolume
+	}
+	return err
+}
+
+func (f *PlopFS) SyncCatalog(ctx context.Context, name string) error {
+	ov, err := f.openControl(ctx, name)
+	if err != nil {
+		return err
+	}
+	if ov.catalog == nil || !ov.catalog.sync {
+		return nil
+	}
+	if err := ov.catalog.catalog.Sync(ctx, ov.store); err != nil {
+		return fmt.Errorf("cannot sync catalog: %w", err)
+	}
+	return nil
+}
+
+func (f *PlopFS) Stores() map[string]*cas.Store {
+	f.mu.Lock()
+	volumes := make(map[string]*servedVolume, len(f.volumes))
+	for name, sv := range f.volumes {
+		volumes[name] = sv
+	}
+	f.mu.Unlock()
+
+	stores := make(map[string]*cas.Store, len(volumes))
+	for name, sv := range volumes {
+		sv.mu.Lock()
+		var store *cas.Store
+		if sv.opened != nil {
+			store = sv.opened.store
+		}
+		sv.mu.Unlock()
+		stores[name] = store
+	}
+	return stores
+}
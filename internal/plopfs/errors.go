@@ -0,0 +1,87 @@
+package plopfs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"syscall"
+
+	"bazil.org/plop/cas"
+	"bazil.org/plop/internal/multierr"
+	"bazil.org/plop/internal/ratelimit"
+	"gocloud.dev/gcerrors"
+)
+
+// request runs fn with the configured request timeout, and maps the
+// resulting error to an errno. The what argument names the thing
+// being accessed, for log messages. Transient bucket errors are
+// already retried by the store, within the deadline.
+//
+// The kernel cancels ctx when the calling process is interrupted,
+// which aborts any downloads in progress. Someone is waiting on the
+// request, so it goes before other work under bucket rate limits.
+func (f *PlopFS) request(ctx context.Context, what string, fn func(ctx context.Context) error) error {
+	ctx = ratelimit.WithPriority(ctx, ratelimit.Interactive)
+	ctx, cancel := f.withTimeout(ctx)
+	defer cancel()
+	err := fn(ctx)
+	return toErrno(ctx, what, err)
+}
+
+// withTimeout limits ctx to the configured request timeout.
+func (f *PlopFS) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, f.mountOptions().RequestTimeout)
+}
+
+// isTransient reports whether err may go away by trying again.
+func isTransient(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	switch gcerrors.Code(err) {
+	case gcerrors.ResourceExhausted, gcerrors.DeadlineExceeded:
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return false
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, cas.ErrNotExist) ||
+		errors.Is(err, cas.ErrBadKey) ||
+		gcerrors.Code(err) == gcerrors.NotFound
+}
+
+// toErrno maps errors from the store to what the caller of the
+// filesystem operation sees. Unexpected errors are logged, as EIO
+// alone does not tell much.
+func toErrno(ctx context.Context, what string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errno, ok := err.(syscall.Errno); ok {
+		return errno
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		// interrupted
+		return syscall.EINTR
+	}
+	if multierr.All(err, isNotFound) {
+		return syscall.ENOENT
+	}
+	if isTransient(err) {
+		log.Printf("%s: giving up: %v", what, err)
+		return syscall.EAGAIN
+	}
+	var corrupt *cas.CorruptObjectError
+	if errors.As(err, &corrupt) {
+		log.Printf("%s: corrupt object %s in bucket %s: %v", what, corrupt.BoxedKey, corrupt.Bucket, err)
+		return syscall.EIO
+	}
+	log.Printf("%s: %v", what, err)
+	return syscall.EIO
+}
@@ -0,0 +1,49 @@
+package plopfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+
+	"bazil.org/plop/cas"
+	"bazil.org/plop/internal/multierr"
+)
+
+func TestToErrno(t *testing.T) {
+	corrupt := &cas.CorruptObjectError{
+		Bucket:   "one",
+		BoxedKey: "xyzzy",
+		Err:      errors.New("box open"),
+	}
+	for _, tc := range []struct {
+		name string
+		err  error
+		want syscall.Errno
+	}{
+		{"not exist", cas.ErrNotExist, syscall.ENOENT},
+		{"bad key", fmt.Errorf("open: %w", cas.ErrBadKey), syscall.ENOENT},
+		{"corrupt", corrupt, syscall.EIO},
+		{"corrupt and missing", multierr.New([]error{corrupt, cas.ErrNotExist}), syscall.EIO},
+		{"timeout", fmt.Errorf("read: %w", context.DeadlineExceeded), syscall.EAGAIN},
+		{"other", errors.New("boom"), syscall.EIO},
+		{"errno", syscall.EACCES, syscall.EACCES},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := toErrno(context.Background(), "test", tc.err)
+			if g, e := err, tc.want; g != e {
+				t.Errorf("wrong errno: %v != %v", g, e)
+			}
+		})
+	}
+}
+
+func TestToErrnoInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := toErrno(ctx, "test", ctx.Err())
+	if g, e := err, syscall.EINTR; g != e {
+		t.Errorf("wrong errno: %v != %v", g, e)
+	}
+}
@@ -0,0 +1,13 @@
+package plopfs
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/plop/internal/config"
+)
+
+// MountOptions returns the FUSE mount options Mount uses for the main
+// mountpoint of cfg.
+func MountOptions(cfg *config.Config) []fuse.MountOption {
+	mp := mountPoints(cfg, nil)[0]
+	return mountOptions(cfg.MountOptions(), mp.readOnly)
+}
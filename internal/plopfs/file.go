@@ -3,14 +3,25 @@ package plopfs
 import (
 	"context"
 	"io"
+	"sync"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
 	"bazil.org/plop/cas"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type File struct {
+	fs *PlopFS
+	// volume is the volume the file was opened from.
+	volume *openVolume
+	key    string
 	handle *cas.Handle
+
+	// xattrs are computed on first use; see File.xattrs.
+	xattrMu    sync.Mutex
+	xattrCache map[string]string
 }
 
 var _ = fs.Node(&File{})
@@ -18,7 +29,7 @@ var _ = fs.Handle(&File{})
 
 func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
 	a.Valid = forever
-	a.Mode = 0o444
+	f.fs.fileAttr(a)
 	size := uint64(f.handle.Size())
 	a.Size = size
 	const blockSize = 512
@@ -38,12 +49,26 @@ func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenR
 
 var _ = fs.HandleReader(&File{})
 
-func (f *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
-	resp.Data = resp.Data[:req.Size]
-	n, err := f.handle.IO(ctx).ReadAt(resp.Data, req.Offset)
-	if err != nil && err != io.EOF {
+func (f *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) (err error) {
+	ctx, span := tracer.Start(ctx, "plopfs.Read", trace.WithAttributes(
+		attribute.String("plop.key", f.key),
+		attribute.Int64("plop.offset", req.Offset),
+		attribute.Int("plop.size", req.Size),
+	))
+	defer func() { endSpan(span, err) }()
+	buf := resp.Data[:req.Size]
+	var n int
+	read := func(ctx context.Context) error {
+		var err error
+		n, err = f.handle.IO(ctx).ReadAt(buf, req.Offset)
+		if err == io.EOF {
+			err = nil
+		}
+		return err
+	}
+	if err := f.fs.request(ctx, "object "+f.key, read); err != nil {
 		return err
 	}
-	resp.Data = resp.Data[:n]
+	resp.Data = buf[:n]
 	return nil
 }
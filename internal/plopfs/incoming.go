@@ -0,0 +1,362 @@
+package plopfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"bazil.org/plop/cas"
+	"bazil.org/plop/internal/ratelimit"
+)
+
+// incomingDirName is the name of the writable directory inside a
+// volume, if enabled. It cannot collide with keys, as it is not valid
+// zbase32 of the right length.
+const incomingDirName = "incoming"
+
+// errIncomingAborted is used to abort an upload when the file is
+// removed or released without being flushed.
+var errIncomingAborted = errors.New("incoming file aborted")
+
+// Incoming is a writable directory where files are stored in the
+// volume as they are written. Once the last file descriptor for a
+// file is closed, it is replaced by a symlink to the object.
+//
+// The directory contents are only kept in memory, and are lost on
+// unmount. The objects themselves stay in the volume.
+type Incoming struct {
+	fs     *PlopFS
+	volume string
+	store  *cas.Store
+
+	mu sync.Mutex
+	// entries are either *IncomingFile or *RefLink.
+	entries map[string]fs.Node
+}
+
+func newIncoming(filesys *PlopFS, volume string, store *cas.Store) *Incoming {
+	d := &Incoming{
+		fs:      filesys,
+		volume:  volume,
+		store:   store,
+		entries: make(map[string]fs.Node),
+	}
+	return d
+}
+
+var _ = fs.Node(&Incoming{})
+
+func (d *Incoming) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Valid = 0
+	// writable regardless of the configured directory mode
+	d.fs.dirAttr(a)
+	a.Mode |= 0o200
+	return nil
+}
+
+var _ = fs.NodeRequestLookuper(&Incoming{})
+
+func (d *Incoming) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	d.mu.Lock()
+	n, ok := d.entries[req.Name]
+	d.mu.Unlock()
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	if f, ok := n.(*IncomingFile); ok && f.settle(ctx) {
+		// look again, the file may have become a symlink
+		d.mu.Lock()
+		n, ok = d.entries[req.Name]
+		d.mu.Unlock()
+		if !ok {
+			return nil, syscall.ENOENT
+		}
+	}
+	// entries change from file to symlink on close
+	resp.EntryValid = 0
+	return n, nil
+}
+
+var _ fs.HandleReadDirAller = (*Incoming)(nil)
+
+func (d *Incoming) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	res := make([]fuse.Dirent, 0, len(d.entries))
+	for name, n := range d.entries {
+		de := fuse.Dirent{
+			Type: fuse.DT_File,
+			Name: name,
+		}
+		if _, ok := n.(*RefLink); ok {
+			de.Type = fuse.DT_Link
+		}
+		res = append(res, de)
+	}
+	return res, nil
+}
+
+var _ = fs.NodeCreater(&Incoming{})
+
+func (d *Incoming) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.entries[req.Name]; ok {
+		return nil, nil, syscall.EEXIST
+	}
+
+	pr, pw := io.Pipe()
+	f := &IncomingFile{
+		dir:       d,
+		name:      req.Name,
+		pw:        pw,
+		done:      make(chan struct{}),
+		released:  make(chan struct{}),
+		finalized: make(chan struct{}),
+	}
+	go func() {
+		defer close(f.done)
+		// The upload outlives the create request, and nobody waits
+		// on it.
+		ctx := ratelimit.WithPriority(context.Background(), ratelimit.Background)
+		key, err := d.store.Create(ctx, pr, cas.CreateSource(incomingDirName+"/"+req.Name))
+		// unblock writers if the upload failed early
+		pr.CloseWithError(err)
+		f.key = key
+		f.err = err
+	}()
+	d.entries[req.Name] = f
+	resp.EntryValid = 0
+	resp.Attr.Valid = 0
+	return f, f, nil
+}
+
+var _ = fs.NodeRemover(&Incoming{})
+
+// Remove forgets an entry in the incoming directory. If the file is
+// still being written, the upload is aborted. Objects already stored
+// in the volume are not affected.
+func (d *Incoming) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	if req.Dir {
+		return syscall.ENOTDIR
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n, ok := d.entries[req.Name]
+	if !ok {
+		return syscall.ENOENT
+	}
+	delete(d.entries, req.Name)
+	if f, ok := n.(*IncomingFile); ok {
+		f.abort()
+	}
+	return nil
+}
+
+// finish replaces the entry for a stored file with a symlink to its
+// key.
+func (d *Incoming) finish(f *IncomingFile, key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.entries[f.name] != f {
+		// removed while being written
+		return
+	}
+	d.entries[f.name] = &RefLink{
+		fs:     d.fs,
+		volume: d.volume,
+		key:    key,
+	}
+}
+
+// forget removes the entry for a file that failed to store.
+func (d *Incoming) forget(f *IncomingFile) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.entries[f.name] == f {
+		delete(d.entries, f.name)
+	}
+}
+
+// incomingSettle is how long a lookup waits for a closed incoming
+// file to be released, so that the file looks like a symlink right
+// after close(2). Files still open elsewhere, such as through a
+// duplicated or inherited file descriptor, stay files.
+const incomingSettle = 1 * time.Second
+
+// IncomingFile is a file being written into the incoming directory.
+// Writes must be sequential, as the content is streamed into the
+// volume.
+//
+// The object is finalized on release, when the last file descriptor
+// is closed. Flushes are sent for every close(2), including those of
+// duplicated and inherited file descriptors, so they cannot end the
+// upload.
+type IncomingFile struct {
+	dir  *Incoming
+	name string
+	pw   *io.PipeWriter
+
+	// writeMu serializes writes, without holding mu while blocked
+	// on the upload.
+	writeMu sync.Mutex
+
+	mu     sync.Mutex
+	size   int64
+	closed bool
+	// flushes counts close(2) calls, and settled how many of them a
+	// lookup has already waited for.
+	flushes int
+	settled int
+
+	// done is closed when the upload finishes, after which key and
+	// err are set.
+	done chan struct{}
+	key  string
+	err  error
+
+	// released is closed when the file is released, and finalized
+	// once the directory entry has been resolved after that.
+	released  chan struct{}
+	finalized chan struct{}
+}
+
+var _ = fs.Node(&IncomingFile{})
+var _ = fs.Handle(&IncomingFile{})
+
+func (f *IncomingFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a.Valid = 0
+	f.dir.fs.fileAttr(a)
+	a.Mode |= 0o200
+	a.Size = uint64(f.size)
+	return nil
+}
+
+var _ = fs.NodeOpener(&IncomingFile{})
+
+// Open refuses to open a file that is still being written. Only the
+// creator can write to it.
+func (f *IncomingFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	return nil, syscall.EBUSY
+}
+
+var _ = fs.HandleWriter(&IncomingFile{})
+
+func (f *IncomingFile) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+	f.mu.Lock()
+	closed, size := f.closed, f.size
+	f.mu.Unlock()
+	if closed {
+		return syscall.EBADF
+	}
+	if req.Offset != size {
+		// cannot seek in a stream
+		return syscall.ESPIPE
+	}
+	n, err := f.pw.Write(req.Data)
+	f.mu.Lock()
+	f.size += int64(n)
+	f.mu.Unlock()
+	resp.Size = n
+	if err != nil {
+		log.Printf("incoming %q: %v", f.name, err)
+		return syscall.EIO
+	}
+	return nil
+}
+
+var _ = fs.HandleFlusher(&IncomingFile{})
+
+// Flush reports an upload that has already failed to close(2). Other
+// upload errors can only be logged, as the upload finishes on
+// release.
+func (f *IncomingFile) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	f.mu.Lock()
+	f.flushes++
+	f.mu.Unlock()
+	select {
+	case <-f.done:
+		if f.err != nil {
+			return syscall.EIO
+		}
+	default:
+	}
+	return nil
+}
+
+var _ = fs.HandleReleaser(&IncomingFile{})
+
+// Release finishes storing the file, and replaces it with a symlink
+// to the object.
+func (f *IncomingFile) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	close(f.released)
+	_ = f.pw.Close()
+	// The upload is not canceled on interrupt, as the entry needs to
+	// be resolved either way.
+	<-f.done
+	if f.err != nil {
+		log.Printf("incoming %q: cannot store: %v", f.name, f.err)
+		f.dir.forget(f)
+	} else {
+		f.dir.finish(f, f.key)
+	}
+	close(f.finalized)
+	return nil
+}
+
+// settle waits for a file that has been closed to be finalized. It
+// reports whether the file was finalized. Each close(2) is only
+// waited for once, so a file kept open elsewhere does not slow down
+// every lookup.
+func (f *IncomingFile) settle(ctx context.Context) bool {
+	f.mu.Lock()
+	flushes := f.flushes
+	waited := f.settled == flushes
+	f.mu.Unlock()
+	select {
+	case <-f.released:
+	default:
+		if waited {
+			// still being written
+			return false
+		}
+	}
+	t := time.NewTimer(incomingSettle)
+	defer t.Stop()
+	select {
+	case <-f.released:
+	case <-t.C:
+		f.mu.Lock()
+		if f.settled < flushes {
+			f.settled = flushes
+		}
+		f.mu.Unlock()
+		return false
+	case <-ctx.Done():
+		return false
+	}
+	select {
+	case <-f.finalized:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// abort stops an unfinished upload.
+func (f *IncomingFile) abort() {
+	_ = f.pw.CloseWithError(errIncomingAborted)
+}
@@ -0,0 +1,295 @@
+package plopfs
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"bazil.org/plop/internal/config"
+	"bazil.org/plop/internal/multierr"
+)
+
+// mountPoint is a path where a filesystem is to be served.
+type mountPoint struct {
+	path     string
+	fs       fs.FS
+	readOnly bool
+}
+
+// mountPoints returns all the paths to mount.
+func mountPoints(cfg *config.Config, filesys *PlopFS) []*mountPoint {
+	var mps []*mountPoint
+	if vols := cfg.MountOptions().Volumes; len(vols) > 0 {
+		mps = append(mps, &mountPoint{
+			path:     cfg.MountPoint,
+			fs:       filesys,
+			readOnly: !hasIncoming(vols),
+		})
+	}
+	for _, vol := range cfg.Volumes {
+		if vol.MountPoint == "" {
+			continue
+		}
+		mps = append(mps, &mountPoint{
+			path: vol.MountPoint,
+			fs: &volumeFS{
+				fs:   filesys,
+				name: vol.Name,
+			},
+			readOnly: !vol.Incoming,
+		})
+	}
+	return mps
+}
+
+// hasIncoming reports whether any of the volumes needs a writable
+// mount.
+func hasIncoming(vols []*config.Volume) bool {
+	for _, vol := range vols {
+		if vol.Incoming {
+			return true
+		}
+	}
+	return false
+}
+
+func mountOptions(opts *config.MountOptions, readOnly bool) []fuse.MountOption {
+	options := []fuse.MountOption{
+		fuse.Subtype("plopfs"),
+		fuse.AsyncRead(),
+		fuse.MaxReadahead(opts.Readahead),
+		fuse.DefaultPermissions(),
+	}
+	if readOnly {
+		options = append(options, fuse.ReadOnly())
+	}
+	if opts.AllowOther {
+		options = append(options, fuse.AllowOther())
+	}
+	return options
+}
+
+// needsRemount reports whether changing the configuration from old to
+// cfg changes anything that can only take effect by remounting.
+func needsRemount(old, cfg *config.Config) bool {
+	type mountKey struct {
+		path     string
+		readOnly bool
+	}
+	key := func(cfg *config.Config) []mountKey {
+		var keys []mountKey
+		for _, mp := range mountPoints(cfg, nil) {
+			keys = append(keys, mountKey{path: mp.path, readOnly: mp.readOnly})
+		}
+		return keys
+	}
+	if !reflect.DeepEqual(key(old), key(cfg)) {
+		return true
+	}
+	if old.MountOptions().AllowOther != cfg.MountOptions().AllowOther {
+		return true
+	}
+	if old.MountOptions().Readahead != cfg.MountOptions().Readahead {
+		return true
+	}
+	return false
+}
+
+// reloadMessage describes the effect of replacing the applied
+// configuration with cfg, for a filesystem mounted with mounted.
+func reloadMessage(mounted, applied, cfg *config.Config) string {
+	switch {
+	case !needsRemount(mounted, cfg):
+		return "config reloaded"
+	case needsRemount(applied, cfg):
+		return "config reloaded, mountpoint and mount option changes need a remount"
+	default:
+		return "config reloaded, earlier mountpoint and mount option changes still need a remount"
+	}
+}
+
+type mountConfig struct {
+	serve    func(*PlopFS) (stop func(), err error)
+	reload   func() (*config.Config, error)
+	ready    func()
+	stopping func()
+}
+
+type mountOption func(*mountConfig)
+
+// MountOption is an option passed to Mount.
+type MountOption mountOption
+
+// WithServe calls fn with the filesystem before mounting it, to serve
+// its volumes in other ways too. The returned stop function is called
+// before the filesystem is closed.
+func WithServe(fn func(filesys *PlopFS) (stop func(), err error)) MountOption {
+	return func(conf *mountConfig) {
+		conf.serve = fn
+	}
+}
+
+// WithReload calls fn on SIGHUP to read a new configuration, which is
+// applied with PlopFS.Update.
+func WithReload(fn func() (*config.Config, error)) MountOption {
+	return func(conf *mountConfig) {
+		conf.reload = fn
+	}
+}
+
+// WithReady calls fn once all mountpoints are serving.
+func WithReady(fn func()) MountOption {
+	return func(conf *mountConfig) {
+		conf.ready = fn
+	}
+}
+
+// WithStopping calls fn when the filesystem starts shutting down.
+func WithStopping(fn func()) MountOption {
+	return func(conf *mountConfig) {
+		conf.stopping = fn
+	}
+}
+
+// Mount serves plopfs at the configured mountpoints, until they are
+// unmounted.
+//
+// SIGINT and SIGTERM unmount the filesystem.
+func Mount(cfg *config.Config, opts ...MountOption) error {
+	var conf mountConfig
+	for _, opt := range opts {
+		opt(&conf)
+	}
+	stopping := func() {
+		if conf.stopping != nil {
+			conf.stopping()
+			conf.stopping = nil
+		}
+	}
+
+	filesys, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := filesys.Close(); err != nil {
+			log.Printf("error closing filesystem: %v", err)
+		}
+	}()
+	if conf.serve != nil {
+		stop, err := conf.serve(filesys)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	mps := mountPoints(cfg, filesys)
+	conns := make([]*fuse.Conn, 0, len(mps))
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	for _, mp := range mps {
+		c, err := fuse.Mount(mp.path, mountOptions(cfg.MountOptions(), mp.readOnly)...)
+		if err != nil {
+			// undo what was mounted so far
+			unmountAll(mps[:len(conns)])
+			return err
+		}
+		conns = append(conns, c)
+	}
+
+	// Catch signals only once mounted, so interrupting a slow
+	// startup still works.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	errCh := make(chan error, len(mps))
+	for i, mp := range mps {
+		go func(c *fuse.Conn, mp *mountPoint) {
+			errCh <- fs.Serve(c, mp.fs)
+		}(conns[i], mp)
+	}
+	// The mounts are initialized, requests will be served as they
+	// come in.
+	if conf.ready != nil {
+		conf.ready()
+	}
+	// applied is the configuration most recently applied with
+	// Update; cfg stays what the mountpoints were mounted with.
+	applied := cfg
+	var errs []error
+	for running := len(mps); running > 0; {
+		select {
+		case err := <-errCh:
+			running--
+			// unmounted from outside
+			stopping()
+			if err != nil {
+				errs = append(errs, err)
+			}
+
+		case sig := <-sigs:
+			switch sig {
+			case syscall.SIGHUP:
+				if conf.reload == nil {
+					continue
+				}
+				newCfg, err := conf.reload()
+				if err != nil {
+					log.Printf("cannot reload config: %v", err)
+					continue
+				}
+				if err := filesys.Update(newCfg); err != nil {
+					log.Printf("cannot apply new config: %v", err)
+					continue
+				}
+				log.Print(reloadMessage(cfg, applied, newCfg))
+				applied = newCfg
+
+			default:
+				log.Printf("%v: unmounting", sig)
+				stopping()
+				unmountAll(mps)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return multierr.New(errs)
+	}
+	return nil
+}
+
+// unmountAll unmounts all the mountpoints. Failures, for example due
+// to the filesystem being busy, are logged and leave the mount
+// running.
+func unmountAll(mps []*mountPoint) {
+	for _, mp := range mps {
+		if err := fuse.Unmount(mp.path); err != nil {
+			log.Printf("cannot unmount %s: %v", mp.path, err)
+		}
+	}
+}
+
+// Unmount unmounts all the configured mountpoints. A running Mount
+// then returns once the kernel has let go of the filesystem.
+func Unmount(cfg *config.Config) error {
+	var errs []error
+	for _, mp := range mountPoints(cfg, nil) {
+		if err := fuse.Unmount(mp.path); err != nil {
+			errs = append(errs, fmt.Errorf("cannot unmount %s: %w", mp.path, err))
+		}
+	}
+	if len(errs) > 0 {
+		return multierr.New(errs)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package plopfs
+
+import (
+	"fmt"
+	"testing"
+
+	"bazil.org/plop/internal/config"
+)
+
+func TestReloadMessage(t *testing.T) {
+	parse := func(allowOther bool) *config.Config {
+		t.Helper()
+		text := fmt.Sprintf(`mountpoint = "/does-not-exist"
+mount {
+  allow_other = %t
+}
+volume "one" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = "file:///does-not-exist"
+  }
+}
+`, allowOther)
+		cfg, err := config.ParseConfig("<test literal>.hcl", []byte(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+	mounted := parse(false)
+	changed := parse(true)
+
+	const (
+		same    = "config reloaded"
+		remount = "config reloaded, mountpoint and mount option changes need a remount"
+		pending = "config reloaded, earlier mountpoint and mount option changes still need a remount"
+	)
+	for _, tc := range []struct {
+		name            string
+		applied, newCfg *config.Config
+		want            string
+	}{
+		{"unchanged", mounted, parse(false), same},
+		{"changed", mounted, changed, remount},
+		{"reapplied", changed, parse(true), pending},
+		{"reverted", changed, parse(false), same},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := reloadMessage(mounted, tc.applied, tc.newCfg); got != tc.want {
+				t.Errorf("wrong message: %q != %q", got, tc.want)
+			}
+		})
+	}
+}
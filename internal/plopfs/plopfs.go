@@ -2,13 +2,16 @@ package plopfs
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"os"
+	"sync"
 	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
 	"bazil.org/plop/cas"
+	"bazil.org/plop/internal/catalog"
 	"bazil.org/plop/internal/config"
 	"bazil.org/plop/internal/multierr"
 	"gocloud.dev/blob"
@@ -19,37 +22,354 @@ import (
 const forever = 1000000 * time.Hour
 
 type PlopFS struct {
-	volumes map[string]*cas.Store
-	buckets map[string][]*blob.Bucket
+	// updateMu serializes Update, which does not hold mu while
+	// comparing volumes.
+	updateMu sync.Mutex
+
+	mu  sync.Mutex
+	cfg *config.Config
+	// exposed holds the names of volumes visible at the root of the
+	// main mount.
+	exposed map[string]struct{}
+	volumes map[string]*servedVolume
+	// retired holds buckets of volumes removed or replaced by
+	// Update. Open files may still refer to them, so they are only
+	// closed by Close.
+	retired []*blob.Bucket
+}
+
+// servedVolume is a configured volume. It is opened on first use.
+type servedVolume struct {
+	name string
+
+	mu     sync.Mutex
+	cfg    *config.Config
+	config *config.Volume
+	// passphrase given at runtime, for volumes that have none
+	// configured.
+	passphrase string
+	// opened is nil until the volume is opened.
+	opened *openVolume
+	// opening is closed when the open in progress finishes, and nil
+	// if none is. Opening talks to the buckets and derives keys, so
+	// it is done without holding mu.
+	opening chan struct{}
+	// retired is set when the volume is no longer served.
+	retired bool
 }
 
+// openVolume is a volume opened for serving.
+type openVolume struct {
+	name    string
+	store   *cas.Store
+	buckets []*blob.Bucket
+	// catalog is nil if the volume has no catalog.
+	catalog *volumeCatalog
+	// incoming is nil if the volume has no incoming directory.
+	incoming *Incoming
+}
+
+// catalogRefreshInterval is how often listing a volume directory
+// refreshes the catalog from the volume, for volumes that sync their
+// catalog. Listings in between serve the local catalog.
+const catalogRefreshInterval = 1 * time.Minute
+
+type volumeCatalog struct {
+	catalog *catalog.Catalog
+	sync    bool
+
+	mu         sync.Mutex
+	refreshed  time.Time
+	refreshing bool
+}
+
+// refreshDue reports whether the catalog should be refreshed from the
+// volume now. If so, the caller must call refreshDone when finished.
+// A refresh already in progress is not waited for.
+func (c *volumeCatalog) refreshDue(now time.Time) bool {
+	if !c.sync {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refreshing || (!c.refreshed.IsZero() && now.Sub(c.refreshed) < catalogRefreshInterval) {
+		return false
+	}
+	c.refreshing = true
+	return true
+}
+
+// refreshDone records a refresh as finished. Failed refreshes count
+// too, so an unreachable volume is not asked on every listing.
+func (c *volumeCatalog) refreshDone(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshing = false
+	c.refreshed = now
+}
+
+// errVolumeLocked is returned when opening a volume that has no
+// passphrase configured, and none was given at runtime.
+var errVolumeLocked = errors.New("volume is locked")
+
+// errVolumeRetired is returned when opening a volume that was removed
+// by a config reload.
+var errVolumeRetired = errors.New("volume is no longer served")
+
+// New returns a filesystem serving the volumes in cfg. Volumes are
+// opened on first use, so that one unreachable volume does not
+// prevent serving others.
 func New(cfg *config.Config) (*PlopFS, error) {
 	filesys := &PlopFS{
-		volumes: make(map[string]*cas.Store, len(cfg.Volumes)),
-		buckets: make(map[string][]*blob.Bucket, len(cfg.Volumes)),
+		volumes: make(map[string]*servedVolume, len(cfg.Volumes)),
 	}
-	ctx := context.TODO()
-	for _, vol := range cfg.Volumes {
-		store, buckets, err := config.OpenVolume(ctx, cfg, vol)
+	if err := filesys.Update(cfg); err != nil {
+		return nil, err
+	}
+	return filesys, nil
+}
+
+// served reports whether the volume is served in any mount.
+func served(cfg *config.Config, vol *config.Volume) bool {
+	if vol.MountPoint != "" {
+		return true
+	}
+	for _, v := range cfg.MountOptions().Volumes {
+		if v == vol {
+			return true
+		}
+	}
+	return false
+}
+
+// open opens the volume if needed. Failures are not remembered, so
+// the next use tries again. Concurrent calls wait for one open.
+func (sv *servedVolume) open(ctx context.Context, filesys *PlopFS) (*openVolume, error) {
+	for {
+		sv.mu.Lock()
+		if sv.retired {
+			sv.mu.Unlock()
+			return nil, errVolumeRetired
+		}
+		if ov := sv.opened; ov != nil {
+			sv.mu.Unlock()
+			return ov, nil
+		}
+		if wait := sv.opening; wait != nil {
+			sv.mu.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		done := make(chan struct{})
+		sv.opening = done
+		cfg, vol, runtime := sv.cfg, sv.config, sv.passphrase
+		sv.mu.Unlock()
+
+		ov, err := openServed(ctx, cfg, vol, runtime)
+
+		sv.mu.Lock()
+		sv.opening = nil
+		close(done)
 		if err != nil {
+			sv.mu.Unlock()
 			return nil, err
 		}
-		filesys.volumes[vol.Name] = store
-		filesys.buckets[vol.Name] = buckets
+		if sv.retired {
+			sv.mu.Unlock()
+			closeBuckets(ov.buckets)
+			return nil, errVolumeRetired
+		}
+		// the configuration may have changed while opening, but
+		// not the storage
+		if sv.config.Incoming {
+			ov.incoming = newIncoming(filesys, ov.name, ov.store)
+		}
+		sv.opened = ov
+		sv.mu.Unlock()
+		return ov, nil
 	}
-	return filesys, nil
+}
+
+// openServed opens a volume with its configured passphrase, or
+// runtime if it has none.
+func openServed(ctx context.Context, cfg *config.Config, vol *config.Volume, runtime string) (*openVolume, error) {
+	passphrase, err := cfg.VolumePassphrase(ctx, vol)
+	if errors.Is(err, config.ErrNoPassphrase) {
+		if runtime == "" {
+			return nil, errVolumeLocked
+		}
+		passphrase, err = runtime, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return openWithPassphrase(ctx, cfg, vol, passphrase)
+}
+
+// unlock opens a volume that has no passphrase configured, with the
+// given passphrase.
+func (sv *servedVolume) unlock(ctx context.Context, filesys *PlopFS, passphrase string) error {
+	sv.mu.Lock()
+	if sv.opened != nil {
+		sv.mu.Unlock()
+		return nil
+	}
+	sv.passphrase = passphrase
+	sv.mu.Unlock()
+	if _, err := sv.open(ctx, filesys); err != nil {
+		sv.mu.Lock()
+		if sv.passphrase == passphrase {
+			sv.passphrase = ""
+		}
+		sv.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func openWithPassphrase(ctx context.Context, cfg *config.Config, vol *config.Volume, passphrase string) (*openVolume, error) {
+	store, buckets, err := config.OpenVolumeWithPassphrase(ctx, cfg, vol, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	ov := &openVolume{
+		name:    vol.Name,
+		store:   store,
+		buckets: buckets,
+	}
+	c, err := config.OpenCatalog(cfg, vol)
+	if err != nil {
+		closeBuckets(buckets)
+		return nil, err
+	}
+	if c != nil {
+		ov.catalog = &volumeCatalog{
+			catalog: c,
+			sync:    vol.Catalog.Sync,
+		}
+	}
+	return ov, nil
+}
+
+func closeBuckets(buckets []*blob.Bucket) {
+	for _, b := range buckets {
+		_ = b.Close()
+	}
+}
+
+// Update changes the served volumes and settings to match cfg.
+// Volumes whose storage settings did not change are kept as is,
+// including any passphrase given at runtime. Files already open keep
+// using the volume they were opened from.
+//
+// Mountpoints and FUSE mount options cannot be changed without
+// remounting.
+func (f *PlopFS) Update(cfg *config.Config) error {
+	f.updateMu.Lock()
+	defer f.updateMu.Unlock()
+
+	f.mu.Lock()
+	oldCfg := f.cfg
+	old := f.volumes
+	f.mu.Unlock()
+
+	// Compare volumes without holding the filesystem lock, which
+	// every request takes.
+	volumes := make(map[string]*servedVolume, len(cfg.Volumes))
+	for _, vol := range cfg.Volumes {
+		if !served(cfg, vol) {
+			continue
+		}
+		if sv, ok := old[vol.Name]; ok && sv.keep(f, oldCfg, cfg, vol) {
+			volumes[vol.Name] = sv
+			continue
+		}
+		volumes[vol.Name] = &servedVolume{
+			name:   vol.Name,
+			cfg:    cfg,
+			config: vol,
+		}
+	}
+	exposed := make(map[string]struct{})
+	for _, vol := range cfg.MountOptions().Volumes {
+		exposed[vol.Name] = struct{}{}
+	}
+	f.mu.Lock()
+	f.cfg = cfg
+	f.exposed = exposed
+	f.volumes = volumes
+	f.mu.Unlock()
+
+	var retired []*blob.Bucket
+	for name, sv := range old {
+		if volumes[name] == sv {
+			continue
+		}
+		retired = append(retired, sv.retire()...)
+	}
+	f.mu.Lock()
+	f.retired = append(f.retired, retired...)
+	f.mu.Unlock()
+	return nil
+}
+
+// retire marks the volume as no longer served, and returns the
+// buckets it had open. An open in progress closes its buckets when
+// done.
+func (sv *servedVolume) retire() []*blob.Bucket {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	sv.retired = true
+	if sv.opened == nil {
+		return nil
+	}
+	return sv.opened.buckets
+}
+
+// keep updates the volume to a new configuration, if that does not
+// change its storage. It reports whether the volume was kept.
+func (sv *servedVolume) keep(filesys *PlopFS, oldCfg *config.Config, cfg *config.Config, vol *config.Volume) bool {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	if !config.SameStore(oldCfg, sv.config, cfg, vol) {
+		return false
+	}
+	sv.cfg = cfg
+	sv.config = vol
+	if ov := sv.opened; ov != nil && vol.Incoming != (ov.incoming != nil) {
+		// files already open keep the old state
+		changed := *ov
+		changed.incoming = nil
+		if vol.Incoming {
+			changed.incoming = newIncoming(filesys, ov.name, ov.store)
+		}
+		sv.opened = &changed
+	}
+	return true
 }
 
 func (f *PlopFS) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	var errs []error
-	for name, bs := range f.buckets {
-		for i, b := range bs {
+	for name, sv := range f.volumes {
+		for i, b := range sv.retire() {
 			if err := b.Close(); err != nil {
 				err = fmt.Errorf("error closing bucket #%d for %q: %w", i, name, err)
 				errs = append(errs, err)
 			}
 		}
 	}
+	for _, b := range f.retired {
+		if err := b.Close(); err != nil {
+			err = fmt.Errorf("error closing retired bucket: %w", err)
+			errs = append(errs, err)
+		}
+	}
 	if len(errs) > 0 {
 		return multierr.New(errs)
 	}
@@ -65,31 +385,96 @@ func (f *PlopFS) Root() (fs.Node, error) {
 	return n, nil
 }
 
-func Mount(cfg *config.Config) error {
-	c, err := fuse.Mount(cfg.MountPoint,
-		fuse.Subtype("plopfs"),
-		fuse.ReadOnly(),
-		fuse.AsyncRead(),
-		fuse.MaxReadahead(8*1024*1024),
-		fuse.DefaultPermissions(),
-	)
-	if err != nil {
-		return err
+// setOwner sets the configured ownership in a.
+func (f *PlopFS) setOwner(a *fuse.Attr) {
+	opts := f.mountOptions()
+	a.Uid = opts.UID
+	a.Gid = opts.GID
+}
+
+// dirAttr sets the configured mode and ownership of directories in
+// a.
+func (f *PlopFS) dirAttr(a *fuse.Attr) {
+	a.Mode = os.ModeDir | f.mountOptions().DirMode
+	f.setOwner(a)
+}
+
+// fileAttr sets the configured mode and ownership of files in a.
+func (f *PlopFS) fileAttr(a *fuse.Attr) {
+	a.Mode = f.mountOptions().FileMode
+	f.setOwner(a)
+}
+
+func (f *PlopFS) mountOptions() *config.MountOptions {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cfg.MountOptions()
+}
+
+// isExposed reports whether the volume is visible at the root of the
+// main mount.
+func (f *PlopFS) isExposed(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.exposed[name]
+	return ok
+}
+
+// exposedNames returns the names of volumes visible at the root of
+// the main mount.
+func (f *PlopFS) exposedNames() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, 0, len(f.exposed))
+	for name := range f.exposed {
+		names = append(names, name)
 	}
-	defer c.Close()
+	return names
+}
 
-	filesys, err := New(cfg)
-	if err != nil {
-		return err
+// open returns the named volume, opening it if needed.
+func (f *PlopFS) open(ctx context.Context, name string) (*openVolume, error) {
+	f.mu.Lock()
+	sv, ok := f.volumes[name]
+	f.mu.Unlock()
+	if !ok {
+		return nil, errVolumeRetired
 	}
-	defer func() {
-		if err := filesys.Close(); err != nil {
-			log.Printf("error closing filesystem: %v", err)
-		}
-	}()
+	return sv.open(ctx, f)
+}
 
-	if err := fs.Serve(c, filesys); err != nil {
-		return err
+// served returns the named volume, without opening it.
+func (f *PlopFS) served(name string) (_ *servedVolume, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sv, ok := f.volumes[name]
+	return sv, ok
+}
+
+// volume returns the directory node for the named volume.
+func (f *PlopFS) volume(name string) (_ *Volume, ok bool) {
+	if _, ok := f.served(name); !ok {
+		return nil, false
 	}
-	return nil
+	n := &Volume{
+		fs:   f,
+		name: name,
+	}
+	return n, true
+}
+
+// volumeFS serves a single volume as the root of a mount.
+type volumeFS struct {
+	fs   *PlopFS
+	name string
+}
+
+var _ = fs.FS(&volumeFS{})
+
+func (v *volumeFS) Root() (fs.Node, error) {
+	n, ok := v.fs.volume(v.name)
+	if !ok {
+		return nil, fmt.Errorf("volume not open: %q", v.name)
+	}
+	return n, nil
 }
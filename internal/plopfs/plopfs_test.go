@@ -3,11 +3,13 @@ package plopfs_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
 
@@ -15,11 +17,14 @@ import (
 	"bazil.org/fuse/fs/fstestutil/spawntest"
 	"bazil.org/fuse/fs/fstestutil/spawntest/httpjson"
 	"bazil.org/plop/cas"
+	"bazil.org/plop/internal/catalog"
 	"bazil.org/plop/internal/config"
+	"bazil.org/plop/internal/daemon"
 	"bazil.org/plop/internal/plopfs"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"gocloud.dev/blob/fileblob"
+	"golang.org/x/sys/unix"
 )
 
 var helpers spawntest.Registry
@@ -47,6 +52,54 @@ func withMount(t testing.TB, configText string, fn func(mntpath string)) {
 	fn(mnt.Dir)
 }
 
+// withControl mounts like withMount, with the mount options of a
+// production mount, and serves the volumes of the mount on a control
+// socket.
+func withControl(t testing.TB, configText string, fn func(mntpath string, filesys *plopfs.PlopFS, client *daemon.Client)) {
+	t.Helper()
+	cfg, err := config.ParseConfig("<test literal>.hcl", []byte(configText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filesys, err := plopfs.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := filesys.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	mnt, err := fstestutil.MountedT(t, filesys, nil, plopfs.MountOptions(cfg)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mnt.Close()
+
+	socket := filepath.Join(tempDir(t), "control.sock")
+	l, err := daemon.Listen(socket)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	srv := daemon.NewServer(filesys)
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(l)
+	}()
+	defer func() {
+		_ = l.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	}()
+	client, err := daemon.Dial(socket)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	fn(mnt.Dir, filesys, client)
+}
+
 func writeBlob(store *cas.Store, data []byte) (string, error) {
 	ctx := context.Background()
 	key, err := store.Create(ctx, bytes.NewReader(data))
@@ -269,7 +322,58 @@ volume "testvolume" {
 		if err := control.JSON("/").Call(ctx, p, &got); err != nil {
 			t.Fatalf("calling helper: %v", err)
 		}
-		wantEntries := []readdirEntry{}
+		wantEntries := []readdirEntry{
+			{Name: "refs", Mode: os.ModeDir | 0o555},
+		}
+		if diff := cmp.Diff(got.Entries, wantEntries); diff != "" {
+			t.Errorf("wrong readdir entries (-got +want)\n%s", diff)
+		}
+	})
+}
+
+func TestVolumeReaddirCatalog(t *testing.T) {
+	tmp := tempDir(t)
+	bucket, err := fileblob.OpenBucket(tmp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+	catalogPath := filepath.Join(tempDir(t), "catalog.jsonl")
+	store := cas.NewStore("s3kr1t",
+		cas.WithBucket(bucket),
+		cas.WithCatalog(catalog.Open(catalogPath)),
+	)
+	const greeting = "hello, world\n"
+	key := mustWriteBlob(t, store, []byte(greeting))
+
+	config := fmt.Sprintf(`
+mountpoint = "/does-not-exist"
+default_volume = "testvolume"
+volume "testvolume" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = %q
+  }
+  catalog {
+    path = %q
+  }
+}
+`, "file://"+tmp, catalogPath)
+
+	withMount(t, config, func(mntpath string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		control := readdirHelper.Spawn(ctx, t)
+		defer control.Close()
+		p := filepath.Join(mntpath, "testvolume")
+		var got readdirResult
+		if err := control.JSON("/").Call(ctx, p, &got); err != nil {
+			t.Fatalf("calling helper: %v", err)
+		}
+		wantEntries := []readdirEntry{
+			{Name: key, Mode: 0o444},
+			{Name: "refs", Mode: os.ModeDir | 0o555},
+		}
 		if diff := cmp.Diff(got.Entries, wantEntries); diff != "" {
 			t.Errorf("wrong readdir entries (-got +want)\n%s", diff)
 		}
@@ -427,3 +531,994 @@ volume "testvolume" {
 		}
 	})
 }
+
+func doReadlink(ctx context.Context, path string) (string, error) {
+	return os.Readlink(path)
+}
+
+var readlinkHelper = helpers.Register("readlink", httpjson.ServePOST(doReadlink))
+
+func TestRefs(t *testing.T) {
+	tmp := tempDir(t)
+	bucket, err := fileblob.OpenBucket(tmp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+	store := cas.NewStore("s3kr1t", cas.WithBucket(bucket))
+	const greeting = "hello, world\n"
+	key := mustWriteBlob(t, store, []byte(greeting))
+	if _, err := store.SetRef(context.Background(), "greeting", key, 0); err != nil {
+		t.Fatalf("SetRef: %v", err)
+	}
+
+	config := fmt.Sprintf(`
+mountpoint = "/does-not-exist"
+default_volume = "testvolume"
+volume "testvolume" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = %q
+  }
+}
+`, "file://"+tmp)
+
+	withMount(t, config, func(mntpath string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		t.Run("readlink", func(t *testing.T) {
+			control := readlinkHelper.Spawn(ctx, t)
+			defer control.Close()
+			p := filepath.Join(mntpath, "testvolume", "refs", "greeting")
+			var got string
+			if err := control.JSON("/").Call(ctx, p, &got); err != nil {
+				t.Fatalf("calling helper: %v", err)
+			}
+			if g, e := got, "../"+key; g != e {
+				t.Errorf("wrong symlink target: %q != %q", g, e)
+			}
+		})
+
+		t.Run("readdir", func(t *testing.T) {
+			control := readdirHelper.Spawn(ctx, t)
+			defer control.Close()
+			p := filepath.Join(mntpath, "testvolume", "refs")
+			var got readdirResult
+			if err := control.JSON("/").Call(ctx, p, &got); err != nil {
+				t.Fatalf("calling helper: %v", err)
+			}
+			wantEntries := []readdirEntry{
+				{Name: "greeting", Mode: os.ModeSymlink | 0o444},
+			}
+			if diff := cmp.Diff(got.Entries, wantEntries); diff != "" {
+				t.Errorf("wrong readdir entries (-got +want)\n%s", diff)
+			}
+		})
+
+		t.Run("read", func(t *testing.T) {
+			control := readFstatHelper.Spawn(ctx, t)
+			defer control.Close()
+			p := filepath.Join(mntpath, "testvolume", "refs", "greeting")
+			var got readFstatResult
+			if err := control.JSON("/").Call(ctx, p, &got); err != nil {
+				t.Fatalf("calling helper: %v", err)
+			}
+			if g, e := string(got.Content), greeting; g != e {
+				t.Errorf("wrong content: %q != %q", g, e)
+			}
+		})
+	})
+}
+
+func TestSidecar(t *testing.T) {
+	tmp := tempDir(t)
+	bucket, err := fileblob.OpenBucket(tmp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+	store := cas.NewStore("s3kr1t", cas.WithBucket(bucket))
+	const greeting = "hello, world\n"
+	key := mustWriteBlob(t, store, []byte(greeting))
+	h, err := store.Open(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	ext, err := h.IO(context.Background()).ExtentAt(0)
+	if err != nil {
+		t.Fatalf("ExtentAt: %v", err)
+	}
+	boxedKey, err := store.DebugBoxKey(key)
+	if err != nil {
+		t.Fatalf("DebugBoxKey: %v", err)
+	}
+
+	bucketURL := "file://" + tmp
+	config := fmt.Sprintf(`
+mountpoint = "/does-not-exist"
+default_volume = "testvolume"
+volume "testvolume" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = %q
+  }
+}
+`, bucketURL)
+
+	withMount(t, config, func(mntpath string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		control := readFstatHelper.Spawn(ctx, t)
+		defer control.Close()
+
+		t.Run("extents", func(t *testing.T) {
+			p := filepath.Join(mntpath, "testvolume", key+".extents")
+			var got readFstatResult
+			if err := control.JSON("/").Call(ctx, p, &got); err != nil {
+				t.Fatalf("calling helper: %v", err)
+			}
+			want := fmt.Sprintf("%s\t0\t%d\n", ext.Key(), len(greeting))
+			if g, e := string(got.Content), want; g != e {
+				t.Errorf("wrong extents: %q != %q", g, e)
+			}
+		})
+
+		t.Run("json", func(t *testing.T) {
+			p := filepath.Join(mntpath, "testvolume", key+".json")
+			var got readFstatResult
+			if err := control.JSON("/").Call(ctx, p, &got); err != nil {
+				t.Fatalf("calling helper: %v", err)
+			}
+			type metadata struct {
+				Key      string   `json:"key"`
+				Size     int64    `json:"size"`
+				Extents  int      `json:"extents"`
+				BoxedKey string   `json:"boxed_key"`
+				Buckets  []string `json:"buckets"`
+			}
+			var meta metadata
+			if err := json.Unmarshal(got.Content, &meta); err != nil {
+				t.Fatalf("bad JSON: %v: %q", err, got.Content)
+			}
+			want := metadata{
+				Key:      key,
+				Size:     int64(len(greeting)),
+				Extents:  1,
+				BoxedKey: boxedKey,
+				Buckets:  []string{bucketURL},
+			}
+			if diff := cmp.Diff(meta, want); diff != "" {
+				t.Errorf("wrong metadata (-got +want)\n%s", diff)
+			}
+		})
+
+		t.Run("not exist", func(t *testing.T) {
+			control := checkNotExistHelper.Spawn(ctx, t)
+			defer control.Close()
+			p := filepath.Join(mntpath, "testvolume", "ne5em96397gwhy4cow3jmifggc7ssewzbfaiaao77kq3ea83n5cy.json")
+			var nothing struct{}
+			if err := control.JSON("/").Call(ctx, p, &nothing); err != nil {
+				t.Fatalf("calling helper: %v", err)
+			}
+		})
+	})
+}
+
+type writeIncomingRequest struct {
+	Path    string
+	Content []byte
+	// Dup closes a duplicate of the file descriptor halfway through
+	// writing, as forking shells do.
+	Dup bool
+}
+
+type writeIncomingResult struct {
+	Target string
+	Xattr  string
+	// LinkXattrs are the attributes listed on the link itself.
+	LinkXattrs []string
+}
+
+func doWriteIncoming(ctx context.Context, req writeIncomingRequest) (*writeIncomingResult, error) {
+	f, err := os.Create(req.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	content := req.Content
+	if req.Dup {
+		half := len(content) / 2
+		if _, err := f.Write(content[:half]); err != nil {
+			return nil, err
+		}
+		fd, err := unix.Dup(int(f.Fd()))
+		if err != nil {
+			return nil, fmt.Errorf("dup: %w", err)
+		}
+		if err := unix.Close(fd); err != nil {
+			return nil, fmt.Errorf("close dup: %w", err)
+		}
+		content = content[half:]
+	}
+	if _, err := f.Write(content); err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	target, err := os.Readlink(req.Path)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1024)
+	n, err := unix.Getxattr(req.Path, "user.plop.key", buf)
+	if err != nil {
+		return nil, fmt.Errorf("getxattr: %w", err)
+	}
+	linkXattrs, err := doLinkXattrNames(req.Path)
+	if err != nil {
+		return nil, err
+	}
+	r := &writeIncomingResult{
+		Target:     target,
+		Xattr:      string(buf[:n]),
+		LinkXattrs: linkXattrs,
+	}
+	return r, nil
+}
+
+var writeIncomingHelper = helpers.Register("writeIncoming", httpjson.ServePOST(doWriteIncoming))
+
+func TestIncoming(t *testing.T) {
+	tmp := tempDir(t)
+	config := fmt.Sprintf(`
+mountpoint = "/does-not-exist"
+default_volume = "testvolume"
+volume "testvolume" {
+  passphrase = "s3kr1t"
+  incoming = true
+  bucket {
+    url = %q
+  }
+}
+`, "file://"+tmp)
+
+	const greeting = "hello, world\n"
+	withMount(t, config, func(mntpath string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		control := writeIncomingHelper.Spawn(ctx, t)
+		defer control.Close()
+		bucket, err := fileblob.OpenBucket(tmp, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bucket.Close()
+		store := cas.NewStore("s3kr1t", cas.WithBucket(bucket))
+
+		for _, tc := range []struct {
+			name string
+			dup  bool
+		}{
+			{"close", false},
+			// closing a duplicate must not end the upload
+			{"dup", true},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				req := writeIncomingRequest{
+					Path:    filepath.Join(mntpath, "testvolume", "incoming", tc.name+".txt"),
+					Content: []byte(greeting),
+					Dup:     tc.dup,
+				}
+				var got writeIncomingResult
+				if err := control.JSON("/").Call(ctx, req, &got); err != nil {
+					t.Fatalf("calling helper: %v", err)
+				}
+				if g, e := got.Target, "../"+got.Xattr; g != e {
+					t.Errorf("wrong symlink target: %q != %q", g, e)
+				}
+				if diff := cmp.Diff(got.LinkXattrs, []string{"user.plop.key", "user.plop.volume"}); diff != "" {
+					t.Errorf("wrong link xattrs (-got +want)\n%s", diff)
+				}
+				h, err := store.Open(ctx, got.Xattr)
+				if err != nil {
+					t.Fatalf("Open: %v", err)
+				}
+				content, err := io.ReadAll(h.IO(ctx))
+				if err != nil {
+					t.Fatalf("read: %v", err)
+				}
+				if g, e := string(content), greeting; g != e {
+					t.Errorf("wrong content: %q != %q", g, e)
+				}
+			})
+		}
+	})
+}
+
+// doXattrs returns all extended attributes of a path.
+func doXattrs(ctx context.Context, path string) (map[string]string, error) {
+	buf := make([]byte, 64*1024)
+	n, err := unix.Listxattr(path, buf)
+	if err != nil {
+		return nil, fmt.Errorf("listxattr: %w", err)
+	}
+	attrs := make(map[string]string)
+	for _, name := range bytes.Split(buf[:n], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value := make([]byte, 64*1024)
+		n, err := unix.Getxattr(path, string(name), value)
+		if err != nil {
+			return nil, fmt.Errorf("getxattr %s: %w", name, err)
+		}
+		attrs[string(name)] = string(value[:n])
+	}
+	return attrs, nil
+}
+
+var xattrsHelper = helpers.Register("xattrs", httpjson.ServePOST(doXattrs))
+
+func TestXattr(t *testing.T) {
+	tmp := tempDir(t)
+	bucket, err := fileblob.OpenBucket(tmp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+	catalogPath := filepath.Join(tempDir(t), "catalog.jsonl")
+	store := cas.NewStore("s3kr1t",
+		cas.WithBucket(bucket),
+		cas.WithCatalog(catalog.Open(catalogPath)),
+	)
+	const greeting = "hello, world\n"
+	key, err := store.Create(context.Background(), strings.NewReader(greeting),
+		cas.CreateSource("/tmp/greeting.txt"),
+		cas.CreateLabels(map[string]string{"lang": "en"}),
+	)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	boxedKey, err := store.DebugBoxKey(key)
+	if err != nil {
+		t.Fatalf("DebugBoxKey: %v", err)
+	}
+
+	config := fmt.Sprintf(`
+mountpoint = "/does-not-exist"
+default_volume = "testvolume"
+volume "testvolume" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = %q
+  }
+  catalog {
+    path = %q
+  }
+}
+`, "file://"+tmp, catalogPath)
+
+	withMount(t, config, func(mntpath string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		control := xattrsHelper.Spawn(ctx, t)
+		defer control.Close()
+
+		t.Run("file", func(t *testing.T) {
+			p := filepath.Join(mntpath, "testvolume", key)
+			var got map[string]string
+			if err := control.JSON("/").Call(ctx, p, &got); err != nil {
+				t.Fatalf("calling helper: %v", err)
+			}
+			want := map[string]string{
+				"user.plop.key":        key,
+				"user.plop.volume":     "testvolume",
+				"user.plop.boxed_key":  boxedKey,
+				"user.plop.extents":    "1",
+				"user.plop.source":     "/tmp/greeting.txt",
+				"user.plop.label.lang": "en",
+			}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("wrong xattrs (-got +want)\n%s", diff)
+			}
+		})
+
+		t.Run("volume", func(t *testing.T) {
+			p := filepath.Join(mntpath, "testvolume")
+			var got map[string]string
+			if err := control.JSON("/").Call(ctx, p, &got); err != nil {
+				t.Fatalf("calling helper: %v", err)
+			}
+			want := map[string]string{
+				"user.plop.volume": "testvolume",
+			}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("wrong xattrs (-got +want)\n%s", diff)
+			}
+		})
+	})
+}
+
+type ownerResult struct {
+	Mode os.FileMode
+	UID  uint32
+	GID  uint32
+}
+
+func doOwner(ctx context.Context, path string) (*ownerResult, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	r := &ownerResult{
+		Mode: fi.Mode(),
+		UID:  st.Uid,
+		GID:  st.Gid,
+	}
+	return r, nil
+}
+
+var ownerHelper = helpers.Register("owner", httpjson.ServePOST(doOwner))
+
+func TestMountConfig(t *testing.T) {
+	tmp := tempDir(t)
+	bucket, err := fileblob.OpenBucket(tmp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+	store := cas.NewStore("s3kr1t", cas.WithBucket(bucket))
+	key := mustWriteBlob(t, store, []byte("hello, world\n"))
+
+	config := fmt.Sprintf(`
+mountpoint = "/does-not-exist"
+mount {
+  uid = 1234
+  gid = 5678
+  file_mode = "0440"
+  dir_mode = "0750"
+  volumes = ["testvolume"]
+}
+volume "testvolume" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = %q
+  }
+}
+volume "hidden" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = %q
+  }
+}
+`, "file://"+tmp, "file://"+tmp)
+
+	withMount(t, config, func(mntpath string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		t.Run("owner", func(t *testing.T) {
+			control := ownerHelper.Spawn(ctx, t)
+			defer control.Close()
+			for _, tc := range []struct {
+				path string
+				mode os.FileMode
+			}{
+				{mntpath, os.ModeDir | 0o750},
+				{filepath.Join(mntpath, "testvolume"), os.ModeDir | 0o750},
+				{filepath.Join(mntpath, "testvolume", key), 0o440},
+			} {
+				var got ownerResult
+				if err := control.JSON("/").Call(ctx, tc.path, &got); err != nil {
+					t.Fatalf("calling helper: %v", err)
+				}
+				want := ownerResult{
+					Mode: tc.mode,
+					UID:  1234,
+					GID:  5678,
+				}
+				if diff := cmp.Diff(got, want); diff != "" {
+					t.Errorf("wrong owner for %s (-got +want)\n%s", tc.path, diff)
+				}
+			}
+		})
+
+		t.Run("volumes", func(t *testing.T) {
+			control := readdirHelper.Spawn(ctx, t)
+			defer control.Close()
+			var got readdirResult
+			if err := control.JSON("/").Call(ctx, mntpath, &got); err != nil {
+				t.Fatalf("calling helper: %v", err)
+			}
+			wantEntries := []readdirEntry{
+				{Name: "testvolume", Mode: os.ModeDir | 0o750},
+			}
+			if diff := cmp.Diff(got.Entries, wantEntries); diff != "" {
+				t.Errorf("wrong readdir entries (-got +want)\n%s", diff)
+			}
+		})
+
+		t.Run("hidden", func(t *testing.T) {
+			control := checkNotExistHelper.Spawn(ctx, t)
+			defer control.Close()
+			p := filepath.Join(mntpath, "hidden")
+			var got struct{}
+			if err := control.JSON("/").Call(ctx, p, &got); err != nil {
+				t.Fatalf("calling helper: %v", err)
+			}
+		})
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	tmp := tempDir(t)
+	configText := func(volumes ...string) string {
+		var buf bytes.Buffer
+		buf.WriteString(`mountpoint = "/does-not-exist"` + "\n")
+		for _, name := range volumes {
+			fmt.Fprintf(&buf, `
+volume %q {
+  passphrase = "s3kr1t"
+  bucket {
+    url = %q
+  }
+}
+`, name, "file://"+tmp)
+		}
+		return buf.String()
+	}
+	parse := func(text string) *config.Config {
+		t.Helper()
+		cfg, err := config.ParseConfig("<test literal>.hcl", []byte(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+
+	filesys, err := plopfs.New(parse(configText("one", "two")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := filesys.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	mnt, err := fstestutil.MountedT(t, filesys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mnt.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	readdir := readdirHelper.Spawn(ctx, t)
+	defer readdir.Close()
+	checkVolumes := func(t *testing.T, names ...string) {
+		t.Helper()
+		var got readdirResult
+		if err := readdir.JSON("/").Call(ctx, mnt.Dir, &got); err != nil {
+			t.Fatalf("calling helper: %v", err)
+		}
+		var want []readdirEntry
+		for _, name := range names {
+			want = append(want, readdirEntry{Name: name, Mode: os.ModeDir | 0o555})
+		}
+		if diff := cmp.Diff(got.Entries, want); diff != "" {
+			t.Errorf("wrong readdir entries (-got +want)\n%s", diff)
+		}
+	}
+
+	checkVolumes(t, "one", "two")
+	if err := filesys.Update(parse(configText("one", "three"))); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	checkVolumes(t, "one", "three")
+
+	notExist := checkNotExistHelper.Spawn(ctx, t)
+	defer notExist.Close()
+	var got struct{}
+	if err := notExist.JSON("/").Call(ctx, filepath.Join(mnt.Dir, "two", "refs"), &got); err != nil {
+		t.Fatalf("calling helper: %v", err)
+	}
+}
+
+// errno returns the error number of err, or 0 for success.
+func errno(err error) syscall.Errno {
+	var e syscall.Errno
+	if errors.As(err, &e) {
+		return e
+	}
+	if err != nil {
+		return syscall.EIO
+	}
+	return 0
+}
+
+func doStatErrno(ctx context.Context, path string) (syscall.Errno, error) {
+	_, err := os.Stat(path)
+	return errno(err), nil
+}
+
+var statErrnoHelper = helpers.Register("statErrno", httpjson.ServePOST(doStatErrno))
+
+type writeFileRequest struct {
+	Path    string
+	Content []byte
+}
+
+func doWriteFileErrno(ctx context.Context, req writeFileRequest) (syscall.Errno, error) {
+	f, err := os.OpenFile(req.Path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return errno(err), nil
+	}
+	defer f.Close()
+	if _, err := f.Write(req.Content); err != nil {
+		return errno(err), nil
+	}
+	return errno(f.Close()), nil
+}
+
+var writeFileErrnoHelper = helpers.Register("writeFileErrno", httpjson.ServePOST(doWriteFileErrno))
+
+func TestLazyOpen(t *testing.T) {
+	tmp := tempDir(t)
+	config := fmt.Sprintf(`
+mountpoint = "/does-not-exist"
+volume "good" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = %q
+  }
+}
+volume "broken" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = "nosuchscheme://bucket"
+  }
+}
+`, "file://"+tmp)
+
+	withMount(t, config, func(mntpath string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		control := statErrnoHelper.Spawn(ctx, t)
+		defer control.Close()
+		for _, tc := range []struct {
+			name string
+			want syscall.Errno
+		}{
+			{"good", 0},
+			{"broken", syscall.EIO},
+		} {
+			var got syscall.Errno
+			if err := control.JSON("/").Call(ctx, filepath.Join(mntpath, tc.name), &got); err != nil {
+				t.Fatalf("calling helper: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("wrong error for %s: %v != %v", tc.name, got, tc.want)
+			}
+		}
+	})
+}
+
+func TestUnlock(t *testing.T) {
+	tmp := tempDir(t)
+	bucket, err := fileblob.OpenBucket(tmp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+	store := cas.NewStore("s3kr1t", cas.WithBucket(bucket))
+	if err := store.InitVolume(context.Background()); err != nil {
+		t.Fatalf("InitVolume: %v", err)
+	}
+	key := mustWriteBlob(t, store, []byte("hello, world\n"))
+
+	config := fmt.Sprintf(`
+mountpoint = "/does-not-exist"
+volume "testvolume" {
+  bucket {
+    url = %q
+  }
+}
+`, "file://"+tmp)
+
+	withMount(t, config, func(mntpath string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stat := statErrnoHelper.Spawn(ctx, t)
+		defer stat.Close()
+		write := writeFileErrnoHelper.Spawn(ctx, t)
+		defer write.Close()
+
+		checkStat := func(t *testing.T, want syscall.Errno) {
+			t.Helper()
+			var got syscall.Errno
+			p := filepath.Join(mntpath, "testvolume", key)
+			if err := stat.JSON("/").Call(ctx, p, &got); err != nil {
+				t.Fatalf("calling helper: %v", err)
+			}
+			if got != want {
+				t.Errorf("wrong stat error: %v != %v", got, want)
+			}
+		}
+		unlock := func(t *testing.T, passphrase string, want syscall.Errno) {
+			t.Helper()
+			req := writeFileRequest{
+				Path:    filepath.Join(mntpath, ".unlock", "testvolume"),
+				Content: []byte(passphrase + "\n"),
+			}
+			var got syscall.Errno
+			if err := write.JSON("/").Call(ctx, req, &got); err != nil {
+				t.Fatalf("calling helper: %v", err)
+			}
+			if got != want {
+				t.Errorf("wrong unlock error: %v != %v", got, want)
+			}
+		}
+
+		checkStat(t, syscall.EACCES)
+		unlock(t, "hunter2", syscall.EACCES)
+		checkStat(t, syscall.EACCES)
+		unlock(t, "s3kr1t", 0)
+		checkStat(t, 0)
+	})
+}
+
+func doReadFileErrno(ctx context.Context, path string) (syscall.Errno, error) {
+	_, err := os.ReadFile(path)
+	return errno(err), nil
+}
+
+var readFileErrnoHelper = helpers.Register("readFileErrno", httpjson.ServePOST(doReadFileErrno))
+
+func TestReadCorrupt(t *testing.T) {
+	tmp := tempDir(t)
+	bucket, err := fileblob.OpenBucket(tmp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+	store := cas.NewStore("s3kr1t", cas.WithBucket(bucket))
+	const greeting = "hello, world\n"
+	key := mustWriteBlob(t, store, []byte(greeting))
+
+	// corrupt the content, but not the list of extents
+	ctx := context.Background()
+	h, err := store.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	ext, err := h.IO(ctx).ExtentAt(0)
+	if err != nil {
+		t.Fatalf("ExtentAt: %v", err)
+	}
+	boxedKey, err := store.DebugBoxKey(ext.Key())
+	if err != nil {
+		t.Fatalf("DebugBoxKey: %v", err)
+	}
+	blobPath := filepath.Join(tmp, boxedKey)
+	buf, err := os.ReadFile(blobPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf[len(buf)/2] ^= 0x01
+	if err := os.WriteFile(blobPath, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := fmt.Sprintf(`
+mountpoint = "/does-not-exist"
+default_volume = "testvolume"
+volume "testvolume" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = %q
+  }
+}
+`, "file://"+tmp)
+
+	withMount(t, config, func(mntpath string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		control := readFileErrnoHelper.Spawn(ctx, t)
+		defer control.Close()
+
+		p := filepath.Join(mntpath, "testvolume", key)
+		var got syscall.Errno
+		if err := control.JSON("/").Call(ctx, p, &got); err != nil {
+			t.Fatalf("calling helper: %v", err)
+		}
+		if g, e := got, syscall.EIO; g != e {
+			t.Errorf("wrong errno: %v != %v", g, e)
+		}
+	})
+}
+
+func TestControl(t *testing.T) {
+	tmp := tempDir(t)
+	config := fmt.Sprintf(`
+mountpoint = "/does-not-exist"
+volume "testvolume" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = %q
+  }
+}
+`, "file://"+tmp)
+
+	withControl(t, config, func(mntpath string, filesys *plopfs.PlopFS, client *daemon.Client) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if store := filesys.Stores()["testvolume"]; store != nil {
+			t.Fatal("volume open before use")
+		}
+		key, err := client.Create(ctx, "testvolume", strings.NewReader("hello, world\n"), "", nil)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if store := filesys.Stores()["testvolume"]; store == nil {
+			t.Error("control socket did not use the volume of the mount")
+		}
+		if err := client.OpenVolume(ctx, "nope"); !errors.Is(err, daemon.ErrNoVolume) {
+			t.Errorf("expected no volume error: %v", err)
+		}
+
+		stat := statErrnoHelper.Spawn(ctx, t)
+		defer stat.Close()
+		var got syscall.Errno
+		if err := stat.JSON("/").Call(ctx, filepath.Join(mntpath, "testvolume", key), &got); err != nil {
+			t.Fatalf("calling helper: %v", err)
+		}
+		if got != 0 {
+			t.Errorf("cannot stat created object: %v", got)
+		}
+	})
+}
+
+func TestUnlockControl(t *testing.T) {
+	tmp := tempDir(t)
+	bucket, err := fileblob.OpenBucket(tmp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+	store := cas.NewStore("s3kr1t", cas.WithBucket(bucket))
+	if err := store.InitVolume(context.Background()); err != nil {
+		t.Fatalf("InitVolume: %v", err)
+	}
+	key := mustWriteBlob(t, store, []byte("hello, world\n"))
+
+	// no incoming directory, so the mount is read-only
+	config := fmt.Sprintf(`
+mountpoint = "/does-not-exist"
+volume "testvolume" {
+  bucket {
+    url = %q
+  }
+}
+`, "file://"+tmp)
+
+	withControl(t, config, func(mntpath string, filesys *plopfs.PlopFS, client *daemon.Client) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stat := statErrnoHelper.Spawn(ctx, t)
+		defer stat.Close()
+		write := writeFileErrnoHelper.Spawn(ctx, t)
+		defer write.Close()
+
+		checkStat := func(t *testing.T, want syscall.Errno) {
+			t.Helper()
+			var got syscall.Errno
+			p := filepath.Join(mntpath, "testvolume", key)
+			if err := stat.JSON("/").Call(ctx, p, &got); err != nil {
+				t.Fatalf("calling helper: %v", err)
+			}
+			if got != want {
+				t.Errorf("wrong stat error: %v != %v", got, want)
+			}
+		}
+
+		checkStat(t, syscall.EACCES)
+		req := writeFileRequest{
+			Path:    filepath.Join(mntpath, ".unlock", "testvolume"),
+			Content: []byte("s3kr1t\n"),
+		}
+		var got syscall.Errno
+		if err := write.JSON("/").Call(ctx, req, &got); err != nil {
+			t.Fatalf("calling helper: %v", err)
+		}
+		if got != syscall.EROFS {
+			t.Errorf("expected read-only mount: %v", got)
+		}
+		if err := client.OpenVolume(ctx, "testvolume"); !errors.Is(err, daemon.ErrLocked) {
+			t.Errorf("expected locked error: %v", err)
+		}
+		if err := client.Unlock(ctx, "testvolume", "hunter2"); !errors.Is(err, cas.ErrWrongPassphrase) {
+			t.Errorf("expected wrong passphrase error: %v", err)
+		}
+		checkStat(t, syscall.EACCES)
+		if err := client.Unlock(ctx, "testvolume", "s3kr1t"); err != nil {
+			t.Fatalf("Unlock: %v", err)
+		}
+		checkStat(t, 0)
+	})
+}
+
+func TestControlStatus(t *testing.T) {
+	tmp := tempDir(t)
+	config := fmt.Sprintf(`
+mountpoint = "/does-not-exist"
+volume "testvolume" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = %q
+  }
+}
+volume "unused" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = %q
+  }
+}
+`, "file://"+tmp, "file://"+tmp)
+
+	withControl(t, config, func(mntpath string, filesys *plopfs.PlopFS, client *daemon.Client) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		// open the volume through the mount, not the control socket
+		stat := statErrnoHelper.Spawn(ctx, t)
+		defer stat.Close()
+		var got syscall.Errno
+		if err := stat.JSON("/").Call(ctx, filepath.Join(mntpath, "testvolume"), &got); err != nil {
+			t.Fatalf("calling helper: %v", err)
+		}
+		if got != 0 {
+			t.Fatalf("cannot stat volume: %v", got)
+		}
+
+		status, err := client.Status(ctx)
+		if err != nil {
+			t.Fatalf("Status: %v", err)
+		}
+		want := &daemon.Status{
+			Volumes: []daemon.VolumeStatus{
+				{
+					Name: "testvolume",
+					Open: true,
+					Buckets: []daemon.BucketStatus{
+						{Name: "file://" + tmp, State: "healthy"},
+					},
+				},
+				{Name: "unused"},
+			},
+		}
+		if diff := cmp.Diff(status, want); diff != "" {
+			t.Errorf("wrong status (-got +want)\n%s", diff)
+		}
+	})
+}
+
+// doLinkXattrNames lists the extended attributes of a symlink,
+// without following it. Linux does not let user attributes be read
+// from symlinks, only listed.
+func doLinkXattrNames(path string) ([]string, error) {
+	buf := make([]byte, 64*1024)
+	n, err := unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil, fmt.Errorf("llistxattr: %w", err)
+	}
+	var names []string
+	for _, name := range bytes.Split(buf[:n], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		names = append(names, string(name))
+	}
+	return names, nil
+}
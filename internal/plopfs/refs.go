@@ -0,0 +1,128 @@
+package plopfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"bazil.org/plop/cas"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// refsDirName is the name of the directory inside a volume that
+// exposes named refs as symlinks. It cannot collide with keys, as it
+// is not valid zbase32 of the right length.
+const refsDirName = "refs"
+
+// Refs can change at any time, so only cache them briefly.
+const refValid = 1 * time.Second
+
+type Refs struct {
+	fs     *PlopFS
+	volume string
+	store  *cas.Store
+}
+
+var _ = fs.Node(&Refs{})
+
+func (r *Refs) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Valid = refValid
+	r.fs.dirAttr(a)
+	return nil
+}
+
+var _ = fs.NodeRequestLookuper(&Refs{})
+
+func (r *Refs) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (_ fs.Node, err error) {
+	ctx, span := tracer.Start(ctx, "plopfs.Refs.Lookup", trace.WithAttributes(
+		attribute.String("plop.name", req.Name),
+	))
+	defer func() { endSpan(span, err) }()
+	var ref *cas.Ref
+	get := func(ctx context.Context) error {
+		var err error
+		ref, err = r.store.GetRef(ctx, req.Name)
+		if errors.Is(err, cas.ErrRefNotExist) {
+			return syscall.ENOENT
+		}
+		if errors.Is(err, cas.ErrBadRefName) {
+			return syscall.ENOENT
+		}
+		return err
+	}
+	if err := r.fs.request(ctx, "ref "+req.Name, get); err != nil {
+		return nil, err
+	}
+	if ref.Deleted() {
+		return nil, syscall.ENOENT
+	}
+	n := &RefLink{
+		fs:     r.fs,
+		volume: r.volume,
+		key:    ref.Key,
+	}
+	resp.EntryValid = refValid
+	return n, nil
+}
+
+var _ fs.HandleReadDirAller = (*Refs)(nil)
+
+func (r *Refs) ReadDirAll(ctx context.Context) (_ []fuse.Dirent, err error) {
+	ctx, span := tracer.Start(ctx, "plopfs.Refs.ReadDirAll")
+	defer func() { endSpan(span, err) }()
+	var refs []*cas.Ref
+	list := func(ctx context.Context) error {
+		var err error
+		refs, err = r.store.ListRefs(ctx)
+		return err
+	}
+	if err := r.fs.request(ctx, "refs", list); err != nil {
+		return nil, err
+	}
+	var res []fuse.Dirent
+	for _, ref := range refs {
+		if strings.Contains(ref.Name, "/") {
+			// cannot be represented as a directory entry
+			continue
+		}
+		res = append(res, fuse.Dirent{
+			Type: fuse.DT_Link,
+			Name: ref.Name,
+		})
+	}
+	return res, nil
+}
+
+// RefLink is a symlink pointing from a named ref to the object it
+// refers to.
+type RefLink struct {
+	fs     *PlopFS
+	volume string
+	key    string
+}
+
+var _ = fs.Node(&RefLink{})
+
+func (l *RefLink) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Valid = refValid
+	a.Mode = os.ModeSymlink | 0o444
+	l.fs.setOwner(a)
+	a.Size = uint64(len(l.target()))
+	return nil
+}
+
+func (l *RefLink) target() string {
+	return "../" + l.key
+}
+
+var _ = fs.NodeReadlinker(&RefLink{})
+
+func (l *RefLink) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	return l.target(), nil
+}
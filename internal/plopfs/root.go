@@ -2,7 +2,6 @@ package plopfs
 
 import (
 	"context"
-	"os"
 	"syscall"
 
 	"bazil.org/fuse"
@@ -17,22 +16,33 @@ var _ = fs.Node(&Root{})
 
 func (r *Root) Attr(ctx context.Context, a *fuse.Attr) error {
 	a.Valid = forever
-	a.Mode = os.ModeDir | 0o555
+	r.fs.dirAttr(a)
 	return nil
 }
 
 var _ = fs.NodeRequestLookuper(&Root{})
 
 func (r *Root) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
-	store, ok := r.fs.volumes[req.Name]
+	if req.Name == unlockDirName {
+		n := &Unlock{
+			fs: r.fs,
+		}
+		resp.EntryValid = refValid
+		return n, nil
+	}
+	if !r.fs.isExposed(req.Name) {
+		return nil, syscall.ENOENT
+	}
+	n, ok := r.fs.volume(req.Name)
 	if !ok {
 		return nil, syscall.ENOENT
 	}
-	n := &Volume{
-		fs:    r.fs,
-		store: store,
+	// open on first use, and report problems on this volume only
+	if _, err := n.open(ctx); err != nil {
+		return nil, err
 	}
-	resp.EntryValid = forever
+	// volumes can come and go with config reloads
+	resp.EntryValid = refValid
 	return n, nil
 }
 
@@ -40,7 +50,7 @@ var _ fs.HandleReadDirAller = (*Root)(nil)
 
 func (r *Root) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	var res []fuse.Dirent
-	for name := range r.fs.volumes {
+	for _, name := range r.fs.exposedNames() {
 		res = append(res, fuse.Dirent{
 			Type: fuse.DT_Dir,
 			Name: name,
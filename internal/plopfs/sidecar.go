@@ -0,0 +1,122 @@
+package plopfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"bazil.org/plop/cas"
+)
+
+// Sidecar files expose metadata about an object, next to it, as
+// KEY.SUFFIX.
+const (
+	sidecarExtents = ".extents"
+	sidecarJSON    = ".json"
+)
+
+// Bucket contents can change, so don't cache the JSON metadata for
+// long.
+const sidecarJSONValid = 1 * time.Minute
+
+// splitSidecar returns the key and suffix of a sidecar file name.
+func splitSidecar(name string) (key string, suffix string, ok bool) {
+	for _, suffix := range []string{sidecarExtents, sidecarJSON} {
+		if key, found := strings.CutSuffix(name, suffix); found && key != "" {
+			return key, suffix, true
+		}
+	}
+	return "", "", false
+}
+
+func extentsSidecar(ctx context.Context, h *cas.Handle) ([]byte, error) {
+	var buf bytes.Buffer
+	if h.NumExtents() == 0 {
+		return buf.Bytes(), nil
+	}
+	r := h.IO(ctx)
+	ext, err := r.ExtentAt(0)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		fmt.Fprintf(&buf, "%s\t%d\t%d\n", ext.Key(), ext.Start(), ext.End()-ext.Start())
+		next, ok := ext.Next()
+		if !ok {
+			break
+		}
+		ext = next
+	}
+	return buf.Bytes(), nil
+}
+
+type objectMetadata struct {
+	Key      string   `json:"key"`
+	Size     int64    `json:"size"`
+	Extents  int      `json:"extents"`
+	BoxedKey string   `json:"boxed_key"`
+	Buckets  []string `json:"buckets"`
+}
+
+func jsonSidecar(ctx context.Context, store *cas.Store, key string, h *cas.Handle) ([]byte, error) {
+	boxedKey, err := store.DebugBoxKey(key)
+	if err != nil {
+		return nil, err
+	}
+	buckets, err := store.Locate(ctx, key)
+	if err != nil {
+		// report what we know
+		log.Printf("cannot locate %s in all buckets: %v", key, err)
+	}
+	meta := objectMetadata{
+		Key:      key,
+		Size:     h.Size(),
+		Extents:  h.NumExtents(),
+		BoxedKey: boxedKey,
+		// avoid null in JSON
+		Buckets: append([]string{}, buckets...),
+	}
+	buf, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, '\n')
+	return buf, nil
+}
+
+// Sidecar is a read-only file with generated content.
+type Sidecar struct {
+	fs    *PlopFS
+	data  []byte
+	valid time.Duration
+}
+
+var _ = fs.Node(&Sidecar{})
+var _ = fs.Handle(&Sidecar{})
+
+func (s *Sidecar) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Valid = s.valid
+	s.fs.fileAttr(a)
+	a.Size = uint64(len(s.data))
+	return nil
+}
+
+var _ = fs.HandleReader(&Sidecar{})
+
+func (s *Sidecar) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	r := bytes.NewReader(s.data)
+	resp.Data = resp.Data[:req.Size]
+	n, err := r.ReadAt(resp.Data, req.Offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	resp.Data = resp.Data[:n]
+	return nil
+}
@@ -0,0 +1,161 @@
+package plopfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"bazil.org/plop/cas"
+)
+
+// unlockDirName is the name of the control directory at the root of
+// the mount, used to give passphrases of volumes that have none
+// configured. It is not listed in the root directory. Read-only
+// mounts cannot be written to, so passphrases are also taken over the
+// control socket, see PlopFS.Unlock.
+const unlockDirName = ".unlock"
+
+// maxPassphraseSize limits how much can be written to an unlock file.
+const maxPassphraseSize = 4096
+
+// Unlock is a directory with a write-only file for each volume.
+// Writing the passphrase of a volume to its file and closing it opens
+// the volume.
+type Unlock struct {
+	fs *PlopFS
+}
+
+var _ = fs.Node(&Unlock{})
+
+func (u *Unlock) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Valid = refValid
+	u.fs.dirAttr(a)
+	return nil
+}
+
+var _ = fs.NodeRequestLookuper(&Unlock{})
+
+func (u *Unlock) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	sv, ok := u.fs.served(req.Name)
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	n := &UnlockFile{
+		fs:     u.fs,
+		volume: sv,
+	}
+	resp.EntryValid = refValid
+	return n, nil
+}
+
+var _ fs.HandleReadDirAller = (*Unlock)(nil)
+
+func (u *Unlock) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	u.fs.mu.Lock()
+	defer u.fs.mu.Unlock()
+	res := make([]fuse.Dirent, 0, len(u.fs.volumes))
+	for name := range u.fs.volumes {
+		res = append(res, fuse.Dirent{
+			Type: fuse.DT_File,
+			Name: name,
+		})
+	}
+	return res, nil
+}
+
+// UnlockFile takes the passphrase of a volume. The passphrase is
+// checked when the file is closed, and a wrong passphrase is reported
+// as EACCES from close(2). Only volumes with a descriptor can detect
+// a wrong passphrase.
+type UnlockFile struct {
+	fs     *PlopFS
+	volume *servedVolume
+}
+
+var _ = fs.Node(&UnlockFile{})
+
+func (u *UnlockFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Valid = refValid
+	u.fs.setOwner(a)
+	a.Mode = 0o200
+	return nil
+}
+
+var _ = fs.NodeOpener(&UnlockFile{})
+
+func (u *UnlockFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsWriteOnly() {
+		return nil, syscall.EACCES
+	}
+	resp.Flags |= fuse.OpenDirectIO
+	h := &unlockHandle{
+		fs:     u.fs,
+		volume: u.volume,
+	}
+	return h, nil
+}
+
+var _ = fs.NodeSetattrer(&UnlockFile{})
+
+// Setattr allows truncating on open, as done by shell redirection.
+func (u *UnlockFile) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	if req.Valid.Size() && req.Size != 0 {
+		return syscall.EPERM
+	}
+	return nil
+}
+
+type unlockHandle struct {
+	fs     *PlopFS
+	volume *servedVolume
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+var _ = fs.HandleWriter(&unlockHandle{})
+
+func (h *unlockHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if req.Offset != int64(len(h.buf)) {
+		return syscall.ESPIPE
+	}
+	if len(h.buf)+len(req.Data) > maxPassphraseSize {
+		return syscall.EFBIG
+	}
+	h.buf = append(h.buf, req.Data...)
+	resp.Size = len(req.Data)
+	return nil
+}
+
+var _ = fs.HandleFlusher(&unlockHandle{})
+
+func (h *unlockHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.buf) == 0 {
+		return nil
+	}
+	passphrase := string(bytes.TrimSuffix(h.buf, []byte("\n")))
+	h.buf = nil
+	if passphrase == "" {
+		return syscall.EINVAL
+	}
+	err := h.volume.unlock(ctx, h.fs, passphrase)
+	switch {
+	case errors.Is(err, cas.ErrWrongPassphrase):
+		return syscall.EACCES
+	case errors.Is(err, errVolumeRetired):
+		return syscall.ENOENT
+	case err != nil:
+		log.Printf("cannot unlock volume %q: %v", h.volume.name, err)
+		return syscall.EIO
+	}
+	return nil
+}
@@ -3,44 +3,199 @@ package plopfs
 import (
 	"context"
 	"errors"
-	"os"
+	"log"
 	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
 	"bazil.org/plop/cas"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Volume is the directory of a volume. It serves whatever the
+// current configuration of the volume is, as the filesystem
+// configuration can be reloaded while mounted.
 type Volume struct {
-	fs    *PlopFS
-	store *cas.Store
+	fs   *PlopFS
+	name string
+}
+
+// open returns the current state of the volume, opening it if
+// needed. Errors are reported as ENOENT if the volume is no longer
+// served, EACCES if it is locked, and as for other requests
+// otherwise.
+func (v *Volume) open(ctx context.Context) (*openVolume, error) {
+	var ov *openVolume
+	open := func(ctx context.Context) error {
+		var err error
+		ov, err = v.fs.open(ctx, v.name)
+		switch {
+		case errors.Is(err, errVolumeRetired):
+			return syscall.ENOENT
+		case errors.Is(err, errVolumeLocked):
+			return syscall.EACCES
+		}
+		return err
+	}
+	if err := v.fs.request(ctx, "volume "+v.name, open); err != nil {
+		return nil, err
+	}
+	return ov, nil
 }
 
 var _ = fs.Node(&Volume{})
 
 func (v *Volume) Attr(ctx context.Context, a *fuse.Attr) error {
 	a.Valid = forever
-	a.Mode = os.ModeDir | 0o555
+	v.fs.dirAttr(a)
 	return nil
 }
 
 var _ = fs.NodeRequestLookuper(&Volume{})
 
-func (v *Volume) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
-	h, err := v.store.Open(ctx, req.Name)
+func (v *Volume) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (_ fs.Node, err error) {
+	ctx, span := tracer.Start(ctx, "plopfs.Volume.Lookup", trace.WithAttributes(
+		attribute.String("plop.volume", v.name),
+		attribute.String("plop.name", req.Name),
+	))
+	defer func() { endSpan(span, err) }()
+	ov, err := v.open(ctx)
 	if err != nil {
-		if errors.Is(err, cas.ErrBadKey) {
-			return nil, syscall.ENOENT
-		}
-		if errors.Is(err, cas.ErrNotExist) {
-			return nil, syscall.ENOENT
+		return nil, err
+	}
+	if req.Name == refsDirName {
+		n := &Refs{
+			fs:     v.fs,
+			volume: ov.name,
+			store:  ov.store,
 		}
+		resp.EntryValid = refValid
+		return n, nil
+	}
+	if req.Name == incomingDirName && ov.incoming != nil {
+		// the directory goes away if disabled by a config reload
+		resp.EntryValid = refValid
+		return ov.incoming, nil
+	}
+
+	key, suffix, isSidecar := splitSidecar(req.Name)
+	if !isSidecar {
+		key = req.Name
+	}
+	var h *cas.Handle
+	open := func(ctx context.Context) error {
+		var err error
+		h, err = ov.store.Open(ctx, key)
+		return err
+	}
+	if err := v.fs.request(ctx, "object "+key, open); err != nil {
 		return nil, err
 	}
 
+	if isSidecar {
+		return v.lookupSidecar(ctx, ov, key, suffix, h, resp)
+	}
+
 	n := &File{
+		fs:     v.fs,
+		volume: ov,
+		key:    key,
 		handle: h,
 	}
 	resp.EntryValid = forever
 	return n, nil
 }
+
+func (v *Volume) lookupSidecar(ctx context.Context, ov *openVolume, key string, suffix string, h *cas.Handle, resp *fuse.LookupResponse) (fs.Node, error) {
+	switch suffix {
+	case sidecarExtents:
+		var data []byte
+		generate := func(ctx context.Context) error {
+			var err error
+			data, err = extentsSidecar(ctx, h)
+			return err
+		}
+		if err := v.fs.request(ctx, "object "+key, generate); err != nil {
+			return nil, err
+		}
+		n := &Sidecar{
+			fs:    v.fs,
+			data:  data,
+			valid: forever,
+		}
+		resp.EntryValid = forever
+		return n, nil
+
+	case sidecarJSON:
+		var data []byte
+		generate := func(ctx context.Context) error {
+			var err error
+			data, err = jsonSidecar(ctx, ov.store, key, h)
+			return err
+		}
+		if err := v.fs.request(ctx, "object "+key, generate); err != nil {
+			return nil, err
+		}
+		n := &Sidecar{
+			fs:    v.fs,
+			data:  data,
+			valid: sidecarJSONValid,
+		}
+		resp.EntryValid = sidecarJSONValid
+		return n, nil
+	}
+	return nil, syscall.ENOENT
+}
+
+var _ fs.HandleReadDirAller = (*Volume)(nil)
+
+// ReadDirAll lists the objects recorded in the catalog. Objects
+// created elsewhere can still be looked up by key. Synced catalogs are
+// refreshed from the volume at most every catalogRefreshInterval.
+func (v *Volume) ReadDirAll(ctx context.Context) (_ []fuse.Dirent, err error) {
+	ctx, span := tracer.Start(ctx, "plopfs.Volume.ReadDirAll", trace.WithAttributes(
+		attribute.String("plop.volume", v.name),
+	))
+	defer func() { endSpan(span, err) }()
+	ov, err := v.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := []fuse.Dirent{
+		{Type: fuse.DT_Dir, Name: refsDirName},
+	}
+	if ov.incoming != nil {
+		res = append(res, fuse.Dirent{Type: fuse.DT_Dir, Name: incomingDirName})
+	}
+	if ov.catalog == nil {
+		return res, nil
+	}
+	if ov.catalog.refreshDue(time.Now()) {
+		ctx, cancel := v.fs.withTimeout(ctx)
+		err := ov.catalog.catalog.Refresh(ctx, ov.store)
+		cancel()
+		ov.catalog.refreshDone(time.Now())
+		if err != nil {
+			// keep serving the local catalog
+			log.Printf("cannot refresh catalog: %v", err)
+		}
+	}
+	entries, err := ov.catalog.catalog.Entries()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		if _, ok := seen[entry.Key]; ok {
+			continue
+		}
+		seen[entry.Key] = struct{}{}
+		res = append(res, fuse.Dirent{
+			Type: fuse.DT_File,
+			Name: entry.Key,
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,114 @@
+package plopfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/plop/internal/config"
+)
+
+func TestCatalogRefreshDue(t *testing.T) {
+	c := &volumeCatalog{sync: true}
+	now := time.Now()
+	if !c.refreshDue(now) {
+		t.Fatal("first listing must refresh")
+	}
+	if c.refreshDue(now) {
+		t.Error("refresh in progress must not start another")
+	}
+	c.refreshDone(now)
+	if c.refreshDue(now.Add(catalogRefreshInterval / 2)) {
+		t.Error("refreshed too soon")
+	}
+	if !c.refreshDue(now.Add(catalogRefreshInterval)) {
+		t.Error("stale catalog not refreshed")
+	}
+
+	local := &volumeCatalog{}
+	if local.refreshDue(now) {
+		t.Error("catalog without sync must not refresh")
+	}
+}
+
+func TestUpdateDuringSlowOpen(t *testing.T) {
+	tmp := t.TempDir()
+	fifo := filepath.Join(tmp, "passphrase")
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	text := fmt.Sprintf(`mountpoint = "/does-not-exist"
+volume "one" {
+  passphrase_command = "cat %s"
+  bucket {
+    url = "file://%s"
+  }
+}
+`, fifo, tmp)
+	parse := func() *config.Config {
+		t.Helper()
+		cfg, err := config.ParseConfig("<test literal>.hcl", []byte(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+	filesys, err := New(parse())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := filesys.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	sv, ok := filesys.served("one")
+	if !ok {
+		t.Fatal("volume not served")
+	}
+
+	ctx := context.Background()
+	opened := make(chan error, 1)
+	go func() {
+		_, err := filesys.open(ctx, "one")
+		opened <- err
+	}()
+	// the passphrase command blocks until the fifo is written
+	for {
+		sv.mu.Lock()
+		opening := sv.opening != nil
+		sv.mu.Unlock()
+		if opening {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	updated := make(chan error, 1)
+	go func() {
+		updated <- filesys.Update(parse())
+		_ = filesys.mountOptions()
+	}()
+	select {
+	case err := <-updated:
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Update blocked by open in progress")
+	}
+
+	if err := os.WriteFile(fifo, []byte("s3kr1t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-opened; err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if again, ok := filesys.served("one"); !ok || again != sv {
+		t.Error("volume was not kept")
+	}
+}
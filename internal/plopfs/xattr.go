@@ -0,0 +1,173 @@
+package plopfs
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+)
+
+// Extended attributes exposing object metadata.
+const (
+	xattrKey      = "user.plop.key"
+	xattrVolume   = "user.plop.volume"
+	xattrBoxedKey = "user.plop.boxed_key"
+	xattrExtents  = "user.plop.extents"
+	// Metadata recorded in the volume catalog, if any.
+	xattrSource      = "user.plop.source"
+	xattrLabelPrefix = "user.plop.label."
+
+	// xattrPrefix is common to all the attributes above.
+	xattrPrefix = "user.plop."
+)
+
+// isPlopXattr reports whether name could be one of our attributes.
+// Tools such as ls probe for security and ACL attributes on every
+// file; those are answered without any work.
+func isPlopXattr(name string) bool {
+	return strings.HasPrefix(name, xattrPrefix)
+}
+
+// getxattr responds with the named attribute from attrs.
+func getxattr(attrs map[string]string, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	value, ok := attrs[req.Name]
+	if !ok {
+		return fuse.ErrNoXattr
+	}
+	resp.Xattr = []byte(value)
+	return nil
+}
+
+// listxattr responds with the names in attrs.
+func listxattr(attrs map[string]string, resp *fuse.ListxattrResponse) {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	resp.Append(names...)
+}
+
+// xattrs returns the attributes of the file. They are computed once
+// per node, as reading the catalog is expensive; catalog changes show
+// up once the kernel forgets the node.
+func (f *File) xattrs(ctx context.Context) (map[string]string, error) {
+	f.xattrMu.Lock()
+	defer f.xattrMu.Unlock()
+	if f.xattrCache != nil {
+		return f.xattrCache, nil
+	}
+	attrs, err := f.computeXattrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f.xattrCache = attrs
+	return attrs, nil
+}
+
+func (f *File) computeXattrs(ctx context.Context) (map[string]string, error) {
+	boxedKey, err := f.volume.store.DebugBoxKey(f.key)
+	if err != nil {
+		return nil, err
+	}
+	attrs := map[string]string{
+		xattrKey:      f.key,
+		xattrVolume:   f.volume.name,
+		xattrBoxedKey: boxedKey,
+		xattrExtents:  strconv.Itoa(f.handle.NumExtents()),
+	}
+	if c := f.volume.catalog; c != nil {
+		entries, err := c.catalog.Entries()
+		if err != nil {
+			// the object metadata is still useful
+			log.Printf("cannot read catalog: %v", err)
+			return attrs, nil
+		}
+		// the most recent entry wins
+		for _, entry := range entries {
+			if entry.Key != f.key {
+				continue
+			}
+			if entry.Source != "" {
+				attrs[xattrSource] = entry.Source
+			}
+			for k, v := range entry.Labels {
+				attrs[xattrLabelPrefix+k] = v
+			}
+		}
+	}
+	return attrs, nil
+}
+
+var _ = fs.NodeGetxattrer(&File{})
+
+func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	if !isPlopXattr(req.Name) {
+		return fuse.ErrNoXattr
+	}
+	attrs, err := f.xattrs(ctx)
+	if err != nil {
+		return err
+	}
+	return getxattr(attrs, req, resp)
+}
+
+var _ = fs.NodeListxattrer(&File{})
+
+func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	attrs, err := f.xattrs(ctx)
+	if err != nil {
+		return err
+	}
+	listxattr(attrs, resp)
+	return nil
+}
+
+// xattrs returns the attributes of the object the link points to,
+// so a writer can learn the key it stored without following the link.
+// Linux only lets user attributes be listed on symlinks; reading them
+// fails in the kernel, before reaching us.
+func (l *RefLink) xattrs() map[string]string {
+	attrs := map[string]string{
+		xattrKey:    l.key,
+		xattrVolume: l.volume,
+	}
+	return attrs
+}
+
+var _ = fs.NodeGetxattrer(&RefLink{})
+
+func (l *RefLink) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return getxattr(l.xattrs(), req, resp)
+}
+
+var _ = fs.NodeListxattrer(&RefLink{})
+
+func (l *RefLink) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	listxattr(l.xattrs(), resp)
+	return nil
+}
+
+func (v *Volume) xattrs() map[string]string {
+	attrs := map[string]string{
+		xattrVolume: v.name,
+	}
+	return attrs
+}
+
+var _ = fs.NodeGetxattrer(&Volume{})
+
+func (v *Volume) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return getxattr(v.xattrs(), req, resp)
+}
+
+var _ = fs.NodeListxattrer(&Volume{})
+
+func (v *Volume) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	listxattr(v.xattrs(), resp)
+	return nil
+}
@@ -0,0 +1,80 @@
+package plopfs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"bazil.org/fuse"
+	"bazil.org/plop/cas"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestGetxattrOther(t *testing.T) {
+	// no volume, so any work would crash
+	f := &File{key: "xyzzy"}
+	for _, name := range []string{"security.selinux", "system.posix_acl_access", "user.other"} {
+		req := &fuse.GetxattrRequest{Name: name}
+		resp := &fuse.GetxattrResponse{}
+		if err := f.Getxattr(context.Background(), req, resp); !errors.Is(err, fuse.ErrNoXattr) {
+			t.Errorf("%s: expected ErrNoXattr, got %v", name, err)
+		}
+	}
+}
+
+func TestXattrsCached(t *testing.T) {
+	ctx := context.Background()
+	store := cas.NewStore("s3kr1t", cas.WithBucket(memblob.OpenBucket(nil)))
+	key, err := store.Create(ctx, strings.NewReader("hello, world\n"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	h, err := store.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	f := &File{
+		volume: &openVolume{name: "testvolume", store: store},
+		key:    key,
+		handle: h,
+	}
+	first, err := f.xattrs(ctx)
+	if err != nil {
+		t.Fatalf("xattrs: %v", err)
+	}
+	if g, e := first[xattrKey], key; g != e {
+		t.Errorf("wrong key attribute: %q != %q", g, e)
+	}
+	second, err := f.xattrs(ctx)
+	if err != nil {
+		t.Fatalf("xattrs: %v", err)
+	}
+	if reflect.ValueOf(first).Pointer() != reflect.ValueOf(second).Pointer() {
+		t.Error("attributes were computed again")
+	}
+}
+
+func TestRefLinkXattrs(t *testing.T) {
+	ctx := context.Background()
+	l := &RefLink{volume: "testvolume", key: "xyzzy"}
+	for name, want := range map[string]string{
+		xattrKey:    "xyzzy",
+		xattrVolume: "testvolume",
+	} {
+		req := &fuse.GetxattrRequest{Name: name}
+		resp := &fuse.GetxattrResponse{}
+		if err := l.Getxattr(ctx, req, resp); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if g, e := string(resp.Xattr), want; g != e {
+			t.Errorf("%s: wrong value: %q != %q", name, g, e)
+		}
+	}
+	req := &fuse.GetxattrRequest{Name: xattrBoxedKey}
+	if err := l.Getxattr(ctx, req, &fuse.GetxattrResponse{}); !errors.Is(err, fuse.ErrNoXattr) {
+		t.Errorf("expected ErrNoXattr, got %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+// Package ratelimit limits the rate of requests or bytes with token
+// buckets, serving waiters of higher priority first.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Priority orders waiters. Waiters of a higher priority go first;
+// waiters of the same priority are served in order.
+type Priority int
+
+const (
+	// Background is for work nobody is waiting on, such as storing
+	// files written to a mount.
+	Background Priority = iota
+	// Normal is the default priority.
+	Normal
+	// Interactive is for requests someone is waiting on, such as
+	// reads from a mount.
+	Interactive
+
+	numPriorities = int(Interactive) + 1
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context that makes waits on limiters use
+// priority p.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFrom returns the priority set in ctx, or Normal.
+func PriorityFrom(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok && p >= Background && p <= Interactive {
+		return p
+	}
+	return Normal
+}
+
+// Limiter is a token bucket. Tokens are added at a constant rate, up
+// to a burst size. It is safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	queues [numPriorities][]*waiter
+	// changed is closed and replaced when the waiters change.
+	changed chan struct{}
+}
+
+type waiter struct {
+	n float64
+}
+
+// New returns a limiter that allows rate tokens per second, with
+// bursts of up to burst tokens. The limiter starts full.
+func New(rate float64, burst float64) *Limiter {
+	l := &Limiter{
+		rate:    rate,
+		burst:   burst,
+		tokens:  burst,
+		last:    time.Now(),
+		changed: make(chan struct{}),
+	}
+	return l
+}
+
+// SetRate changes the rate and burst of the limiter.
+func (l *Limiter) SetRate(rate float64, burst float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = rate
+	l.burst = burst
+	if l.tokens > burst {
+		l.tokens = burst
+	}
+	l.notify()
+}
+
+func (l *Limiter) refill(now time.Time) {
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+}
+
+func (l *Limiter) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// first returns the waiter to serve next.
+func (l *Limiter) first() *waiter {
+	for p := numPriorities - 1; p >= 0; p-- {
+		if q := l.queues[p]; len(q) > 0 {
+			return q[0]
+		}
+	}
+	return nil
+}
+
+func (l *Limiter) remove(p Priority, w *waiter) {
+	q := l.queues[p]
+	for i, other := range q {
+		if other == w {
+			l.queues[p] = append(q[:i:i], q[i+1:]...)
+			break
+		}
+	}
+	l.notify()
+}
+
+// Wait takes n tokens, waiting until they are available and all
+// waiters before it have been served. The priority of the wait comes
+// from ctx, see WithPriority.
+//
+// Taking more tokens than the burst size is allowed once the bucket
+// is full; the tokens are then owed, and later waiters wait longer.
+func (l *Limiter) Wait(ctx context.Context, n int64) error {
+	p := PriorityFrom(ctx)
+	w := &waiter{n: float64(n)}
+	l.mu.Lock()
+	l.queues[p] = append(l.queues[p], w)
+	for {
+		now := time.Now()
+		l.refill(now)
+		need := w.n
+		if need > l.burst {
+			need = l.burst
+		}
+		var delay time.Duration
+		if l.first() == w {
+			if l.tokens >= need {
+				l.tokens -= w.n
+				l.remove(p, w)
+				l.mu.Unlock()
+				return nil
+			}
+			delay = time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+			if delay <= 0 {
+				// rounding; do not wait for a change that may never come
+				delay = time.Microsecond
+			}
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if delay > 0 {
+			timer = time.NewTimer(delay)
+			expired = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			l.mu.Lock()
+			l.remove(p, w)
+			l.mu.Unlock()
+			return ctx.Err()
+		case <-changed:
+		case <-expired:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		l.mu.Lock()
+	}
+}
@@ -0,0 +1,89 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"bazil.org/plop/internal/ratelimit"
+)
+
+func TestWait(t *testing.T) {
+	ctx := context.Background()
+	l := ratelimit.New(1000, 100)
+	start := time.Now()
+	// the burst is free, then 200 tokens at 1000/s
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(ctx, 100); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("did not wait long enough: %v", elapsed)
+	}
+}
+
+func TestWaitLarge(t *testing.T) {
+	ctx := context.Background()
+	l := ratelimit.New(1000, 10)
+	// more than the burst goes through, and is owed
+	if err := l.Wait(ctx, 100); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	start := time.Now()
+	if err := l.Wait(ctx, 1); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("debt was not paid: %v", elapsed)
+	}
+}
+
+func TestWaitCanceled(t *testing.T) {
+	l := ratelimit.New(1, 1)
+	ctx := context.Background()
+	if err := l.Wait(ctx, 1); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(timeoutCtx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout: %v", err)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	ctx := context.Background()
+	l := ratelimit.New(100, 1)
+	// empty the bucket, so the next waiters queue up
+	if err := l.Wait(ctx, 1); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	var mu sync.Mutex
+	var order []ratelimit.Priority
+	var wg sync.WaitGroup
+	wait := func(p ratelimit.Priority) {
+		defer wg.Done()
+		if err := l.Wait(ratelimit.WithPriority(ctx, p), 1); err != nil {
+			t.Errorf("Wait: %v", err)
+			return
+		}
+		mu.Lock()
+		order = append(order, p)
+		mu.Unlock()
+	}
+	wg.Add(1)
+	go wait(ratelimit.Background)
+	// let the background waiter queue first
+	time.Sleep(2 * time.Millisecond)
+	wg.Add(1)
+	go wait(ratelimit.Interactive)
+	wg.Wait()
+
+	if len(order) != 2 || order[0] != ratelimit.Interactive {
+		t.Errorf("interactive did not go first: %v", order)
+	}
+}
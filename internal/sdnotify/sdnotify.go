@@ -0,0 +1,42 @@
+// Package sdnotify implements the systemd service readiness
+// notification protocol.
+//
+// See sd_notify(3).
+package sdnotify
+
+import (
+	"net"
+	"os"
+)
+
+// Common states to notify.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+)
+
+// Notify sends the state to the service manager, if the process was
+// started with a notification socket. It is a no-op otherwise.
+func Notify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+	if name[0] == '@' {
+		// abstract namespace
+		name = "\x00" + name[1:]
+	}
+	addr := &net.UnixAddr{
+		Name: name,
+		Net:  "unixgram",
+	}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return err
+	}
+	return conn.Close()
+}
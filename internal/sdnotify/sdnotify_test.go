@@ -0,0 +1,38 @@
+package sdnotify_test
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"bazil.org/plop/internal/sdnotify"
+)
+
+func TestNotify(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: p, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", p)
+
+	if err := sdnotify.Notify(sdnotify.Ready); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	buf := make([]byte, 100)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if g, e := string(buf[:n]), "READY=1"; g != e {
+		t.Errorf("wrong message: %q != %q", g, e)
+	}
+}
+
+func TestNotifyNoSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdnotify.Notify(sdnotify.Ready); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+// Package tracing exports OpenTelemetry spans as configured in the
+// tracing block of the config.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"bazil.org/plop/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Start installs a global tracer provider that exports spans as
+// configured. With opts nil, it does nothing. The returned function
+// flushes any buffered spans and stops exporting.
+func Start(ctx context.Context, opts *config.TracingOptions) (shutdown func(context.Context) error, err error) {
+	if opts == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	switch {
+	case opts.File != "":
+		f, err := os.OpenFile(opts.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: %w", err)
+		}
+		e, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("tracing: %w", err)
+		}
+		exporter = e
+		file = f
+	default:
+		httpOpts := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(opts.OTLPEndpoint),
+		}
+		if opts.OTLPInsecure {
+			httpOpts = append(httpOpts, otlptracehttp.WithInsecure())
+		}
+		e, err := otlptracehttp.New(ctx, httpOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: %w", err)
+		}
+		exporter = e
+	}
+
+	res := resource.NewSchemaless(
+		attribute.String("service.name", "plop"),
+	)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	shutdown = func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("tracing: %w", err)
+		}
+		return nil
+	}
+	return shutdown, nil
+}
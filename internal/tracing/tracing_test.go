@@ -0,0 +1,48 @@
+package tracing_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bazil.org/plop/internal/config"
+	"bazil.org/plop/internal/tracing"
+	"go.opentelemetry.io/otel"
+)
+
+func TestNotConfigured(t *testing.T) {
+	shutdown, err := tracing.Start(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	opts := &config.TracingOptions{
+		File:        path,
+		SampleRatio: 1,
+	}
+	ctx := context.Background()
+	shutdown, err := tracing.Start(ctx, opts)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	_, span := otel.Tracer("test").Start(ctx, "test-span")
+	span.End()
+	if err := shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(buf), `"Name":"test-span"`) {
+		t.Errorf("span not exported: %s", buf)
+	}
+}
@@ -0,0 +1,146 @@
+package plop_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bazil.org/plop"
+	_ "gocloud.dev/blob/fileblob"
+)
+
+const testConfig = `
+mountpoint = "/does-not-exist"
+default_volume = "testvolume"
+volume "testvolume" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = "file://%[1]s/bucket"
+  }
+}
+volume "prompt" {
+  bucket {
+    url = "file://%[1]s/bucket"
+  }
+}
+`
+
+func writeTestConfig(t testing.TB) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "bucket"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, "config.hcl")
+	src := fmt.Sprintf(testConfig, dir)
+	if err := os.WriteFile(p, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRoundtrip(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := plop.ReadConfig(writeTestConfig(t))
+	if err != nil {
+		t.Fatalf("ReadConfig: %v", err)
+	}
+	if g, e := strings.Join(cfg.Volumes(), ","), "testvolume,prompt"; g != e {
+		t.Errorf("wrong volumes: %q != %q", g, e)
+	}
+	store, err := cfg.OpenVolume(ctx, "")
+	if err != nil {
+		t.Fatalf("OpenVolume: %v", err)
+	}
+	defer store.Close()
+	if g, e := store.Name(), "testvolume"; g != e {
+		t.Errorf("wrong volume: %q != %q", g, e)
+	}
+	const greeting = "hello, world\n"
+	key, err := store.Create(ctx, strings.NewReader(greeting))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	h, err := store.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	buf, err := io.ReadAll(h.IO(ctx))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if g, e := string(buf), greeting; g != e {
+		t.Errorf("wrong content: %q != %q", g, e)
+	}
+	if err := store.SyncCatalog(ctx); err != nil {
+		t.Errorf("SyncCatalog: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	// closing again is harmless
+	if err := store.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestOpenVolumeErrors(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := plop.ReadConfig(writeTestConfig(t))
+	if err != nil {
+		t.Fatalf("ReadConfig: %v", err)
+	}
+	if _, err := cfg.OpenVolume(ctx, "nope"); !errors.Is(err, plop.ErrNoVolume) {
+		t.Errorf("expected no volume error: %v", err)
+	}
+	if _, err := cfg.OpenVolume(ctx, "prompt"); !errors.Is(err, plop.ErrNoPassphrase) {
+		t.Errorf("expected no passphrase error: %v", err)
+	}
+	store, err := cfg.OpenVolumeWithPassphrase(ctx, "prompt", "s3kr1t")
+	if err != nil {
+		t.Fatalf("OpenVolumeWithPassphrase: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestLocalConfigOptional(t *testing.T) {
+	p := writeTestConfig(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".plop.hcl"), []byte(`default_volume = "prompt"`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Chdir(wd); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	cfg, err := plop.ReadConfig(p)
+	if err != nil {
+		t.Fatalf("ReadConfig: %v", err)
+	}
+	if g, e := cfg.DefaultVolume(), "testvolume"; g != e {
+		t.Errorf("local config used without asking: %q != %q", g, e)
+	}
+	cfg, err = plop.ReadConfig(p, plop.WithLocalConfig())
+	if err != nil {
+		t.Fatalf("ReadConfig: %v", err)
+	}
+	if g, e := cfg.DefaultVolume(), "prompt"; g != e {
+		t.Errorf("local config not used: %q != %q", g, e)
+	}
+}
@@ -0,0 +1,528 @@
+// Package plopblob provides a gocloud.dev/blob driver that stores
+// blobs in a plop volume. Content is chunked, deduplicated and
+// encrypted like any other object in the volume.
+//
+// Blob names are kept in an index of refs named "blob:NAME", each
+// pointing to a small descriptor object that holds the content key
+// and the attributes of the blob. Like all refs, the index is
+// encrypted and stored in the buckets of the volume.
+//
+// # URLs
+//
+// For blob.OpenBucket, plopblob registers for the scheme "plop". The
+// URL host is the name of the volume, or empty for the default
+// volume. The query parameter "config" sets the path of the config
+// file, and defaults to the config of the plop command:
+//
+//	plop://VOLUME?config=/path/to/config.hcl
+//
+// Volumes opened by URL read the passphrase from the configured
+// source, and are closed with the bucket.
+//
+// # Limitations
+//
+// Listing reads the whole index, and the descriptor of every blob
+// returned. SignedURL is not supported.
+package plopblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"bazil.org/plop"
+	"bazil.org/plop/cas"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+)
+
+// Scheme is the URL scheme plopblob registers its URLOpener under on
+// blob.DefaultURLMux.
+const Scheme = "plop"
+
+func init() {
+	blob.DefaultURLMux().RegisterBucket(Scheme, &URLOpener{})
+}
+
+// URLOpener opens plop volumes as buckets, for URLs like
+// "plop://VOLUME".
+type URLOpener struct{}
+
+// OpenBucketURL opens the volume named by the URL host.
+func (o *URLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
+	path := u.Query().Get("config")
+	for param := range u.Query() {
+		if param != "config" {
+			return nil, fmt.Errorf("open bucket %v: invalid query parameter %q", u, param)
+		}
+	}
+	if path == "" {
+		p, err := plop.DefaultConfigPath()
+		if err != nil {
+			return nil, fmt.Errorf("open bucket %v: %w", u, err)
+		}
+		path = p
+	}
+	cfg, err := plop.ReadConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("open bucket %v: %w", u, err)
+	}
+	store, err := cfg.OpenVolume(ctx, u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("open bucket %v: %w", u, err)
+	}
+	b := &bucket{
+		store:  store.Store,
+		closer: store,
+	}
+	return blob.NewBucket(b), nil
+}
+
+// OpenBucket returns a bucket that stores blobs in the given store.
+// The caller remains responsible for closing the store, after the
+// bucket is no longer used.
+func OpenBucket(store *cas.Store) *blob.Bucket {
+	b := &bucket{
+		store: store,
+	}
+	return blob.NewBucket(b)
+}
+
+const (
+	refPrefix = "blob:"
+
+	defaultPageSize = 1000
+)
+
+var errNotFound = errors.New("blob not found")
+
+// refName returns the name of the index ref for a blob name. Ref
+// names cannot contain NUL bytes, so those are escaped.
+func refName(key string) string {
+	r := strings.NewReplacer("%", "%25", "\x00", "%00")
+	return refPrefix + r.Replace(key)
+}
+
+// blobName is the inverse of refName. It reports false for refs that
+// are not in the index.
+func blobName(name string) (string, bool) {
+	escaped, ok := strings.CutPrefix(name, refPrefix)
+	if !ok {
+		return "", false
+	}
+	r := strings.NewReplacer("%25", "%", "%00", "\x00")
+	return r.Replace(escaped), true
+}
+
+// descriptor is the content of the object an index ref points to.
+type descriptor struct {
+	Key                string            `json:"key"`
+	Size               int64             `json:"size"`
+	MD5                []byte            `json:"md5,omitempty"`
+	ContentType        string            `json:"content_type,omitempty"`
+	CacheControl       string            `json:"cache_control,omitempty"`
+	ContentDisposition string            `json:"content_disposition,omitempty"`
+	ContentEncoding    string            `json:"content_encoding,omitempty"`
+	ContentLanguage    string            `json:"content_language,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	Created            time.Time         `json:"created"`
+}
+
+type bucket struct {
+	store *cas.Store
+	// closer is set if the bucket owns the store.
+	closer io.Closer
+}
+
+var _ driver.Bucket = (*bucket)(nil)
+
+// lookup returns the index ref for the blob.
+func (b *bucket) lookup(ctx context.Context, key string) (*cas.Ref, error) {
+	ref, err := b.store.GetRef(ctx, refName(key))
+	if errors.Is(err, cas.ErrRefNotExist) {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ref.Deleted() {
+		return nil, errNotFound
+	}
+	return ref, nil
+}
+
+// readDescriptor reads the descriptor object with the given key.
+func (b *bucket) readDescriptor(ctx context.Context, key string) (*descriptor, error) {
+	h, err := b.store.Open(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("blob descriptor: %w", err)
+	}
+	buf := make([]byte, h.Size())
+	if _, err := h.IO(ctx).ReadAt(buf, 0); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("blob descriptor: %w", err)
+	}
+	var desc descriptor
+	if err := json.Unmarshal(buf, &desc); err != nil {
+		return nil, fmt.Errorf("blob descriptor: %w", err)
+	}
+	return &desc, nil
+}
+
+// stat returns the index ref and the descriptor of the blob.
+func (b *bucket) stat(ctx context.Context, key string) (*cas.Ref, *descriptor, error) {
+	ref, err := b.lookup(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	desc, err := b.readDescriptor(ctx, ref.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ref, desc, nil
+}
+
+// setRef points the index entry for the blob at the descriptor,
+// replacing any previous version of the blob.
+func (b *bucket) setRef(ctx context.Context, key string, descKey string) error {
+	name := refName(key)
+	for {
+		var prevSeq uint64
+		ref, err := b.store.GetRef(ctx, name)
+		switch {
+		case errors.Is(err, cas.ErrRefNotExist):
+		case err != nil:
+			return err
+		default:
+			prevSeq = ref.Seq
+		}
+		_, err = b.store.SetRef(ctx, name, descKey, prevSeq)
+		if errors.Is(err, cas.ErrRefConflict) {
+			// last writer wins, like other blob stores
+			continue
+		}
+		return err
+	}
+}
+
+func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	switch {
+	case errors.Is(err, errNotFound):
+		return gcerrors.NotFound
+	case errors.Is(err, cas.ErrBadRefName):
+		return gcerrors.InvalidArgument
+	case errors.Is(err, errNotImplemented):
+		return gcerrors.Unimplemented
+	default:
+		return gcerrors.Unknown
+	}
+}
+
+func (b *bucket) As(i interface{}) bool { return false }
+
+func (b *bucket) ErrorAs(err error, i interface{}) bool { return false }
+
+func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	ref, desc, err := b.stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	attrs := &driver.Attributes{
+		CacheControl:       desc.CacheControl,
+		ContentDisposition: desc.ContentDisposition,
+		ContentEncoding:    desc.ContentEncoding,
+		ContentLanguage:    desc.ContentLanguage,
+		ContentType:        desc.ContentType,
+		Metadata:           desc.Metadata,
+		CreateTime:         desc.Created,
+		ModTime:            ref.Time,
+		Size:               desc.Size,
+		MD5:                desc.MD5,
+		ETag:               `"` + ref.Key + `"`,
+	}
+	return attrs, nil
+}
+
+func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	if opts.BeforeList != nil {
+		if err := opts.BeforeList(func(interface{}) bool { return false }); err != nil {
+			return nil, err
+		}
+	}
+	refs, err := b.store.ListRefs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	type entry struct {
+		key string
+		ref *cas.Ref
+	}
+	var entries []entry
+	for _, ref := range refs {
+		key, ok := blobName(ref.Name)
+		if !ok || !strings.HasPrefix(key, opts.Prefix) {
+			continue
+		}
+		entries = append(entries, entry{key: key, ref: ref})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	pageToken := string(opts.PageToken)
+	var page driver.ListPage
+	// refs of the blobs in page.Objects, nil for directories
+	var pageRefs []*cas.Ref
+	var lastDir string
+	for _, e := range entries {
+		obj := &driver.ListObject{Key: e.key}
+		ref := e.ref
+		if opts.Delimiter != "" {
+			rest := e.key[len(opts.Prefix):]
+			if idx := strings.Index(rest, opts.Delimiter); idx >= 0 {
+				dir := opts.Prefix + rest[:idx+len(opts.Delimiter)]
+				if dir == lastDir {
+					continue
+				}
+				lastDir = dir
+				obj = &driver.ListObject{Key: dir, IsDir: true}
+				ref = nil
+			}
+		}
+		if pageToken != "" && obj.Key <= pageToken {
+			continue
+		}
+		if len(page.Objects) == pageSize {
+			page.NextPageToken = []byte(page.Objects[pageSize-1].Key)
+			break
+		}
+		page.Objects = append(page.Objects, obj)
+		pageRefs = append(pageRefs, ref)
+	}
+
+	for i, obj := range page.Objects {
+		ref := pageRefs[i]
+		if ref == nil {
+			continue
+		}
+		desc, err := b.readDescriptor(ctx, ref.Key)
+		if err != nil {
+			return nil, fmt.Errorf("list %q: %w", obj.Key, err)
+		}
+		obj.ModTime = ref.Time
+		obj.Size = desc.Size
+		obj.MD5 = desc.MD5
+	}
+	return &page, nil
+}
+
+func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	ref, desc, err := b.stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	h, err := b.store.Open(ctx, desc.Key)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BeforeRead != nil {
+		if err := opts.BeforeRead(func(interface{}) bool { return false }); err != nil {
+			return nil, err
+		}
+	}
+	size := h.Size()
+	if offset > size {
+		offset = size
+	}
+	n := size - offset
+	if length >= 0 && length < n {
+		n = length
+	}
+	r := &reader{
+		r: io.NewSectionReader(h.IO(ctx), offset, n),
+		attrs: driver.ReaderAttributes{
+			ContentType: desc.ContentType,
+			ModTime:     ref.Time,
+			Size:        size,
+		},
+	}
+	return r, nil
+}
+
+type reader struct {
+	r     io.Reader
+	attrs driver.ReaderAttributes
+}
+
+var _ driver.Reader = (*reader)(nil)
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r *reader) Close() error {
+	return nil
+}
+
+func (r *reader) Attributes() *driver.ReaderAttributes {
+	return &r.attrs
+}
+
+func (r *reader) As(i interface{}) bool { return false }
+
+func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	if opts.BeforeWrite != nil {
+		if err := opts.BeforeWrite(func(interface{}) bool { return false }); err != nil {
+			return nil, err
+		}
+	}
+	desc := &descriptor{
+		ContentType:        contentType,
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		Metadata:           opts.Metadata,
+	}
+	pr, pw := io.Pipe()
+	w := &writer{
+		ctx:    ctx,
+		bucket: b,
+		key:    key,
+		desc:   desc,
+		pw:     pw,
+		done:   make(chan struct{}),
+	}
+	go w.upload(pr)
+	return w, nil
+}
+
+func checkKey(key string) error {
+	// the index has no use for empty names, and neither do other
+	// blob stores
+	if key == "" {
+		return fmt.Errorf("empty blob name: %w", cas.ErrBadRefName)
+	}
+	return nil
+}
+
+type writer struct {
+	ctx    context.Context
+	bucket *bucket
+	key    string
+	desc   *descriptor
+	pw     *io.PipeWriter
+
+	// done is closed when upload returns, after setting err.
+	done chan struct{}
+	err  error
+}
+
+var _ driver.Writer = (*writer)(nil)
+
+// upload stores the content written to the pipe.
+func (w *writer) upload(pr *io.PipeReader) {
+	defer close(w.done)
+	hash := md5.New()
+	counter := &countingWriter{}
+	r := io.TeeReader(pr, io.MultiWriter(hash, counter))
+	key, err := w.bucket.store.Create(w.ctx, r, cas.CreateSource(w.key))
+	if err != nil {
+		w.err = err
+		// unblock writes
+		pr.CloseWithError(err)
+		return
+	}
+	// Create stops reading at EOF, so there is nothing left to
+	// unblock.
+	w.desc.Key = key
+	w.desc.Size = counter.n
+	w.desc.MD5 = hash.Sum(nil)
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close finishes the upload, and makes the new content visible under
+// the name. If the context was canceled, the blob is left unchanged.
+func (w *writer) Close() error {
+	_ = w.pw.Close()
+	<-w.done
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	w.desc.Created = time.Now().UTC().Truncate(time.Second)
+	buf, err := json.Marshal(w.desc)
+	if err != nil {
+		return err
+	}
+	descKey, err := w.bucket.store.Create(w.ctx, bytes.NewReader(buf), cas.CreateWithoutCatalog())
+	if err != nil {
+		return fmt.Errorf("blob descriptor: %w", err)
+	}
+	return w.bucket.setRef(w.ctx, w.key, descKey)
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	if err := checkKey(dstKey); err != nil {
+		return err
+	}
+	if opts.BeforeCopy != nil {
+		if err := opts.BeforeCopy(func(interface{}) bool { return false }); err != nil {
+			return err
+		}
+	}
+	ref, err := b.lookup(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	// the copy shares the descriptor, and thus the content
+	return b.setRef(ctx, dstKey, ref.Key)
+}
+
+func (b *bucket) Delete(ctx context.Context, key string) error {
+	ref, err := b.lookup(ctx, key)
+	if err != nil {
+		return err
+	}
+	return b.store.DeleteRef(ctx, refName(key), ref.Seq)
+}
+
+func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return "", errNotImplemented
+}
+
+var errNotImplemented = errors.New("not implemented")
+
+func (b *bucket) Close() error {
+	if b.closer == nil {
+		return nil
+	}
+	return b.closer.Close()
+}
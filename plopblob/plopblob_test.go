@@ -0,0 +1,132 @@
+package plopblob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bazil.org/plop/cas"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/blob/drivertest"
+	_ "gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/memblob"
+)
+
+type harness struct {
+	store *cas.Store
+}
+
+func newHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	store := cas.NewStore("s3kr1t", cas.WithBucket(memblob.OpenBucket(nil)))
+	h := &harness{
+		store: store,
+	}
+	return h, nil
+}
+
+func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	return &bucket{store: h.store}, nil
+}
+
+func (h *harness) MakeDriverForNonexistentBucket(ctx context.Context) (driver.Bucket, error) {
+	return nil, nil
+}
+
+func (h *harness) HTTPClient() *http.Client {
+	return nil
+}
+
+func (h *harness) Close() {}
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformanceTests(t, newHarness, nil)
+}
+
+const testConfig = `
+mountpoint = "/does-not-exist"
+volume "testvolume" {
+  passphrase = "s3kr1t"
+  bucket {
+    url = "file://%[1]s/bucket"
+  }
+}
+`
+
+func TestURL(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "bucket"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config.hcl")
+	if err := os.WriteFile(configPath, []byte(fmt.Sprintf(testConfig, dir)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	u := "plop://testvolume?config=" + url.QueryEscape(configPath)
+	b, err := blob.OpenBucket(ctx, u)
+	if err != nil {
+		t.Fatalf("OpenBucket: %v", err)
+	}
+	const name = "greeting-secret-name.txt"
+	greeting := []byte("hello, world\n")
+	if err := b.WriteAll(ctx, name, greeting, nil); err != nil {
+		t.Fatalf("WriteAll: %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// names and content are not visible in the underlying bucket
+	walk := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.Contains(path, "secret") {
+			t.Errorf("name visible in path: %s", path)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(buf, []byte("secret")) || bytes.Contains(buf, greeting) {
+			t.Errorf("plaintext visible in file: %s", path)
+		}
+		return nil
+	}
+	if err := filepath.WalkDir(filepath.Join(dir, "bucket"), walk); err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+
+	b, err = blob.OpenBucket(ctx, u)
+	if err != nil {
+		t.Fatalf("OpenBucket again: %v", err)
+	}
+	defer b.Close()
+	buf, err := b.ReadAll(ctx, name)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(buf, greeting) {
+		t.Errorf("wrong content: %q != %q", buf, greeting)
+	}
+}
+
+func TestURLBadParameter(t *testing.T) {
+	ctx := context.Background()
+	_, err := blob.OpenBucket(ctx, "plop://testvolume?bogus=1")
+	if err == nil || !strings.Contains(err.Error(), `invalid query parameter "bogus"`) {
+		t.Fatalf("expected bad parameter error: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package plop
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"bazil.org/plop/cas"
+	"bazil.org/plop/internal/catalog"
+	"bazil.org/plop/internal/config"
+	"bazil.org/plop/internal/multierr"
+	"gocloud.dev/blob"
+)
+
+// Store is an opened volume. It embeds the CAS store, and owns the
+// buckets it uses.
+type Store struct {
+	*cas.Store
+	name    string
+	buckets []*blob.Bucket
+	// catalog is nil if the volume has no catalog.
+	catalog     *catalog.Catalog
+	syncCatalog bool
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func openStore(ctx context.Context, cfg *config.Config, vol *config.Volume, passphrase string) (*Store, error) {
+	store, buckets, err := config.OpenVolumeWithPassphrase(ctx, cfg, vol, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	c, err := config.OpenCatalog(cfg, vol)
+	if err != nil {
+		for _, b := range buckets {
+			_ = b.Close()
+		}
+		return nil, err
+	}
+	s := &Store{
+		Store:   store,
+		name:    vol.Name,
+		buckets: buckets,
+		catalog: c,
+	}
+	if c != nil {
+		s.syncCatalog = vol.Catalog.Sync
+	}
+	return s, nil
+}
+
+// Name returns the name of the volume.
+func (s *Store) Name() string {
+	return s.name
+}
+
+// SyncCatalog synchronizes the local catalog with the copy stored in
+// the volume, if the volume is configured to do so.
+func (s *Store) SyncCatalog(ctx context.Context) error {
+	if s.catalog == nil || !s.syncCatalog {
+		return nil
+	}
+	if err := s.catalog.Sync(ctx, s.Store); err != nil {
+		return fmt.Errorf("cannot sync catalog: %w", err)
+	}
+	return nil
+}
+
+// Close closes the buckets of the volume. Objects opened from the
+// store cannot be read after Close.
+func (s *Store) Close() error {
+	s.closeOnce.Do(func() {
+		var errs []error
+		for i, b := range s.buckets {
+			if err := b.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("error closing bucket #%d for %q: %w", i, s.name, err))
+			}
+		}
+		if len(errs) > 0 {
+			s.closeErr = multierr.New(errs)
+		}
+	})
+	return s.closeErr
+}